@@ -9,12 +9,17 @@ import (
 	"hue-bridge-emulator/internal/adapters/output/persistence"
 	"hue-bridge-emulator/internal/domain/service"
 	"hue-bridge-emulator/internal/domain/translator"
+	"hue-bridge-emulator/internal/ports"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 func main() {
@@ -58,12 +63,49 @@ func main() {
 
 	// HA Client
 	haClient := homeassistant.NewClient()
+	var haEvents ports.HomeAssistantEventsPort = homeassistant.NewEventStream()
+	if timeout := os.Getenv("HA_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+			haClient.SetTimeout(d)
+		} else {
+			slog.Warn("Invalid HA_TIMEOUT, using default", "value", timeout, "default", homeassistant.DefaultTimeout)
+		}
+	}
+	if attempts := os.Getenv("HA_MAX_ATTEMPTS"); attempts != "" {
+		if n, err := strconv.Atoi(attempts); err == nil && n > 0 {
+			haClient.SetMaxAttempts(n)
+		} else {
+			slog.Warn("Invalid HA_MAX_ATTEMPTS, using default", "value", attempts, "default", homeassistant.DefaultMaxAttempts)
+		}
+	}
+	threshold, cooldown := homeassistant.DefaultBreakerThreshold, homeassistant.DefaultBreakerCooldown
+	if v := os.Getenv("HA_BREAKER_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			threshold = n
+		} else {
+			slog.Warn("Invalid HA_BREAKER_THRESHOLD, using default", "value", v, "default", threshold)
+		}
+	}
+	if v := os.Getenv("HA_BREAKER_COOLDOWN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cooldown = d
+		} else {
+			slog.Warn("Invalid HA_BREAKER_COOLDOWN, using default", "value", v, "default", cooldown)
+		}
+	}
+	haClient.SetBreaker(threshold, cooldown)
 
 	translatorFactory := translator.NewFactory()
-	translatorFactory.Register(model.MappingTypeLight, &translator.LightStrategy{})
+	translatorFactory.Register(model.MappingTypeLight, &translator.ColorLightStrategy{})
 	translatorFactory.Register(model.MappingTypeCover, &translator.CoverStrategy{})
 	translatorFactory.Register(model.MappingTypeClimate, &translator.ClimateStrategy{})
 	translatorFactory.Register(model.MappingTypeCustom, &translator.CustomStrategy{})
+	translatorFactory.Register(model.MappingTypeFan, &translator.FanStrategy{})
+	translatorFactory.Register(model.MappingTypeMedia, &translator.MediaPlayerStrategy{})
+	translatorFactory.Register(model.MappingTypeLock, &translator.LockStrategy{})
+	translatorFactory.Register(model.MappingTypeValve, &translator.ValveStrategy{})
+	translatorFactory.Register(model.MappingTypeGarage, &translator.GarageStrategy{})
+	translatorFactory.Register(model.MappingTypeTrigger, &translator.TriggerStrategy{})
 
 	// Load initial config if exists
 	cfg, err := configRepo.Get(context.Background())
@@ -72,6 +114,7 @@ func main() {
 	}
 	if cfg.HassURL != "" && cfg.HassToken != "" {
 		haClient.Configure(cfg.HassURL, cfg.HassToken)
+		haEvents.Configure(cfg.HassURL, cfg.HassToken)
 		slog.Info("Home Assistant configured from persisted storage")
 	} else {
 		slog.Warn("Home Assistant not configured. Please use the Web Admin interface.")
@@ -91,12 +134,66 @@ func main() {
 
 	bridgeService := service.NewBridgeService(haClient, configRepo, translatorFactory)
 	bridgeService.SetIgnoredDomains([]string{"zone.", "sun.", "weather."})
+	if size := os.Getenv("COMMAND_HISTORY_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil && n >= 0 {
+			bridgeService.SetCommandHistorySize(n)
+		} else {
+			slog.Warn("Invalid COMMAND_HISTORY_SIZE, using default", "value", size, "default", service.DefaultCommandHistorySize)
+		}
+	}
+	if window := os.Getenv("CONVERGENCE_WINDOW"); window != "" {
+		if d, err := time.ParseDuration(window); err == nil && d > 0 {
+			bridgeService.SetConvergenceWindow(d)
+		} else {
+			slog.Warn("Invalid CONVERGENCE_WINDOW, using default", "value", window, "default", service.DefaultConvergenceWindow)
+		}
+	}
 	bridgeService.Start(ctx)
 
-	// Start SSDP Server
-	ssdpServer := ssdp.NewServer(ip)
+	// Push-based state sync, the periodic refresh remains as a fallback
+	bridgeService.WatchEvents(ctx, haEvents)
+
+	// Stable identity, derived from the MAC of the advertised interface on first start
+	identity, err := bridgeService.EnsureIdentity(ctx, getInterfaceMAC(ip))
+	if err != nil {
+		slog.Error("Could not initialize bridge identity", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Bridge identity", "mac", identity.MAC, "bridgeid", identity.BridgeID)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "80"
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		bridgeService.SetPrimaryAddress(ip, p)
+	}
+
+	// Additional bridges, each serving its own subset of the devices
+	instances, err := bridgeService.EnsureBridges(ctx)
+	if err != nil {
+		slog.Error("Could not initialize bridge instances", "error", err)
+		os.Exit(1)
+	}
+	advertised := []ssdp.Bridge{{IP: ip, Port: 80, Identity: identity}}
+	for _, b := range instances {
+		advertised = append(advertised, ssdp.Bridge{IP: instanceIP(b, ip), Port: instancePort(b), Identity: b.Identity})
+		slog.Info("Bridge instance", "id", b.ID, "ip", instanceIP(b, ip), "port", instancePort(b), "bridgeid", b.Identity.BridgeID)
+	}
+
+	// Start SSDP Server, it announces byebye on shutdown
+	ssdpServer := ssdp.NewServer(advertised...)
+	if interval := os.Getenv("SSDP_NOTIFY_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil && d > 0 {
+			ssdpServer.SetNotifyInterval(d)
+		} else {
+			slog.Warn("Invalid SSDP_NOTIFY_INTERVAL, using default", "value", interval, "default", ssdp.DefaultNotifyInterval)
+		}
+	}
+	ssdpDone := make(chan struct{})
 	go func() {
-		if err := ssdpServer.Start(); err != nil {
+		defer close(ssdpDone)
+		if err := ssdpServer.Start(ctx); err != nil {
 			slog.Error("SSDP Server error", "error", err)
 		}
 	}()
@@ -108,17 +205,66 @@ func main() {
 	}
 	authService := service.NewAuthService(authRepo)
 
-	// Start HTTP Server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "80"
+	// Hue API users
+	whitelistRepo := persistence.NewJSONWhitelistRepository("/data/whitelist.json")
+	if os.Getenv("WHITELIST_PATH") != "" {
+		whitelistRepo = persistence.NewJSONWhitelistRepository(os.Getenv("WHITELIST_PATH"))
+	}
+	whitelistService := service.NewWhitelistService(whitelistRepo)
+	// Clients paired before the whitelist existed all use the legacy username
+	if err := whitelistService.EnsureLegacyUser(ctx, len(cfg.VirtualDevices) > 0); err != nil {
+		slog.Error("Could not whitelist the legacy Hue username", "error", err)
+		os.Exit(1)
+	}
+	if window := os.Getenv("LINK_BUTTON_WINDOW"); window != "" {
+		if d, err := time.ParseDuration(window); err == nil && d > 0 {
+			whitelistService.SetLinkButtonWindow(d)
+		} else {
+			slog.Warn("Invalid LINK_BUTTON_WINDOW, using default", "value", window, "default", service.DefaultLinkButtonWindow)
+		}
 	}
-	httpServer := http.NewServer(bridgeService, bridgeService, authService, ip)
-	slog.Info("HTTP Server listening", "address", "0.0.0.0:"+port)
-	if err := httpServer.ListenAndServe(":"+port); err != nil {
+
+	// Start HTTP Servers, one per bridge
+	// Instances on IP aliases share the port, the primary bridge must not hold the wildcard address
+	addr := ":" + port
+	if len(instances) > 0 {
+		addr = net.JoinHostPort(ip, port)
+	}
+	servers, serversCtx := errgroup.WithContext(ctx)
+	httpServer := http.NewServer(bridgeService.ForBridge(service.PrimaryBridgeID), bridgeService, authService, whitelistService, ip)
+	servers.Go(func() error {
+		slog.Info("HTTP Server listening", "address", addr)
+		return httpServer.ListenAndServe(serversCtx, addr)
+	})
+	for _, b := range instances {
+		instanceServer := http.NewServer(bridgeService.ForBridge(b.ID), bridgeService, authService, whitelistService, instanceIP(b, ip))
+		instanceServer.SetPort(instancePort(b))
+		instanceAddr := net.JoinHostPort(instanceIP(b, ip), strconv.Itoa(instancePort(b)))
+		servers.Go(func() error {
+			slog.Info("HTTP Server listening", "address", instanceAddr, "bridge", b.ID)
+			return instanceServer.ListenAndServe(serversCtx, instanceAddr)
+		})
+	}
+	if err := servers.Wait(); err != nil {
 		slog.Error("HTTP Server error", "error", err)
 		os.Exit(1)
 	}
+	<-ssdpDone
+}
+
+// instanceIP returns the address of an additional bridge, the primary one by default
+func instanceIP(b *model.BridgeInstance, primaryIP string) string {
+	if b.IP != "" {
+		return b.IP
+	}
+	return primaryIP
+}
+
+func instancePort(b *model.BridgeInstance) int {
+	if b.Port != 0 {
+		return b.Port
+	}
+	return 80
 }
 
 func getLocalIP(preferredNet string) string {
@@ -189,3 +335,23 @@ func getLocalIP(preferredNet string) string {
 	}
 	return bestIP
 }
+
+// getInterfaceMAC returns the hardware address of the interface owning ip, if any
+func getInterfaceMAC(ip string) net.HardwareAddr {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == ip {
+				return iface.HardwareAddr
+			}
+		}
+	}
+	return nil
+}
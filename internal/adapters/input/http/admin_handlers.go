@@ -2,9 +2,11 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -84,15 +86,21 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 
 		// Mask token for frontend
 		displayCfg := struct {
-			HassURL             string                 `json:"hass_url"`
-			HassToken           string                 `json:"hass_token"`
-			HassTokenConfigured bool                   `json:"hass_token_configured"`
-			VirtualDevices      []*model.VirtualDevice `json:"virtual_devices"`
+			HassURL             string                  `json:"hass_url"`
+			HassToken           string                  `json:"hass_token"`
+			HassTokenConfigured bool                    `json:"hass_token_configured"`
+			VirtualDevices      []*model.VirtualDevice  `json:"virtual_devices"`
+			VirtualGroups       []*model.VirtualGroup   `json:"virtual_groups"`
+			Bridges             []*model.BridgeInstance `json:"bridges"`
+			MaxDevicesPerBridge int                     `json:"max_devices_per_bridge"`
 		}{
 			HassURL:             cfg.HassURL,
 			HassToken:           "",
 			HassTokenConfigured: cfg.HassToken != "",
 			VirtualDevices:      cfg.VirtualDevices,
+			VirtualGroups:       cfg.VirtualGroups,
+			Bridges:             cfg.Bridges,
+			MaxDevicesPerBridge: cfg.MaxDevicesPerBridge,
 		}
 
 		s.jsonResponse(w, displayCfg)
@@ -112,6 +120,10 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		}
 
 		err := s.admin.UpdateConfig(r.Context(), &newCfg)
+		if errors.Is(err, model.ErrInvalidConfig) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -129,6 +141,85 @@ func (s *Server) handleHAEntities(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, entities)
 }
 
+func (s *Server) handleHueUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		users, err := s.whitelist.GetUsers(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.jsonResponse(w, users)
+	} else if r.Method == "DELETE" {
+		err := s.whitelist.DeleteUser(r.Context(), r.URL.Query().Get("username"))
+		if errors.Is(err, model.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleLinkButton(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		s.jsonResponse(w, map[string]bool{"linkbutton": s.whitelist.LinkButtonPressed(r.Context())})
+	} else if r.Method == "POST" {
+		until := s.whitelist.PressLinkButton(r.Context())
+		s.jsonResponse(w, map[string]interface{}{"linkbutton": true, "until": until})
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := model.CommandFilter{Device: q.Get("device"), Status: model.CommandStatus(q.Get("status"))}
+	if filter.Status != "" && filter.Status != model.CommandSucceeded && filter.Status != model.CommandFailed {
+		http.Error(w, "status must be ok or failed", http.StatusBadRequest)
+		return
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	s.jsonResponse(w, s.admin.GetCommands(r.Context(), filter))
+}
+
+func (s *Server) handleStateMismatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.jsonResponse(w, s.admin.GetStateMismatches(r.Context()))
+}
+
+func (s *Server) handleRegenerateIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	identity, err := s.admin.RegenerateIdentity(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, identity)
+}
+
 func (s *Server) getClientIP(r *http.Request) string {
 	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
 		return xrip
@@ -169,6 +260,61 @@ func (s *Server) handleAdminTestAction(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleTranslatePreview shows what a device configuration translates an HA state or a Hue state
+// to, without calling HA. The Hue state is validated like a Hue light state command.
+func (s *Server) handleTranslatePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		VirtualDevice *model.VirtualDevice `json:"virtual_device"`
+		HAState       *struct {
+			EntityID   string         `json:"entity_id"`
+			State      string         `json:"state"`
+			Attributes model.HAFields `json:"attributes"`
+		} `json:"ha_state"`
+		HueState map[string]interface{} `json:"hue_state"`
+		Sweep    bool                   `json:"sweep"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.VirtualDevice == nil || (req.HAState == nil && req.HueState == nil && !req.Sweep) {
+		http.Error(w, "virtual_device and one of ha_state, hue_state or sweep are required", http.StatusBadRequest)
+		return
+	}
+
+	var haState *model.HAEntityState
+	if req.HAState != nil {
+		haState = &model.HAEntityState{EntityID: req.HAState.EntityID, State: req.HAState.State, Attributes: req.HAState.Attributes}
+	}
+	var hueState *model.DeviceState
+	if req.HueState != nil {
+		var resp []map[string]interface{}
+		hueState, resp = s.parseStateUpdate("/lights/preview/state", req.HueState)
+		if hueState == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	preview, err := s.admin.PreviewTranslation(r.Context(), req.VirtualDevice, haState, hueState, req.Sweep)
+	if errors.Is(err, model.ErrInvalidConfig) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, preview)
+}
+
 const adminSetupHTML = `
 <!DOCTYPE html>
 <html>
@@ -239,6 +385,9 @@ const adminHTML = `
     <div class="tabs">
         <div class="tab active" onclick="showTab('general')">General Config</div>
         <div class="tab" onclick="showTab('virtual-devices')">Virtual Devices</div>
+        <div class="tab" onclick="showTab('groups')">Groups</div>
+        <div class="tab" onclick="showTab('hue-apps')">Hue Apps</div>
+        <div class="tab" onclick="showTab('commands')">Commands</div>
     </div>
 
     <div id="general" class="content active">
@@ -252,6 +401,35 @@ const adminHTML = `
 
             <button type="submit">Save General Config</button>
         </form>
+
+        <h2>Bridge Identity</h2>
+        <p>Bridge ID: <span id="bridgeIdentity"></span></p>
+        <p style="color: #666;">A new identity makes Alexa see a new bridge: discover devices again and remove the old ones in the Alexa app. It is advertised after a restart.</p>
+        <button type="button" onclick="regenerateIdentity()" style="margin-bottom: 10px;">Regenerate Identity</button>
+
+        <h2>Additional Bridges</h2>
+        <p style="color: #666;">Each bridge serves its own devices, which helps when Alexa struggles with many devices on one bridge. Alexa only uses port 80, give each bridge its own IP alias. Changes apply after a restart.</p>
+        <label for="max_devices_per_bridge">Max devices per bridge (0 keeps unassigned devices on the primary bridge)</label>
+        <input type="number" id="max_devices_per_bridge" min="0" value="0">
+        <table id="bridgesTable">
+            <thead>
+                <tr>
+                    <th>ID</th>
+                    <th>IP</th>
+                    <th>Port</th>
+                    <th>Bridge ID</th>
+                    <th>Devices</th>
+                    <th>Actions</th>
+                </tr>
+            </thead>
+            <tbody></tbody>
+        </table>
+        <div style="display: flex; gap: 5px;">
+            <input type="text" id="bridge_ip" placeholder="IP alias, e.g. 192.168.1.21">
+            <input type="number" id="bridge_port" placeholder="80" min="1" max="65535">
+            <button type="button" onclick="addBridge()" style="margin-bottom: 10px;">+ Add Bridge</button>
+        </div>
+        <button onclick="saveAll()">Save Configuration</button>
     </div>
 
     <div id="virtual-devices" class="content">
@@ -266,6 +444,7 @@ const adminHTML = `
                     <th>Alexa Name</th>
                     <th>HA Entity ID</th>
                     <th>Type</th>
+                    <th>Bridge</th>
                     <th>Test Actions</th>
                     <th>Actions</th>
                 </tr>
@@ -275,6 +454,108 @@ const adminHTML = `
         <button onclick="saveAll()">Save Configuration</button>
     </div>
 
+    <div id="groups" class="content">
+        <div style="display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px;">
+            <h2>Rooms &amp; Zones</h2>
+            <button onclick="openGroupModal()">+ Add Group</button>
+        </div>
+        <table id="groupsTable">
+            <thead>
+                <tr>
+                    <th>GroupID</th>
+                    <th>Alexa Name</th>
+                    <th>Type</th>
+                    <th>Members</th>
+                    <th>Actions</th>
+                </tr>
+            </thead>
+            <tbody></tbody>
+        </table>
+        <button onclick="saveAll()">Save Configuration</button>
+    </div>
+
+    <div id="hue-apps" class="content">
+        <h2>Paired Hue Apps</h2>
+        <p>Clients such as Alexa that registered with the emulated bridge. Revoked clients have to pair again.</p>
+        <p>
+            <button onclick="pressLinkButton()">Press Link Button</button>
+            <span id="linkButtonStatus" style="margin-left: 10px; color: #666;"></span>
+        </p>
+        <table id="hueUsersTable">
+            <thead>
+                <tr>
+                    <th>Device Type</th>
+                    <th>Username</th>
+                    <th>Created</th>
+                    <th>Last Used</th>
+                    <th>Actions</th>
+                </tr>
+            </thead>
+            <tbody></tbody>
+        </table>
+    </div>
+
+    <div id="commands" class="content">
+        <div style="display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px;">
+            <h2>Recent Failures</h2>
+            <button onclick="loadFailures()">Refresh</button>
+        </div>
+        <p>Commands Home Assistant rejected or did not answer. The full history is available at <code>/admin/commands?device=&lt;id&gt;&amp;status=ok|failed</code>.</p>
+        <table id="failuresTable">
+            <thead>
+                <tr>
+                    <th>Time</th>
+                    <th>Device</th>
+                    <th>Service</th>
+                    <th>Payload</th>
+                    <th>HTTP Status</th>
+                    <th>Latency</th>
+                    <th>Error</th>
+                </tr>
+            </thead>
+            <tbody></tbody>
+        </table>
+
+        <h2>State Mismatches</h2>
+        <p>Devices whose state Home Assistant did not report back after a command, the change was rolled back. Frequent mismatches usually mean the mapping does not round-trip.</p>
+        <table id="mismatchesTable">
+            <thead>
+                <tr>
+                    <th>Device</th>
+                    <th>Count</th>
+                    <th>Last</th>
+                    <th>Expected</th>
+                    <th>Reported</th>
+                </tr>
+            </thead>
+            <tbody></tbody>
+        </table>
+    </div>
+
+    <div id="groupModal" class="modal">
+        <div class="modal-content">
+            <h2 id="groupModalTitle">Group Configuration</h2>
+            <input type="hidden" id="group_index">
+            <label>Alexa Name</label>
+            <input type="text" id="group_name" placeholder="e.g. Living Room">
+            <label>Type</label>
+            <select id="group_type">
+                <option value="Room">Room</option>
+                <option value="Zone">Zone</option>
+                <option value="LightGroup">Light Group</option>
+            </select>
+            <label>Class</label>
+            <input type="text" id="group_class" placeholder="Living room">
+            <label>Members</label>
+            <div id="group_members"></div>
+
+            <div style="margin-top: 20px; text-align: right;">
+                <button onclick="closeGroupModal()">Cancel</button>
+                <button onclick="applyGroupChanges()">Apply</button>
+            </div>
+        </div>
+    </div>
+
     <div id="deviceModal" class="modal">
         <div class="modal-content">
             <h2 id="modalTitle">Device Configuration</h2>
@@ -288,13 +569,33 @@ const adminHTML = `
                 </select>
                 <button type="button" onclick="loadEntities()" title="Refresh entities" style="padding: 5px 10px; margin-bottom: 10px;">Refresh</button>
             </div>
+            <label>State Entity (optional, e.g. binary_sensor.gate)</label>
+            <input type="text" id="dev_state_entity" placeholder="Defaults to the HA entity">
+            <label>State Attribute (optional, e.g. position or status.open)</label>
+            <input type="text" id="dev_state_attribute">
             <label>Type</label>
             <select id="dev_type" onchange="toggleAdvanced()">
                 <option value="light">Light</option>
                 <option value="cover">Cover</option>
                 <option value="climate">Climate</option>
+                <option value="fan">Fan</option>
+                <option value="media_player">Media Player</option>
+                <option value="lock">Lock</option>
+                <option value="valve">Valve</option>
+                <option value="garage">Garage Door</option>
+                <option value="trigger">Scene / Script / Button</option>
                 <option value="custom">Custom</option>
             </select>
+            <label>Bridge</label>
+            <select id="dev_bridge"></select>
+            <label>Additional Entities (JSON, optional)</label>
+            <textarea id="dev_targets" placeholder='[{"entity_id": "light.right_lamp"}, {"entity_id": "switch.lamp", "type": "custom", "payload": {}}]'></textarea>
+            <label>Reported State (with additional entities)</label>
+            <select id="dev_state_policy">
+                <option value="any">ON when any entity is on</option>
+                <option value="all">ON when all entities are on</option>
+                <option value="leader">State of the main entity</option>
+            </select>
 
             <div id="modal_test_actions" style="margin-bottom: 20px; padding: 10px; border: 1px dashed #007bff; border-radius: 4px;">
                 <label>Test Current Device (Real-time)</label>
@@ -305,6 +606,57 @@ const adminHTML = `
                 </div>
             </div>
 
+            <fieldset id="fan_config">
+                <legend>Fan Preset Modes</legend>
+                <label>Brightness bands sent as preset modes (JSON, optional)</label>
+                <textarea id="fan_presets" placeholder='[{"max_bri": 84, "mode": "sleep"}, {"max_bri": 169, "mode": "auto"}]'></textarea>
+            </fieldset>
+
+            <fieldset id="climate_config">
+                <legend>Climate</legend>
+                <label>HVAC mode when ON</label>
+                <select id="hvac_mode">
+                    <option value="">None (climate.turn_on / turn_off)</option>
+                    <option value="heat">Heat</option>
+                    <option value="cool">Cool</option>
+                    <option value="heat_cool">Heat / Cool</option>
+                    <option value="auto">Auto</option>
+                </select>
+                <label>Min / Max temperature and step (empty uses the entity's)</label>
+                <input type="number" id="climate_min_temp" step="any">
+                <input type="number" id="climate_max_temp" step="any">
+                <input type="number" id="climate_temp_step" step="any" min="0">
+            </fieldset>
+
+            <fieldset id="media_config">
+                <legend>Media Player</legend>
+                <label><input type="checkbox" id="media_playback"> ON/OFF plays and pauses</label>
+                <label><input type="checkbox" id="muted_as_off"> Muted is OFF</label>
+            </fieldset>
+
+            <fieldset id="safety_config">
+                <legend>Safety</legend>
+                <label><input type="checkbox" id="allow_voice_unlock"> Allow unlocking / opening by voice</label>
+            </fieldset>
+
+            <fieldset id="trigger_config">
+                <legend>Trigger</legend>
+                <label>Reported ON for (seconds)</label>
+                <input type="number" id="trigger_reset_delay" placeholder="5" min="0">
+                <small>OFF is ignored unless an OFF Service is set in the custom actions.</small>
+            </fieldset>
+
+            <fieldset id="rules_config">
+                <legend>Mapping Rules (optional)</legend>
+                <label>HA states reported as on / off / unreachable (JSON)</label>
+                <textarea id="state_map" placeholder='{"open": "on", "jammed": "unreachable"}'></textarea>
+                <label>Brightness attribute (dotted path) and its maximum</label>
+                <input type="text" id="bri_attribute" placeholder="level">
+                <input type="number" id="bri_attribute_max" placeholder="254" step="any" min="0">
+                <label>Brightness bands sent with their own service and payload (JSON)</label>
+                <textarea id="bri_bands" placeholder='[{"max_bri": 84, "service": "script.preset_low", "payload": {}}]'></textarea>
+            </fieldset>
+
             <fieldset id="advanced_config">
                 <legend>Custom Actions Configuration</legend>
                 <label>ON Service</label>
@@ -355,6 +707,8 @@ const adminHTML = `
             const res = await fetch('/admin/config');
             config = await res.json();
             if (!config.virtual_devices) config.virtual_devices = [];
+            if (!config.virtual_groups) config.virtual_groups = [];
+            if (!config.bridges) config.bridges = [];
 
             document.getElementById('hass_url').value = config.hass_url || '';
             document.getElementById('hass_token').value = '';
@@ -365,8 +719,126 @@ const adminHTML = `
                 tokenInput.placeholder = 'Enter Long-Lived Access Token';
             }
 
+            document.getElementById('max_devices_per_bridge').value = config.max_devices_per_bridge || 0;
+            document.getElementById('bridgeIdentity').textContent = config.identity ? config.identity.bridge_id : 'generated on restart';
+
+            renderBridges();
             renderDevices();
+            renderGroups();
             loadEntities();
+            loadHueUsers();
+            loadFailures();
+        }
+
+        async function loadFailures() {
+            const res = await fetch('/admin/commands?status=failed&limit=20');
+            const failures = (await res.json()) || [];
+            const tbody = document.querySelector('#failuresTable tbody');
+            tbody.innerHTML = '';
+            if (failures.length === 0) {
+                tbody.innerHTML = '<tr><td colspan="7" style="color: #666;">No failed commands</td></tr>';
+                return;
+            }
+            failures.forEach(c => {
+                const tr = document.createElement('tr');
+                tr.innerHTML =
+                    '<td>' + new Date(c.time).toLocaleString() + '</td>' +
+                    '<td>' + c.device_name + '<br><small>' + c.entity_id + '</small></td>' +
+                    '<td><code>' + c.service + '</code></td>' +
+                    '<td><code>' + JSON.stringify(c.data || {}) + '</code></td>' +
+                    '<td>' + (c.http_status || '-') + '</td>' +
+                    '<td>' + c.latency_ms + ' ms</td>' +
+                    '<td>' + c.error + '</td>';
+                tbody.appendChild(tr);
+            });
+            loadMismatches();
+        }
+
+        function summarizeState(st) {
+            if (!st.on) return 'off';
+            return 'on, bri ' + st.bri + (st.colormode ? ', ' + st.colormode : '');
+        }
+
+        async function loadMismatches() {
+            const res = await fetch('/admin/state-mismatches');
+            const mismatches = (await res.json()) || [];
+            const tbody = document.querySelector('#mismatchesTable tbody');
+            tbody.innerHTML = '';
+            if (mismatches.length === 0) {
+                tbody.innerHTML = '<tr><td colspan="5" style="color: #666;">No mismatches</td></tr>';
+                return;
+            }
+            mismatches.forEach(m => {
+                const tr = document.createElement('tr');
+                tr.innerHTML =
+                    '<td>' + m.device_name + '<br><small>' + m.entity_id + '</small></td>' +
+                    '<td>' + m.count + '</td>' +
+                    '<td>' + new Date(m.last).toLocaleString() + '</td>' +
+                    '<td>' + summarizeState(m.expected) + '</td>' +
+                    '<td>' + summarizeState(m.reported) + '</td>';
+                tbody.appendChild(tr);
+            });
+        }
+
+        async function loadHueUsers() {
+            const res = await fetch('/admin/hue-users');
+            const users = (await res.json()) || [];
+            const tbody = document.querySelector('#hueUsersTable tbody');
+            tbody.innerHTML = '';
+            users.forEach(u => {
+                const tr = document.createElement('tr');
+                tr.innerHTML =
+                    '<td>' + u.devicetype + '</td>' +
+                    '<td><code>' + u.username + '</code></td>' +
+                    '<td>' + new Date(u.create_date).toLocaleString() + '</td>' +
+                    '<td>' + new Date(u.last_use_date).toLocaleString() + '</td>' +
+                    '<td><button class="delete" onclick="revokeHueUser(\'' + u.username + '\')">Revoke</button></td>';
+                tbody.appendChild(tr);
+            });
+        }
+
+        async function regenerateIdentity() {
+            if (!confirm('Regenerate the bridge identity? Alexa will have to discover all devices again.')) return;
+            const res = await fetch('/admin/identity/regenerate', { method: 'POST' });
+            if (!res.ok) {
+                showStatus('Error regenerating identity: ' + await res.text());
+                return;
+            }
+            config.identity = await res.json();
+            document.getElementById('bridgeIdentity').textContent = config.identity.bridge_id;
+            showStatus('Identity regenerated, restart the emulator and discover devices again');
+        }
+
+        let linkButtonTimer;
+
+        async function pressLinkButton() {
+            const res = await fetch('/admin/link-button', { method: 'POST' });
+            if (!res.ok) {
+                showStatus('Error pressing link button');
+                return;
+            }
+            const until = new Date((await res.json()).until);
+            const status = document.getElementById('linkButtonStatus');
+            clearInterval(linkButtonTimer);
+            const tick = () => {
+                const left = Math.ceil((until - new Date()) / 1000);
+                if (left <= 0) {
+                    clearInterval(linkButtonTimer);
+                    status.textContent = 'Pairing closed';
+                    loadHueUsers();
+                    return;
+                }
+                status.textContent = 'Pairing open, ask Alexa to discover devices (' + left + 's left)';
+            };
+            tick();
+            linkButtonTimer = setInterval(tick, 1000);
+        }
+
+        async function revokeHueUser(username) {
+            if (!confirm('Revoke this client? It will have to pair again.')) return;
+            const res = await fetch('/admin/hue-users?username=' + encodeURIComponent(username), { method: 'DELETE' });
+            showStatus(res.ok ? 'Client revoked' : 'Error revoking client');
+            loadHueUsers();
         }
 
         async function loadEntities() {
@@ -404,6 +876,61 @@ const adminHTML = `
             if (selectedValue) sel.value = selectedValue;
         }
 
+        function bridgeLabel(id) {
+            const b = config.bridges.find(b => b.id === id);
+            if (!id || !b) return 'Primary';
+            return 'Bridge ' + b.id + ' (' + (b.ip || 'primary IP') + ':' + (b.port || 80) + ')';
+        }
+
+        function renderBridges() {
+            const tbody = document.querySelector('#bridgesTable tbody');
+            tbody.innerHTML = '';
+            config.bridges.forEach((b, index) => {
+                const count = config.virtual_devices.filter(d => d.bridge_id === b.id).length;
+                const tr = document.createElement('tr');
+                tr.innerHTML =
+                    '<td>' + (b.id || 'new') + '</td>' +
+                    '<td>' + (b.ip || 'primary IP') + '</td>' +
+                    '<td>' + (b.port || 80) + '</td>' +
+                    '<td>' + (b.identity ? b.identity.bridge_id : 'generated on save') + '</td>' +
+                    '<td>' + count + '</td>' +
+                    '<td><button class="delete" onclick="deleteBridge(' + index + ')">Delete</button></td>';
+                tbody.appendChild(tr);
+            });
+        }
+
+        function addBridge() {
+            const b = { ip: document.getElementById('bridge_ip').value.trim() };
+            const port = parseInt(document.getElementById('bridge_port').value);
+            if (port) b.port = port;
+            config.bridges.push(b);
+            document.getElementById('bridge_ip').value = '';
+            document.getElementById('bridge_port').value = '';
+            renderBridges();
+        }
+
+        function deleteBridge(index) {
+            if (confirm('Delete this bridge? Its devices move back to the primary bridge.')) {
+                const id = config.bridges[index].id;
+                config.bridges.splice(index, 1);
+                config.virtual_devices.forEach(d => { if (id && d.bridge_id === id) delete d.bridge_id; });
+                renderBridges();
+                renderDevices();
+            }
+        }
+
+        function renderBridgeSelect(selectedValue = '') {
+            const sel = document.getElementById('dev_bridge');
+            sel.innerHTML = '<option value="">Primary</option>';
+            config.bridges.filter(b => b.id).forEach(b => {
+                const opt = document.createElement('option');
+                opt.value = b.id;
+                opt.textContent = bridgeLabel(b.id);
+                sel.appendChild(opt);
+            });
+            sel.value = selectedValue;
+        }
+
         function renderDevices() {
             const tbody = document.querySelector('#devicesTable tbody');
             tbody.innerHTML = '';
@@ -413,14 +940,15 @@ const adminHTML = `
                 const testButtons = hueId ?
                     '<button onclick="testAction(\''+hueId+'\', {on: true})">On</button> ' +
                     '<button onclick="testAction(\''+hueId+'\', {on: false})">Off</button> ' +
-                    '<button onclick="testAction(\''+hueId+'\', {bri: 127})">Dim 50%</button>' :
+                    '<button onclick="testAction(\''+hueId+'\', {on: true, bri: 127})">Dim 50%</button>' :
                     '<span style="color: #666; font-style: italic;">Save config first</span>';
 
                 tr.innerHTML =
                     '<td>' + (hueId || 'new') + '</td>' +
                     '<td>' + vd.name + '</td>' +
-                    '<td>' + vd.entity_id + '</td>' +
+                    '<td>' + vd.entity_id + (vd.targets && vd.targets.length ? ' <small>+' + vd.targets.length + '</small>' : '') + '</td>' +
                     '<td>' + vd.type + '</td>' +
+                    '<td>' + bridgeLabel(vd.bridge_id) + '</td>' +
                     '<td>' + testButtons + '</td>' +
                     '<td>' +
                         '<button onclick="openDeviceModal(' + index + ')">Edit</button> ' +
@@ -431,11 +959,12 @@ const adminHTML = `
         }
 
         async function testAction(hueId, state) {
+            const vd = config.virtual_devices.find(d => d.hue_id === hueId);
             try {
-                const res = await fetch('/api/admin/lights/' + hueId + '/state', {
-                    method: 'PUT',
+                const res = await fetch('/admin/test-action', {
+                    method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify(state)
+                    body: JSON.stringify({ virtual_device: vd, state_update: state })
                 });
                 if (res.ok) {
                     showStatus('Action sent successfully');
@@ -501,7 +1030,12 @@ const adminHTML = `
         function toggleAdvanced() {
             const type = document.getElementById('dev_type').value;
             const advContainer = document.getElementById('advanced_config');
-            advContainer.style.display = (type === 'custom') ? 'block' : 'none';
+            advContainer.style.display = (type === 'custom' || type === 'trigger') ? 'block' : 'none';
+            document.getElementById('fan_config').style.display = (type === 'fan') ? 'block' : 'none';
+            document.getElementById('climate_config').style.display = (type === 'climate') ? 'block' : 'none';
+            document.getElementById('media_config').style.display = (type === 'media_player') ? 'block' : 'none';
+            document.getElementById('safety_config').style.display = ['lock', 'valve', 'garage'].includes(type) ? 'block' : 'none';
+            document.getElementById('trigger_config').style.display = (type === 'trigger') ? 'block' : 'none';
             renderEntitySelect(document.getElementById('dev_entity').value);
         }
 
@@ -512,6 +1046,11 @@ const adminHTML = `
                 document.getElementById('dev_name').value = d.name;
                 renderEntitySelect(d.entity_id);
                 document.getElementById('dev_type').value = d.type;
+                renderBridgeSelect(d.bridge_id || '');
+                document.getElementById('dev_state_entity').value = d.state_entity_id || '';
+                document.getElementById('dev_state_attribute').value = d.state_attribute || '';
+                document.getElementById('dev_targets').value = d.targets ? JSON.stringify(d.targets, null, 2) : '';
+                document.getElementById('dev_state_policy').value = d.state_policy || 'any';
                 const ac = d.action_config || {};
                 document.getElementById('on_service').value = ac.on_service || '';
                 document.getElementById('on_payload').value = JSON.stringify(ac.on_payload || {}, null, 2);
@@ -522,11 +1061,29 @@ const adminHTML = `
                 document.getElementById('to_hue').value = ac.to_hue_formula || '';
                 document.getElementById('to_ha').value = ac.to_ha_formula || '';
                 document.getElementById('omit_eid').checked = ac.omit_entity_id || false;
+                document.getElementById('fan_presets').value = ac.fan_presets ? JSON.stringify(ac.fan_presets, null, 2) : '';
+                document.getElementById('media_playback').checked = ac.media_playback || false;
+                document.getElementById('muted_as_off').checked = ac.muted_as_off || false;
+                document.getElementById('allow_voice_unlock').checked = ac.allow_voice_unlock || false;
+                document.getElementById('trigger_reset_delay').value = ac.trigger_reset_delay || '';
+                document.getElementById('hvac_mode').value = ac.hvac_mode || '';
+                document.getElementById('state_map').value = ac.state_map ? JSON.stringify(ac.state_map, null, 2) : '';
+                document.getElementById('bri_attribute').value = ac.bri_attribute || '';
+                document.getElementById('bri_attribute_max').value = ac.bri_attribute_max || '';
+                document.getElementById('bri_bands').value = ac.bri_bands ? JSON.stringify(ac.bri_bands, null, 2) : '';
+                document.getElementById('climate_min_temp').value = ac.climate_min_temp ?? '';
+                document.getElementById('climate_max_temp').value = ac.climate_max_temp ?? '';
+                document.getElementById('climate_temp_step').value = ac.climate_temp_step || '';
                 document.getElementById('modalTitle').textContent = 'Edit Virtual Device';
             } else {
                 document.getElementById('dev_name').value = '';
                 renderEntitySelect('');
                 document.getElementById('dev_type').value = 'light';
+                renderBridgeSelect('');
+                document.getElementById('dev_state_entity').value = '';
+                document.getElementById('dev_state_attribute').value = '';
+                document.getElementById('dev_targets').value = '';
+                document.getElementById('dev_state_policy').value = 'any';
                 document.getElementById('on_service').value = '';
                 document.getElementById('on_payload').value = '{}';
                 document.getElementById('no_op_on').checked = false;
@@ -536,6 +1093,19 @@ const adminHTML = `
                 document.getElementById('to_hue').value = '';
                 document.getElementById('to_ha').value = '';
                 document.getElementById('omit_eid').checked = false;
+                document.getElementById('fan_presets').value = '';
+                document.getElementById('media_playback').checked = false;
+                document.getElementById('muted_as_off').checked = false;
+                document.getElementById('allow_voice_unlock').checked = false;
+                document.getElementById('trigger_reset_delay').value = '';
+                document.getElementById('hvac_mode').value = '';
+                document.getElementById('state_map').value = '';
+                document.getElementById('bri_attribute').value = '';
+                document.getElementById('bri_attribute_max').value = '';
+                document.getElementById('bri_bands').value = '';
+                document.getElementById('climate_min_temp').value = '';
+                document.getElementById('climate_max_temp').value = '';
+                document.getElementById('climate_temp_step').value = '';
                 document.getElementById('modalTitle').textContent = 'Add Virtual Device';
             }
             toggleAdvanced();
@@ -546,6 +1116,12 @@ const adminHTML = `
             document.getElementById('deviceModal').style.display = 'none';
         }
 
+        // optionalNumber returns the number typed in an input, null when it is empty
+        function optionalNumber(id) {
+            const v = parseFloat(document.getElementById(id).value);
+            return isNaN(v) ? null : v;
+        }
+
         function applyDeviceChanges() {
             const index = parseInt(document.getElementById('edit_index').value);
             let on_payload, off_payload;
@@ -561,11 +1137,38 @@ const adminHTML = `
                 alert('Invalid OFF Payload JSON: ' + e.message);
                 return;
             }
+            let fan_presets;
+            try {
+                fan_presets = JSON.parse(document.getElementById('fan_presets').value || '[]');
+            } catch (e) {
+                alert('Invalid Fan Presets JSON: ' + e.message);
+                return;
+            }
+            let targets;
+            try {
+                targets = JSON.parse(document.getElementById('dev_targets').value || '[]');
+            } catch (e) {
+                alert('Invalid Additional Entities JSON: ' + e.message);
+                return;
+            }
+            let state_map, bri_bands;
+            try {
+                state_map = JSON.parse(document.getElementById('state_map').value || '{}');
+                bri_bands = JSON.parse(document.getElementById('bri_bands').value || '[]');
+            } catch (e) {
+                alert('Invalid Mapping Rules JSON: ' + e.message);
+                return;
+            }
 
             const d = {
                 name: document.getElementById('dev_name').value,
                 entity_id: document.getElementById('dev_entity').value,
                 type: document.getElementById('dev_type').value,
+                bridge_id: document.getElementById('dev_bridge').value,
+                state_entity_id: document.getElementById('dev_state_entity').value,
+                state_attribute: document.getElementById('dev_state_attribute').value,
+                targets: targets,
+                state_policy: document.getElementById('dev_state_policy').value,
                 action_config: {
                     on_service: document.getElementById('on_service').value,
                     on_payload: on_payload,
@@ -575,7 +1178,20 @@ const adminHTML = `
                     no_op_off: document.getElementById('no_op_off').checked,
                     to_hue_formula: document.getElementById('to_hue').value,
                     to_ha_formula: document.getElementById('to_ha').value,
-                    omit_entity_id: document.getElementById('omit_eid').checked
+                    omit_entity_id: document.getElementById('omit_eid').checked,
+                    fan_presets: fan_presets,
+                    media_playback: document.getElementById('media_playback').checked,
+                    muted_as_off: document.getElementById('muted_as_off').checked,
+                    allow_voice_unlock: document.getElementById('allow_voice_unlock').checked,
+                    trigger_reset_delay: parseInt(document.getElementById('trigger_reset_delay').value) || 0,
+                    hvac_mode: document.getElementById('hvac_mode').value,
+                    climate_min_temp: optionalNumber('climate_min_temp'),
+                    climate_max_temp: optionalNumber('climate_max_temp'),
+                    climate_temp_step: parseFloat(document.getElementById('climate_temp_step').value) || 0,
+                    state_map: state_map,
+                    bri_attribute: document.getElementById('bri_attribute').value,
+                    bri_attribute_max: parseFloat(document.getElementById('bri_attribute_max').value) || 0,
+                    bri_bands: bri_bands
                 }
             };
             if (index >= 0) {
@@ -588,6 +1204,75 @@ const adminHTML = `
             closeDeviceModal();
         }
 
+        function renderGroups() {
+            const tbody = document.querySelector('#groupsTable tbody');
+            tbody.innerHTML = '';
+            config.virtual_groups.forEach((g, index) => {
+                const names = (g.lights || []).map(id => {
+                    const vd = config.virtual_devices.find(d => d.hue_id === id);
+                    return vd ? vd.name : id;
+                });
+                const tr = document.createElement('tr');
+                tr.innerHTML =
+                    '<td>' + (g.id || 'new') + '</td>' +
+                    '<td>' + g.name + '</td>' +
+                    '<td>' + (g.type || 'Room') + '</td>' +
+                    '<td>' + names.join(', ') + '</td>' +
+                    '<td>' +
+                        '<button onclick="openGroupModal(' + index + ')">Edit</button> ' +
+                        '<button class="delete" onclick="deleteGroup(' + index + ')">Delete</button>' +
+                    '</td>';
+                tbody.appendChild(tr);
+            });
+        }
+
+        function openGroupModal(index = -1) {
+            const g = index >= 0 ? config.virtual_groups[index] : { name: '', type: 'Room', class: '', lights: [] };
+            document.getElementById('group_index').value = index;
+            document.getElementById('group_name').value = g.name;
+            document.getElementById('group_type').value = g.type || 'Room';
+            document.getElementById('group_class').value = g.class || '';
+            const members = document.getElementById('group_members');
+            members.innerHTML = '';
+            config.virtual_devices.filter(vd => vd.hue_id).forEach(vd => {
+                const label = document.createElement('label');
+                const checked = (g.lights || []).includes(vd.hue_id) ? ' checked' : '';
+                label.innerHTML = '<input type="checkbox" value="' + vd.hue_id + '"' + checked + '> ' + vd.name;
+                members.appendChild(label);
+            });
+            document.getElementById('groupModalTitle').textContent = index >= 0 ? 'Edit Group' : 'Add Group';
+            document.getElementById('groupModal').style.display = 'block';
+        }
+
+        function closeGroupModal() {
+            document.getElementById('groupModal').style.display = 'none';
+        }
+
+        function applyGroupChanges() {
+            const index = parseInt(document.getElementById('group_index').value);
+            const g = {
+                name: document.getElementById('group_name').value,
+                type: document.getElementById('group_type').value,
+                class: document.getElementById('group_class').value,
+                lights: Array.from(document.querySelectorAll('#group_members input:checked')).map(c => c.value)
+            };
+            if (index >= 0) {
+                g.id = config.virtual_groups[index].id;
+                config.virtual_groups[index] = g;
+            } else {
+                config.virtual_groups.push(g);
+            }
+            renderGroups();
+            closeGroupModal();
+        }
+
+        function deleteGroup(index) {
+            if (confirm('Delete this group?')) {
+                config.virtual_groups.splice(index, 1);
+                renderGroups();
+            }
+        }
+
         function deleteDevice(index) {
             if (confirm('Delete this virtual device?')) {
                 config.virtual_devices.splice(index, 1);
@@ -598,6 +1283,7 @@ const adminHTML = `
         async function saveAll() {
             config.hass_url = document.getElementById('hass_url').value;
             config.hass_token = document.getElementById('hass_token').value;
+            config.max_devices_per_bridge = parseInt(document.getElementById('max_devices_per_bridge').value) || 0;
             // Note: If hass_token is empty, the backend will preserve the current one
             const res = await fetch('/admin/config', {
                 method: 'POST',
@@ -606,9 +1292,9 @@ const adminHTML = `
             });
             if (res.ok) {
                 showStatus('Configuration saved and applied!');
-                await loadEntities();
+                await loadData();
             } else {
-                showStatus('Error saving config');
+                showStatus('Error saving config: ' + await res.text());
             }
         }
 
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"net/http"
+)
+
+// Hue API error types, see https://developers.meethue.com/develop/hue-api/error-messages/
+const (
+	hueErrUnauthorizedUser     = 1
+	hueErrInvalidJSON          = 2
+	hueErrResourceUnavailable  = 3
+	hueErrMethodUnavailable    = 4
+	hueErrMissingParameters    = 5
+	hueErrParameterUnavailable = 6
+	hueErrInvalidValue         = 7
+	hueErrLinkButtonNotPressed = 101
+	hueErrInternal             = 901
+)
+
+type hueError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+func newHueError(errType int, address, description string) hueError {
+	return hueError{Type: errType, Address: address, Description: description}
+}
+
+func errUnauthorizedUser(address string) hueError {
+	return newHueError(hueErrUnauthorizedUser, address, "unauthorized user")
+}
+
+func errInvalidJSON(address string) hueError {
+	return newHueError(hueErrInvalidJSON, address, "body contains invalid json")
+}
+
+func errResourceUnavailable(address string) hueError {
+	return newHueError(hueErrResourceUnavailable, address, fmt.Sprintf("resource, %s, not available", address))
+}
+
+func errMethodUnavailable(method, address string) hueError {
+	return newHueError(hueErrMethodUnavailable, address, fmt.Sprintf("method, %s, not available for resource, %s", method, address))
+}
+
+func errMissingParameters(address string) hueError {
+	return newHueError(hueErrMissingParameters, address, "invalid/missing parameters in body")
+}
+
+func errParameterUnavailable(address, param string) hueError {
+	return newHueError(hueErrParameterUnavailable, address+"/"+param, fmt.Sprintf("parameter, %s, not available", param))
+}
+
+func errInvalidValue(address, param string, value interface{}) hueError {
+	return newHueError(hueErrInvalidValue, address+"/"+param, fmt.Sprintf("invalid value, %v, for parameter, %s", value, param))
+}
+
+// hueErrorResponse writes errors the way a bridge does: a 200 with an array of error objects
+func (s *Server) hueErrorResponse(w http.ResponseWriter, errs ...hueError) {
+	resp := make([]map[string]interface{}, 0, len(errs))
+	for _, e := range errs {
+		resp = append(resp, map[string]interface{}{"error": e})
+	}
+	s.jsonResponse(w, resp)
+}
+
+// hueServiceError maps a domain error on address to the matching Hue error
+func (s *Server) hueServiceError(w http.ResponseWriter, address string, err error) {
+	if errors.Is(err, model.ErrNotFound) {
+		s.hueErrorResponse(w, errResourceUnavailable(address))
+		return
+	}
+	if errors.Is(err, model.ErrLinkButtonNotPressed) {
+		s.hueErrorResponse(w, newHueError(hueErrLinkButtonNotPressed, address, "link button not pressed"))
+		return
+	}
+	s.hueErrorResponse(w, newHueError(hueErrInternal, address, fmt.Sprintf("Internal error, %s", err)))
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/amimof/huego"
@@ -14,39 +16,111 @@ func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api")
 	parts := strings.Split(strings.Trim(path, "/"), "/")
 
-	if r.Method == "POST" && (path == "" || path == "/") {
-		s.handleRegister(w, r)
+	if path == "" || path == "/" {
+		if r.Method == "POST" {
+			s.handleRegister(w, r)
+			return
+		}
+		s.hueErrorResponse(w, errUnauthorizedUser("/"))
 		return
 	}
 
-	if len(parts) < 1 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	username := parts[0]
+	subPath := parts[1:]
+	address := "/" + strings.Join(subPath, "/")
+
+	authorized, err := s.whitelist.Authorize(r.Context(), username)
+	if err != nil {
+		s.hueServiceError(w, address, err)
 		return
 	}
-
-	subPath := parts[1:]
-	if len(subPath) == 0 {
-		s.handleFullState(w, r)
+	if !authorized {
+		// Unpaired clients may still read the public part of the configuration
+		if r.Method == "GET" && address == "/config" {
+			s.handlePublicConfig(w, r)
+			return
+		}
+		s.hueErrorResponse(w, errUnauthorizedUser(address))
 		return
 	}
 
-	switch subPath[0] {
-	case "lights":
-		if len(subPath) == 1 {
+	switch {
+	case len(subPath) == 0:
+		if s.allowMethod(w, r, "GET", address) {
+			s.handleFullState(w, r)
+		}
+	case address == "/config":
+		if s.allowMethod(w, r, "GET", address) {
+			s.handleGetConfig(w, r)
+		}
+	case subPath[0] == "lights" && len(subPath) == 1:
+		if s.allowMethod(w, r, "GET", address) {
 			s.handleGetLights(w, r)
-		} else if len(subPath) == 2 {
+		}
+	case subPath[0] == "lights" && len(subPath) == 2:
+		if s.allowMethod(w, r, "GET", address) {
 			s.handleGetLight(w, r, subPath[1])
-		} else if len(subPath) == 3 && subPath[2] == "state" {
+		}
+	case subPath[0] == "lights" && len(subPath) == 3 && subPath[2] == "state":
+		if s.allowMethod(w, r, "PUT", address) {
 			s.handleSetLightState(w, r, subPath[1])
 		}
+	case subPath[0] == "groups" && len(subPath) == 1:
+		if s.allowMethod(w, r, "GET", address) {
+			s.handleGetGroups(w, r)
+		}
+	case subPath[0] == "groups" && len(subPath) == 2:
+		if s.allowMethod(w, r, "GET", address) {
+			s.handleGetGroup(w, r, subPath[1])
+		}
+	case subPath[0] == "groups" && len(subPath) == 3 && subPath[2] == "action":
+		if s.allowMethod(w, r, "PUT", address) {
+			s.handleSetGroupAction(w, r, subPath[1])
+		}
+	default:
+		s.hueErrorResponse(w, errResourceUnavailable(address))
 	}
 }
 
+// allowMethod answers with a Hue error when the resource does not support the request method
+func (s *Server) allowMethod(w http.ResponseWriter, r *http.Request, method, address string) bool {
+	if r.Method != method {
+		s.hueErrorResponse(w, errMethodUnavailable(r.Method, address))
+		return false
+	}
+	return true
+}
+
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.hueErrorResponse(w, errInvalidJSON("/"))
+		return
+	}
+
+	raw, ok := body["devicetype"]
+	if !ok {
+		s.hueErrorResponse(w, errMissingParameters("/"))
+		return
+	}
+	// Hue device types look like "<application_name>#<devicename>" and are at most 40 characters
+	deviceType, ok := raw.(string)
+	if !ok || deviceType == "" || len(deviceType) > 40 {
+		s.hueErrorResponse(w, errInvalidValue("", "devicetype", raw))
+		return
+	}
+
+	user, err := s.whitelist.Register(r.Context(), deviceType)
+	if err != nil {
+		s.hueServiceError(w, "", err)
+		return
+	}
+	slog.Info("Hue API user registered", "devicetype", deviceType)
+
 	s.jsonResponse(w, []map[string]interface{}{
 		{
 			"success": map[string]string{
-				"username": "admin",
+				"username": user.Username,
 			},
 		},
 	})
@@ -55,13 +129,13 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFullState(w http.ResponseWriter, r *http.Request) {
 	devices, err := s.hue.GetDevices(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.hueServiceError(w, "/", err)
 		return
 	}
 
 	lights := make(map[string]*huego.Light)
 	for _, d := range devices {
-		meta := s.hue.GetDeviceMetadata(d.Type)
+		meta := s.lightMetadata(d)
 		lights[d.ID] = &huego.Light{
 			Name:             d.Name,
 			Type:             meta.Type,
@@ -72,32 +146,106 @@ func (s *Server) handleFullState(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	groups, err := s.hue.GetGroups(r.Context())
+	if err != nil {
+		s.hueServiceError(w, "/", err)
+		return
+	}
+
+	config, err := s.bridgeConfig(r)
+	if err != nil {
+		s.hueServiceError(w, "/", err)
+		return
+	}
+
 	fullState := map[string]interface{}{
 		"lights": lights,
-		"groups": make(map[string]interface{}),
-		"config": map[string]interface{}{
-			"name":       "Philips hue",
-			"swversion":  "01003542",
-			"apiversion": "1.11.0",
-			"mac":        "00:17:88:10:22:01",
-			"bridgeid":   "001788FFFE102201",
-			"modelid":    "BSB001",
-		},
+		"groups": s.toHueGroups(groups),
+		"config": config,
 	}
 
 	s.jsonResponse(w, fullState)
 }
 
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := s.bridgeConfig(r)
+	if err != nil {
+		s.hueServiceError(w, "/config", err)
+		return
+	}
+	s.jsonResponse(w, config)
+}
+
+func (s *Server) handlePublicConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := s.publicConfig(r)
+	if err != nil {
+		s.hueServiceError(w, "/config", err)
+		return
+	}
+	s.jsonResponse(w, config)
+}
+
+// publicConfig is the subset of the configuration a bridge returns without a valid username
+func (s *Server) publicConfig(r *http.Request) (map[string]interface{}, error) {
+	identity, err := s.hue.GetBridgeIdentity(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"name":       "Philips hue",
+		"swversion":  "01003542",
+		"apiversion": "1.11.0",
+		"mac":        identity.MAC,
+		"bridgeid":   identity.BridgeID,
+		"modelid":    "BSB001",
+		"factorynew": false,
+	}, nil
+}
+
+type hueWhitelistEntry struct {
+	LastUseDate string `json:"last use date"`
+	CreateDate  string `json:"create date"`
+	Name        string `json:"name"`
+}
+
+// hueDateFormat is the UTC timestamp layout used throughout the Hue API
+const hueDateFormat = "2006-01-02T15:04:05"
+
+func (s *Server) bridgeConfig(r *http.Request) (map[string]interface{}, error) {
+	users, err := s.whitelist.GetUsers(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	whitelist := make(map[string]hueWhitelistEntry, len(users))
+	for _, u := range users {
+		whitelist[u.Username] = hueWhitelistEntry{
+			LastUseDate: u.LastUseDate.UTC().Format(hueDateFormat),
+			CreateDate:  u.CreateDate.UTC().Format(hueDateFormat),
+			Name:        u.DeviceType,
+		}
+	}
+
+	config, err := s.publicConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	config["ipaddress"] = s.ip
+	config["whitelist"] = whitelist
+	config["linkbutton"] = s.whitelist.LinkButtonPressed(r.Context())
+	return config, nil
+}
+
 func (s *Server) handleGetLights(w http.ResponseWriter, r *http.Request) {
 	devices, err := s.hue.GetDevices(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.hueServiceError(w, "/lights", err)
 		return
 	}
 
 	lights := make(map[string]*huego.Light)
 	for _, d := range devices {
-		meta := s.hue.GetDeviceMetadata(d.Type)
+		meta := s.lightMetadata(d)
 		lights[d.ID] = &huego.Light{
 			Name:             d.Name,
 			Type:             meta.Type,
@@ -114,11 +262,11 @@ func (s *Server) handleGetLights(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleGetLight(w http.ResponseWriter, r *http.Request, id string) {
 	device, err := s.hue.GetDevice(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		s.hueServiceError(w, "/lights/"+id, err)
 		return
 	}
 
-	meta := s.hue.GetDeviceMetadata(device.Type)
+	meta := s.lightMetadata(device)
 	l := &huego.Light{
 		Name:             device.Name,
 		Type:             meta.Type,
@@ -131,42 +279,262 @@ func (s *Server) handleGetLight(w http.ResponseWriter, r *http.Request, id strin
 	s.jsonResponse(w, l)
 }
 
+// lightMetadata returns the metadata derived from the entity when there is one, else the one of the
+// mapping type
+func (s *Server) lightMetadata(d *model.Device) model.HueMetadata {
+	if d.Metadata != nil {
+		return *d.Metadata
+	}
+	return s.hue.GetDeviceMetadata(d.Type)
+}
+
 func (s *Server) handleSetLightState(w http.ResponseWriter, r *http.Request, id string) {
-	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	address := fmt.Sprintf("/lights/%s/state", id)
+	if _, err := s.hue.GetDevice(r.Context(), id); err != nil {
+		s.hueServiceError(w, address, err)
 		return
 	}
 
 	var rawState map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&rawState); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.hueErrorResponse(w, errInvalidJSON(address))
 		return
 	}
 
-	stateUpdate := &model.DeviceState{}
-	if on, ok := rawState["on"].(bool); ok {
-		stateUpdate.On = on
-	}
-	if bri, ok := rawState["bri"].(float64); ok {
-		stateUpdate.Bri = uint8(bri)
-		stateUpdate.UpdatedByBri = true
+	stateUpdate, resp := s.parseStateUpdate(address, rawState)
+	if stateUpdate == nil {
+		s.jsonResponse(w, resp)
+		return
 	}
-	// TODO: handle other fields if needed, but for now these are the main ones
 
-	err := s.hue.UpdateDeviceState(r.Context(), id, stateUpdate)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.hue.UpdateDeviceState(r.Context(), id, stateUpdate); err != nil {
+		s.hueServiceError(w, address, err)
 		return
 	}
 
+	s.jsonResponse(w, resp)
+}
+
+// parseStateUpdate validates every parameter like a bridge does: valid ones are applied and
+// acknowledged, invalid ones are reported. The update is nil when nothing valid was sent.
+func (s *Server) parseStateUpdate(address string, rawState map[string]interface{}) (*model.DeviceState, []map[string]interface{}) {
+	if len(rawState) == 0 {
+		return nil, []map[string]interface{}{{"error": errMissingParameters(address)}}
+	}
+
+	keys := make([]string, 0, len(rawState))
+	for k := range rawState {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Commands without "on" keep the device as it is, unless they change brightness or colour
+	stateUpdate := &model.DeviceState{}
+	_, hasBri := rawState["bri"]
+	_, hasCt := rawState["ct"]
 	resp := []map[string]interface{}{}
-	for k, v := range rawState {
+	applied := false
+
+	for _, key := range keys {
+		value := rawState[key]
+		valid := true
+		switch key {
+		case "on":
+			stateUpdate.On, valid = value.(bool)
+			stateUpdate.UpdatedByOn = valid
+		case "bri":
+			var bri float64
+			if bri, valid = inRange(value, 0, 254); valid {
+				stateUpdate.Bri = uint8(bri)
+				stateUpdate.UpdatedByBri = true
+			}
+		case "bri_inc":
+			var inc float64
+			// An absolute brightness takes precedence over the increment
+			if inc, valid = inRange(value, -254, 254); valid && !hasBri {
+				briInc := int16(inc)
+				stateUpdate.BriInc = &briInc
+				stateUpdate.UpdatedByBri = true
+			}
+		case "hue":
+			var hue float64
+			if hue, valid = inRange(value, 0, 65535); valid {
+				stateUpdate.Hue = uint16(hue)
+				stateUpdate.UpdatedByHue = true
+			}
+		case "sat":
+			var sat float64
+			if sat, valid = inRange(value, 0, 254); valid {
+				stateUpdate.Sat = uint8(sat)
+				stateUpdate.UpdatedBySat = true
+			}
+		case "xy":
+			var xy []float32
+			if xy, valid = parseXy(value); valid {
+				stateUpdate.Xy = xy
+				stateUpdate.UpdatedByXy = true
+			}
+		case "ct":
+			var ct float64
+			if ct, valid = inRange(value, 153, 500); valid {
+				stateUpdate.Ct = uint16(ct)
+				stateUpdate.UpdatedByCt = true
+			}
+		case "ct_inc":
+			var inc float64
+			// Larger steps than the whole mired range behave like the range itself
+			if inc, valid = inRange(value, -65534, 65534); valid && !hasCt {
+				ctInc := int16(clamp(inc, -347, 347))
+				stateUpdate.CtInc = &ctInc
+				stateUpdate.UpdatedByCt = true
+			}
+		case "transitiontime":
+			var tt float64
+			if tt, valid = inRange(value, 0, 65535); valid {
+				transition := uint16(tt)
+				stateUpdate.TransitionTime = &transition
+			}
+		case "alert":
+			stateUpdate.Alert, valid = oneOf(value, "none", "select", "lselect")
+		case "effect":
+			stateUpdate.Effect, valid = oneOf(value, "none", "colorloop")
+		default:
+			resp = append(resp, map[string]interface{}{"error": errParameterUnavailable(address, key)})
+			continue
+		}
+
+		if !valid {
+			resp = append(resp, map[string]interface{}{"error": errInvalidValue(address, key, value)})
+			continue
+		}
+		applied = true
 		resp = append(resp, map[string]interface{}{
-			"success": map[string]interface{}{
-				fmt.Sprintf("/lights/%s/state/%s", id, k): v,
-			},
+			"success": map[string]interface{}{address + "/" + key: value},
 		})
 	}
 
+	if !applied {
+		return nil, resp
+	}
+	return stateUpdate, resp
+}
+
+// inRange reads a JSON number and checks it against the parameter bounds
+func inRange(value interface{}, min, max float64) (float64, bool) {
+	v, ok := value.(float64)
+	return v, ok && v >= min && v <= max
+}
+
+func oneOf(value interface{}, allowed ...string) (string, bool) {
+	v, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+	for _, a := range allowed {
+		if v == a {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func parseXy(value interface{}) ([]float32, bool) {
+	xy, ok := value.([]interface{})
+	if !ok || len(xy) != 2 {
+		return nil, false
+	}
+	x, okX := inRange(xy[0], 0, 1)
+	y, okY := inRange(xy[1], 0, 1)
+	if !okX || !okY {
+		return nil, false
+	}
+	return []float32{float32(x), float32(y)}, true
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+type hueGroupState struct {
+	AllOn bool `json:"all_on"`
+	AnyOn bool `json:"any_on"`
+}
+
+type hueGroup struct {
+	Name   string        `json:"name"`
+	Lights []string      `json:"lights"`
+	Type   string        `json:"type"`
+	Class  string        `json:"class,omitempty"`
+	State  hueGroupState `json:"state"`
+	Action *huego.State  `json:"action"`
+}
+
+func (s *Server) toHueGroup(g *model.Group) *hueGroup {
+	return &hueGroup{
+		Name:   g.Name,
+		Lights: g.Lights,
+		Type:   g.Type,
+		Class:  g.Class,
+		State:  hueGroupState{AllOn: g.State.AllOn, AnyOn: g.State.AnyOn},
+		Action: s.toHueState(g.Action),
+	}
+}
+
+func (s *Server) toHueGroups(groups []*model.Group) map[string]*hueGroup {
+	res := make(map[string]*hueGroup, len(groups))
+	for _, g := range groups {
+		res[g.ID] = s.toHueGroup(g)
+	}
+	return res
+}
+
+func (s *Server) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.hue.GetGroups(r.Context())
+	if err != nil {
+		s.hueServiceError(w, "/groups", err)
+		return
+	}
+	s.jsonResponse(w, s.toHueGroups(groups))
+}
+
+func (s *Server) handleGetGroup(w http.ResponseWriter, r *http.Request, id string) {
+	group, err := s.hue.GetGroup(r.Context(), id)
+	if err != nil {
+		s.hueServiceError(w, "/groups/"+id, err)
+		return
+	}
+	s.jsonResponse(w, s.toHueGroup(group))
+}
+
+func (s *Server) handleSetGroupAction(w http.ResponseWriter, r *http.Request, id string) {
+	address := fmt.Sprintf("/groups/%s/action", id)
+	if _, err := s.hue.GetGroup(r.Context(), id); err != nil {
+		s.hueServiceError(w, address, err)
+		return
+	}
+
+	var rawState map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&rawState); err != nil {
+		s.hueErrorResponse(w, errInvalidJSON(address))
+		return
+	}
+
+	stateUpdate, resp := s.parseStateUpdate(address, rawState)
+	if stateUpdate == nil {
+		s.jsonResponse(w, resp)
+		return
+	}
+
+	if err := s.hue.UpdateGroupState(r.Context(), id, stateUpdate); err != nil {
+		s.hueServiceError(w, address, err)
+		return
+	}
+
 	s.jsonResponse(w, resp)
 }
@@ -2,7 +2,9 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
 	"hue-bridge-emulator/internal/ports"
@@ -21,21 +23,30 @@ type Server struct {
 	hue         ports.HueEmulationPort
 	admin       ports.AdminPort
 	authService ports.AuthService
+	whitelist   ports.WhitelistService
 	ip          string
+	port        int
 	setupLimiter map[string]time.Time
 	limiterMu    sync.Mutex
 }
 
-func NewServer(hue ports.HueEmulationPort, admin ports.AdminPort, authService ports.AuthService, ip string) *Server {
+func NewServer(hue ports.HueEmulationPort, admin ports.AdminPort, authService ports.AuthService, whitelist ports.WhitelistService, ip string) *Server {
 	return &Server{
 		hue:          hue,
 		admin:        admin,
 		authService:  authService,
+		whitelist:    whitelist,
 		ip:           ip,
+		port:         80,
 		setupLimiter: make(map[string]time.Time),
 	}
 }
 
+// SetPort sets the port advertised in the bridge description, Alexa expects the default of 80
+func (s *Server) SetPort(port int) {
+	s.port = port
+}
+
 func (s *Server) Mux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleRoot)
@@ -49,6 +60,12 @@ func (s *Server) Mux() *http.ServeMux {
 	mux.Handle("/admin/config", s.withBasicAuth(http.HandlerFunc(s.handleConfig)))
 	mux.Handle("/admin/ha-entities", s.withBasicAuth(http.HandlerFunc(s.handleHAEntities)))
 	mux.Handle("/admin/test-action", s.withBasicAuth(http.HandlerFunc(s.handleAdminTestAction)))
+	mux.Handle("/admin/translate-preview", s.withBasicAuth(http.HandlerFunc(s.handleTranslatePreview)))
+	mux.Handle("/admin/hue-users", s.withBasicAuth(http.HandlerFunc(s.handleHueUsers)))
+	mux.Handle("/admin/link-button", s.withBasicAuth(http.HandlerFunc(s.handleLinkButton)))
+	mux.Handle("/admin/commands", s.withBasicAuth(http.HandlerFunc(s.handleCommands)))
+	mux.Handle("/admin/state-mismatches", s.withBasicAuth(http.HandlerFunc(s.handleStateMismatches)))
+	mux.Handle("/admin/identity/regenerate", s.withBasicAuth(http.HandlerFunc(s.handleRegenerateIdentity)))
 
 	return mux
 }
@@ -57,8 +74,20 @@ func (s *Server) Handler() http.Handler {
 	return s.loggingMiddleware(s.Mux())
 }
 
-func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.Handler())
+// ListenAndServe serves until ctx is cancelled, then lets in-flight requests complete
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func (s *Server) withBasicAuth(next http.Handler) http.Handler {
@@ -104,6 +133,12 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleDescription(w http.ResponseWriter, r *http.Request) {
+	identity, err := s.hue.GetBridgeIdentity(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/xml")
 	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8" ?>
 <root xmlns="urn:schemas-upnp-org:device-1-0">
@@ -111,7 +146,7 @@ func (s *Server) handleDescription(w http.ResponseWriter, r *http.Request) {
 <major>1</major>
 <minor>0</minor>
 </specVersion>
-<URLBase>http://%s:80/</URLBase>
+<URLBase>http://%s:%d/</URLBase>
 <device>
 <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
 <friendlyName>Philips hue (%s)</friendlyName>
@@ -121,11 +156,11 @@ func (s *Server) handleDescription(w http.ResponseWriter, r *http.Request) {
 <modelName>Philips hue bridge 2012</modelName>
 <modelNumber>929000226503</modelNumber>
 <modelURL>http://www.meethue.com</modelURL>
-<serialNumber>001788102201</serialNumber>
-<UDN>uuid:2f402f80-da50-11e1-9b23-001788102201</UDN>
+<serialNumber>%s</serialNumber>
+<UDN>uuid:%s</UDN>
 <presentationURL>admin</presentationURL>
 </device>
-</root>`, s.ip, s.ip)
+</root>`, s.ip, s.port, s.ip, identity.Serial, identity.UUID)
 }
 
 func (s *Server) formatUniqueID(id string) string {
@@ -151,6 +186,8 @@ func (s *Server) toHueState(ds *model.DeviceState) *huego.State {
 		Sat:       ds.Sat,
 		Xy:        ds.Xy,
 		Ct:        ds.Ct,
+		Effect:    ds.Effect,
+		ColorMode: ds.ColorMode,
 		Reachable: ds.Reachable,
 	}
 }
@@ -1,94 +1,292 @@
 package ssdp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type Server struct {
-	ip   string
-	port int
+const (
+	serverName = "FreeRTOS/6.0.5, UPnP/1.1, IpBridge/1.17.0"
+	deviceType = "urn:schemas-upnp-org:device:basic:1"
+)
+
+// DefaultNotifyInterval is the period of the ssdp:alive announcements, below the advertised max-age
+const DefaultNotifyInterval = 60 * time.Second
+
+// Bridge is an emulated bridge advertised on the network
+type Bridge struct {
+	IP       string
+	Port     int
+	Identity *model.BridgeIdentity
 }
 
-func NewServer(ip string) *Server {
-	return &Server{ip: ip, port: 80}
+func (b Bridge) location() string {
+	return fmt.Sprintf("http://%s:%d/description.xml", b.IP, b.Port)
 }
 
-func (s *Server) Start() error {
-	addr, err := net.ResolveUDPAddr("udp4", "239.255.255.250:1900")
-	if err != nil {
-		return err
+type Server struct {
+	bridges        []Bridge
+	group          *net.UDPAddr
+	notifyInterval time.Duration
+}
+
+// NewServer advertises every bridge, a single process may emulate several of them
+func NewServer(bridges ...Bridge) *Server {
+	return &Server{
+		bridges:        bridges,
+		group:          &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900},
+		notifyInterval: DefaultNotifyInterval,
 	}
+}
+
+func (s *Server) SetNotifyInterval(interval time.Duration) {
+	s.notifyInterval = interval
+}
 
+// Start answers searches and announces the bridge on every multicast interface until ctx is
+// cancelled, then sends ssdp:byebye and returns.
+func (s *Server) Start(ctx context.Context) error {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return err
 	}
 
-	started := 0
+	var wg sync.WaitGroup
+	var conns []*net.UDPConn
 	for _, iface := range ifaces {
 		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
 			continue
 		}
 		iface := iface
-		conn, err := net.ListenMulticastUDP("udp4", &iface, addr)
+		conn, err := net.ListenMulticastUDP("udp4", &iface, s.group)
 		if err != nil {
 			slog.Warn("SSDP: skipping interface", "interface", iface.Name, "error", err)
 			continue
 		}
 		slog.Info("SSDP: listening on interface", "interface", iface.Name)
-		started++
-		go s.listen(conn)
+		conns = append(conns, conn)
+		go s.listen(ctx, conn)
+
+		// Binding the source address makes the kernel send the multicast on this interface
+		for _, ip := range interfaceIPv4s(&iface) {
+			notifyConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+			if err != nil {
+				slog.Warn("SSDP: cannot announce on interface", "interface", iface.Name, "ip", ip, "error", err)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer notifyConn.Close()
+				s.announce(ctx, notifyConn)
+			}()
+		}
 	}
 
-	if started == 0 {
+	if len(conns) == 0 {
 		return fmt.Errorf("SSDP: no multicast interface available")
 	}
 
-	select {}
+	<-ctx.Done()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func interfaceIPv4s(iface *net.Interface) []net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	return ips
 }
 
-func (s *Server) listen(conn *net.UDPConn) {
-	buf := make([]byte, 1024)
+func (s *Server) listen(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, 2048)
 	for {
-		n, src, err := conn.ReadFromUDP(buf)
+		n, src, err := conn.ReadFrom(buf)
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			slog.Error("SSDP: read error", "error", err)
 			continue
 		}
 
-		msg := string(buf[:n])
-		slog.Debug("SSDP: received packet", "bytes", n, "from", src, "message", msg)
-		if strings.Contains(msg, "M-SEARCH") {
-			// Echo Dot 3 often searches for urn:schemas-upnp-org:device:basic:1 or upnp:rootdevice
-			if strings.Contains(msg, "urn:schemas-upnp-org:device:basic:1") ||
-				strings.Contains(msg, "upnp:rootdevice") ||
-				strings.Contains(msg, "ssdp:all") {
-				slog.Info("SSDP: responding to M-SEARCH", "from", src)
-				s.respond(src)
+		slog.Debug("SSDP: received packet", "bytes", n, "from", src, "message", string(buf[:n]))
+		req, err := parseSearch(buf[:n])
+		if err != nil {
+			slog.Debug("SSDP: ignoring packet", "from", src, "reason", err)
+			continue
+		}
+		for _, b := range s.bridges {
+			variants := searchResponses(b, req.st)
+			if len(variants) == 0 {
+				continue
 			}
+			slog.Info("SSDP: responding to M-SEARCH", "from", src, "st", req.st, "mx", req.mx, "bridgeid", b.Identity.BridgeID)
+			go s.respond(ctx, conn, src, req, b, variants)
 		}
 	}
 }
 
-func (s *Server) respond(dest *net.UDPAddr) {
-	conn, err := net.DialUDP("udp4", nil, dest)
+// maxMX caps the response delay, as required by UPnP 1.1
+const maxMX = 5
+
+// mxUnit is the unit of the MX header
+var mxUnit = time.Second
+
+type searchRequest struct {
+	st string
+	mx int
+}
+
+// parseSearch validates an M-SEARCH request and extracts its search target and maximum wait
+func parseSearch(packet []byte) (*searchRequest, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet)))
 	if err != nil {
-		slog.Error("SSDP: failed to respond", "dest", dest, "error", err)
-		return
+		return nil, err
+	}
+	if req.Method != "M-SEARCH" {
+		return nil, fmt.Errorf("not a search: %s", req.Method)
+	}
+	if man := strings.Trim(req.Header.Get("MAN"), `"`); man != "ssdp:discover" {
+		return nil, fmt.Errorf("unsupported MAN %q", man)
 	}
-	defer conn.Close()
 
-	resp := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
-		"CACHE-CONTROL: max-age=100\r\n"+
-		"EXT:\r\n"+
-		"LOCATION: http://%s:%d/description.xml\r\n"+
-		"SERVER: FreeRTOS/6.0.5, UPnP/1.1, IpBridge/1.17.0\r\n"+
-		"ST: urn:schemas-upnp-org:device:basic:1\r\n"+
-		"USN: uuid:2f402f80-da50-11e1-9b23-001788102201::urn:schemas-upnp-org:device:basic:1\r\n\r\n", s.ip, s.port)
+	st := strings.TrimSpace(req.Header.Get("ST"))
+	if st == "" {
+		return nil, fmt.Errorf("missing ST")
+	}
 
-	slog.Info("SSDP: sent response", "dest", dest)
-	conn.Write([]byte(resp))
+	// Unicast searches may omit MX, a present one must be a non-negative integer
+	mx := 0
+	if raw := req.Header.Get("MX"); raw != "" {
+		mx, err = strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || mx < 0 {
+			return nil, fmt.Errorf("invalid MX %q", raw)
+		}
+	}
+	if mx > maxMX {
+		mx = maxMX
+	}
+
+	return &searchRequest{st: st, mx: mx}, nil
+}
+
+// searchResponses returns the ST/USN pairs of b answering a search target; ssdp:all gets every variant
+func searchResponses(b Bridge, st string) [][2]string {
+	var res [][2]string
+	for _, nt := range notificationTypes(b) {
+		if st == "ssdp:all" || strings.EqualFold(st, nt[0]) {
+			// Echo the requested search target
+			if st != "ssdp:all" {
+				nt[0] = st
+			}
+			res = append(res, nt)
+		}
+	}
+	return res
+}
+
+// respond waits a random delay up to MX, spreading the load of simultaneous responders
+func (s *Server) respond(ctx context.Context, conn net.PacketConn, dest net.Addr, req *searchRequest, b Bridge, variants [][2]string) {
+	if req.mx > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rand.N(time.Duration(req.mx) * mxUnit)):
+		}
+	}
+
+	for _, v := range variants {
+		resp := "HTTP/1.1 200 OK\r\n" +
+			fmt.Sprintf("HOST: %s\r\n", s.group) +
+			"EXT:\r\n" +
+			"CACHE-CONTROL: max-age=100\r\n" +
+			fmt.Sprintf("LOCATION: %s\r\n", b.location()) +
+			fmt.Sprintf("SERVER: %s\r\n", serverName) +
+			fmt.Sprintf("hue-bridgeid: %s\r\n", b.Identity.BridgeID) +
+			fmt.Sprintf("ST: %s\r\n", v[0]) +
+			fmt.Sprintf("USN: %s\r\n", v[1]) +
+			"\r\n"
+
+		if _, err := conn.WriteTo([]byte(resp), dest); err != nil {
+			slog.Error("SSDP: failed to respond", "dest", dest, "error", err)
+			return
+		}
+	}
+	slog.Info("SSDP: sent response", "dest", dest, "variants", len(variants), "bridgeid", b.Identity.BridgeID)
+}
+
+// announce multicasts ssdp:alive every notifyInterval and ssdp:byebye once ctx is cancelled
+func (s *Server) announce(ctx context.Context, conn net.PacketConn) {
+	s.notify(conn, "ssdp:alive")
+
+	ticker := time.NewTicker(s.notifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.notify(conn, "ssdp:byebye")
+			return
+		case <-ticker.C:
+			s.notify(conn, "ssdp:alive")
+		}
+	}
+}
+
+// notificationTypes lists the NT/USN pairs a Hue bridge announces
+func notificationTypes(b Bridge) [][2]string {
+	usn := "uuid:" + b.Identity.UUID
+	return [][2]string{
+		{"upnp:rootdevice", usn + "::upnp:rootdevice"},
+		{usn, usn},
+		{deviceType, usn + "::" + deviceType},
+	}
+}
+
+func (s *Server) notify(conn net.PacketConn, nts string) {
+	for _, b := range s.bridges {
+		for _, nt := range notificationTypes(b) {
+			msg := "NOTIFY * HTTP/1.1\r\n" +
+				fmt.Sprintf("HOST: %s\r\n", s.group)
+			if nts == "ssdp:alive" {
+				msg += "CACHE-CONTROL: max-age=100\r\n" +
+					fmt.Sprintf("LOCATION: %s\r\n", b.location()) +
+					fmt.Sprintf("SERVER: %s\r\n", serverName)
+			}
+			msg += fmt.Sprintf("NTS: %s\r\n", nts) +
+				fmt.Sprintf("NT: %s\r\n", nt[0]) +
+				fmt.Sprintf("USN: %s\r\n", nt[1])
+			if nts == "ssdp:alive" {
+				msg += fmt.Sprintf("hue-bridgeid: %s\r\n", b.Identity.BridgeID)
+			}
+			msg += "\r\n"
+
+			if _, err := conn.WriteTo([]byte(msg), s.group); err != nil {
+				slog.Warn("SSDP: failed to send notification", "nts", nts, "nt", nt[0], "from", conn.LocalAddr(), "error", err)
+			}
+		}
+	}
+	slog.Debug("SSDP: sent notifications", "nts", nts, "bridges", len(s.bridges), "from", conn.LocalAddr())
 }
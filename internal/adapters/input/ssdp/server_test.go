@@ -0,0 +1,243 @@
+package ssdp
+
+import (
+	"context"
+	"hue-bridge-emulator/internal/domain/model"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testIdentity = model.NewBridgeIdentity([6]byte{0x02, 0xaa, 0xbb, 0xcc, 0xdd, 0xee})
+
+var testBridge = Bridge{IP: "192.168.1.10", Port: 80, Identity: testIdentity}
+
+// newLoopbackServer returns a server whose multicast group is a loopback socket owned by the test
+func newLoopbackServer(t *testing.T) (*Server, *net.UDPConn) {
+	t.Helper()
+	group, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { group.Close() })
+
+	s := NewServer(testBridge)
+	s.group = group.LocalAddr().(*net.UDPAddr)
+	return s, group
+}
+
+func readPackets(t *testing.T, conn *net.UDPConn, n int) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 2048)
+	for len(packets) < n {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		size, _, err := conn.ReadFromUDP(buf)
+		require.NoError(t, err)
+		packets = append(packets, string(buf[:size]))
+	}
+	return packets
+}
+
+func header(packet, name string) string {
+	for _, line := range strings.Split(packet, "\r\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func TestServer_Announce(t *testing.T) {
+	s, group := newLoopbackServer(t)
+	s.SetNotifyInterval(20 * time.Millisecond)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.announce(ctx, conn)
+		close(done)
+	}()
+
+	// Initial burst plus at least one periodic repetition
+	packets := readPackets(t, group, 6)
+	var nts []string
+	for _, p := range packets[:3] {
+		assert.True(t, strings.HasPrefix(p, "NOTIFY * HTTP/1.1\r\n"))
+		assert.Equal(t, "ssdp:alive", header(p, "NTS"))
+		assert.Equal(t, "http://192.168.1.10:80/description.xml", header(p, "LOCATION"))
+		assert.Equal(t, testIdentity.BridgeID, header(p, "hue-bridgeid"))
+		nts = append(nts, header(p, "NT"))
+	}
+	assert.Equal(t, []string{"upnp:rootdevice", "uuid:" + testIdentity.UUID, deviceType}, nts)
+	assert.Equal(t, "uuid:"+testIdentity.UUID+"::upnp:rootdevice", header(packets[0], "USN"))
+	assert.Equal(t, "ssdp:alive", header(packets[5], "NTS"))
+
+	cancel()
+	<-done
+
+	// Drain any alive sent before cancellation, then expect the byebye burst
+	var byebye []string
+	buf := make([]byte, 2048)
+	for len(byebye) < 3 {
+		group.SetReadDeadline(time.Now().Add(2 * time.Second))
+		size, _, err := group.ReadFromUDP(buf)
+		require.NoError(t, err)
+		if p := string(buf[:size]); header(p, "NTS") == "ssdp:byebye" {
+			byebye = append(byebye, p)
+		}
+	}
+	for _, p := range byebye {
+		assert.Empty(t, header(p, "LOCATION"))
+	}
+	assert.Equal(t, deviceType, header(byebye[2], "NT"))
+}
+
+func search(st, mx string) string {
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n"
+	if mx != "" {
+		msg += "MX: " + mx + "\r\n"
+	}
+	return msg + "ST: " + st + "\r\n\r\n"
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  string
+		want    *searchRequest
+		wantErr bool
+	}{
+		{"basic", search(deviceType, "3"), &searchRequest{st: deviceType, mx: 3}, false},
+		{"mx capped", search("ssdp:all", "120"), &searchRequest{st: "ssdp:all", mx: maxMX}, false},
+		{"unicast without mx", search("upnp:rootdevice", ""), &searchRequest{st: "upnp:rootdevice"}, false},
+		{"lower case headers", "M-SEARCH * HTTP/1.1\r\nman: ssdp:discover\r\nmx: 1\r\nst: ssdp:all\r\n\r\n", &searchRequest{st: "ssdp:all", mx: 1}, false},
+		{"invalid mx", search("ssdp:all", "soon"), nil, true},
+		{"negative mx", search("ssdp:all", "-1"), nil, true},
+		{"missing st", "M-SEARCH * HTTP/1.1\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\n\r\n", nil, true},
+		{"wrong man", "M-SEARCH * HTTP/1.1\r\nMAN: \"ssdp:update\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n", nil, true},
+		{"notify", "NOTIFY * HTTP/1.1\r\nNT: upnp:rootdevice\r\nNTS: ssdp:alive\r\n\r\n", nil, true},
+		{"garbage", "hello", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSearch([]byte(tt.packet))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSearchResponses(t *testing.T) {
+	usn := "uuid:" + testIdentity.UUID
+	assert.Equal(t, [][2]string{
+		{"upnp:rootdevice", usn + "::upnp:rootdevice"},
+		{usn, usn},
+		{deviceType, usn + "::" + deviceType},
+	}, searchResponses(testBridge, "ssdp:all"))
+	assert.Equal(t, [][2]string{{"urn:Schemas-UPnP-org:device:Basic:1", usn + "::" + deviceType}},
+		searchResponses(testBridge, "urn:Schemas-UPnP-org:device:Basic:1"))
+	assert.Equal(t, [][2]string{{usn, usn}}, searchResponses(testBridge, usn))
+	assert.Empty(t, searchResponses(testBridge, "urn:schemas-upnp-org:device:MediaRenderer:1"))
+	assert.Empty(t, searchResponses(testBridge, "uuid:someone-else"))
+}
+
+// startLoopbackListener serves searches for bridges on a loopback socket and returns a client socket
+func startLoopbackListener(t *testing.T, bridges ...Bridge) (*net.UDPConn, *net.UDPAddr) {
+	t.Helper()
+	mxUnit = 50 * time.Millisecond
+	t.Cleanup(func() { mxUnit = time.Second })
+
+	s := NewServer(bridges...)
+	serverConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		serverConn.Close()
+	})
+	go s.listen(ctx, serverConn)
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, serverConn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestServer_Search(t *testing.T) {
+	client, server := startLoopbackListener(t, testBridge)
+
+	t.Run("ssdp:all gets every variant", func(t *testing.T) {
+		start := time.Now()
+		_, err := client.WriteToUDP([]byte(search("ssdp:all", "2")), server)
+		require.NoError(t, err)
+
+		packets := readPackets(t, client, 3)
+		// Random delay below MX, all variants sent together
+		assert.Less(t, time.Since(start), 2*mxUnit+500*time.Millisecond)
+		var sts []string
+		for _, p := range packets {
+			assert.True(t, strings.HasPrefix(p, "HTTP/1.1 200 OK\r\n"))
+			assert.Equal(t, "http://192.168.1.10:80/description.xml", header(p, "LOCATION"))
+			assert.Equal(t, testIdentity.BridgeID, header(p, "hue-bridgeid"))
+			assert.Equal(t, "max-age=100", header(p, "CACHE-CONTROL"))
+			sts = append(sts, header(p, "ST"))
+		}
+		assert.Equal(t, []string{"upnp:rootdevice", "uuid:" + testIdentity.UUID, deviceType}, sts)
+		assert.Equal(t, "uuid:"+testIdentity.UUID+"::"+deviceType, header(packets[2], "USN"))
+	})
+
+	t.Run("specific target is echoed", func(t *testing.T) {
+		_, err := client.WriteToUDP([]byte(search("upnp:rootdevice", "1")), server)
+		require.NoError(t, err)
+
+		packets := readPackets(t, client, 1)
+		assert.Equal(t, "upnp:rootdevice", header(packets[0], "ST"))
+		assert.Equal(t, "uuid:"+testIdentity.UUID+"::upnp:rootdevice", header(packets[0], "USN"))
+	})
+
+	t.Run("ignored requests", func(t *testing.T) {
+		for _, packet := range []string{
+			search("urn:schemas-upnp-org:device:MediaRenderer:1", "1"),
+			search("ssdp:all", "soon"),
+			"NOTIFY * HTTP/1.1\r\nNT: upnp:rootdevice\r\nNTS: ssdp:alive\r\n\r\n",
+		} {
+			_, err := client.WriteToUDP([]byte(packet), server)
+			require.NoError(t, err)
+		}
+
+		client.SetReadDeadline(time.Now().Add(3 * mxUnit))
+		_, _, err := client.ReadFromUDP(make([]byte, 2048))
+		assert.Error(t, err)
+	})
+}
+
+func TestServer_SearchMultipleBridges(t *testing.T) {
+	second := Bridge{IP: "192.168.1.11", Port: 8080, Identity: model.NewBridgeIdentity([6]byte{0x02, 0x11, 0x22, 0x33, 0x44, 0x55})}
+	client, server := startLoopbackListener(t, testBridge, second)
+
+	_, err := client.WriteToUDP([]byte(search("upnp:rootdevice", "1")), server)
+	require.NoError(t, err)
+
+	// Each bridge answers for itself
+	locations := map[string]string{}
+	for _, p := range readPackets(t, client, 2) {
+		locations[header(p, "hue-bridgeid")] = header(p, "LOCATION")
+	}
+	assert.Equal(t, map[string]string{
+		testIdentity.BridgeID:    "http://192.168.1.10:80/description.xml",
+		second.Identity.BridgeID: "http://192.168.1.11:8080/description.xml",
+	}, locations)
+}
@@ -0,0 +1,94 @@
+package homeassistant
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Home Assistant while it is considered down
+var ErrCircuitOpen = errors.New("Home Assistant unavailable, circuit breaker open")
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// breaker opens after threshold consecutive failures. Once the cooldown has elapsed a single
+// trial call goes through: its success closes the breaker, its failure opens it again.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func newBreaker() *breaker {
+	return &breaker{threshold: DefaultBreakerThreshold, cooldown: DefaultBreakerCooldown, now: time.Now}
+}
+
+func (b *breaker) configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+// allow reports whether a call may be attempted
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record accounts for the outcome of an allowed call
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		if b.failures >= b.threshold {
+			slog.Info("Home Assistant reachable again, circuit breaker closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		if b.failures == b.threshold {
+			slog.Warn("Home Assistant unreachable, circuit breaker open", "failures", b.failures, "cooldown", b.cooldown)
+		}
+	}
+}
+
+// release ends an allowed call that says nothing about Home Assistant, e.g. a cancelled one
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures < b.threshold
+}
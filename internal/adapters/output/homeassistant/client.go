@@ -4,34 +4,80 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
 	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
+const (
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxAttempts = 3
+)
+
+// retryBaseDelay is the backoff before the first retry, doubled for each further attempt
+var retryBaseDelay = 250 * time.Millisecond
+
+const retryMaxDelay = 5 * time.Second
+
 type Client struct {
-	url        string
-	token      string
-	httpClient *http.Client
-	mu         sync.RWMutex
+	url         string
+	token       string
+	httpClient  *http.Client
+	mu          sync.RWMutex
+	timeout     time.Duration
+	maxAttempts int
+	breaker     *breaker
 }
 
 func NewClient() *Client {
 	return &Client{
-		httpClient: &http.Client{},
+		httpClient:  &http.Client{},
+		timeout:     DefaultTimeout,
+		maxAttempts: DefaultMaxAttempts,
+		breaker:     newBreaker(),
 	}
 }
 
+// SetTimeout bounds every request made to Home Assistant
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+}
+
+// SetMaxAttempts sets how many times a failed idempotent call is tried, 1 disables retries
+func (c *Client) SetMaxAttempts(attempts int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxAttempts = attempts
+}
+
+// SetBreaker opens the circuit after threshold consecutive failures for cooldown
+func (c *Client) SetBreaker(threshold int, cooldown time.Duration) {
+	c.breaker.configure(threshold, cooldown)
+}
+
+// Available reports whether Home Assistant answers, false while the circuit breaker is open
+func (c *Client) Available() bool {
+	return c.breaker.closed()
+}
+
 
 func (c *Client) Configure(url, token string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.url = strings.TrimSuffix(url, "/")
 	c.token = token
+	// Failures of the previous server say nothing about the new one
+	c.breaker.reset()
 }
 
 func (c *Client) IsConfigured() bool {
@@ -51,30 +97,26 @@ func (c *Client) GetRawStates(ctx context.Context) ([]model.HAEntityState, error
 		return nil, fmt.Errorf("Home Assistant not configured")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url+"/api/states", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HA API error: %d", resp.StatusCode)
-	}
+	var states []haState
+	err := c.call(ctx, retryRead, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url+"/api/states", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
 
-	type haState struct {
-		EntityID   string         `json:"entity_id"`
-		State      string         `json:"state"`
-		Attributes model.HAFields `json:"attributes"`
-	}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
 
-	var states []haState
-	if err := json.NewDecoder(resp.Body).Decode(&states); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &model.HAStatusError{StatusCode: resp.StatusCode}
+		}
+		return json.NewDecoder(resp.Body).Decode(&states)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -89,14 +131,14 @@ func (c *Client) GetRawStates(ctx context.Context) ([]model.HAEntityState, error
 	return res, nil
 }
 
-func (c *Client) SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) error {
+func (c *Client) SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) (int, error) {
 	c.mu.RLock()
 	urlBase := c.url
 	token := c.token
 	c.mu.RUnlock()
 
 	if urlBase == "" || token == "" {
-		return fmt.Errorf("Home Assistant not configured")
+		return 0, fmt.Errorf("Home Assistant not configured")
 	}
 
 	domain := strings.Split(device.ExternalID, ".")[0]
@@ -126,21 +168,9 @@ func (c *Client) SetState(ctx context.Context, device *model.Device, cmd model.H
 
 	slog.Info("HA Service Call", "pid", os.Getpid(), "url", url, "payload", string(body))
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	status, err := c.callService(ctx, url, token, body)
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HA API error: %d", resp.StatusCode)
+		return status, err
 	}
 
 	// Handle custom effects if provided
@@ -148,7 +178,7 @@ func (c *Client) SetState(ctx context.Context, device *model.Device, cmd model.H
 		c.executeEffect(ctx, cmd.Effect, urlBase, token)
 	}
 
-	return nil
+	return status, nil
 }
 
 func (c *Client) executeEffect(ctx context.Context, effect, urlBase, token string) {
@@ -158,21 +188,103 @@ func (c *Client) executeEffect(ctx context.Context, effect, urlBase, token strin
 	}
 
 	url := fmt.Sprintf("%s/api/services/%s/%s", urlBase, parts[0], parts[1])
-	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
-	if err != nil {
-		slog.Error("Failed to create request for effect", "error", err, "url", url)
-		return
+	if _, err := c.callService(ctx, url, token, nil); err != nil {
+		slog.Warn("Failed to execute effect", "error", err, "url", url)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		slog.Error("Failed to execute effect", "error", err, "url", url)
-		return
+}
+
+// callService posts body to a Home Assistant service URL. Service calls are not idempotent,
+// they are only retried when HA never received them.
+// callService posts body to the service at url, returning the HTTP status of the last attempt
+func (c *Client) callService(ctx context.Context, url, token string, body []byte) (int, error) {
+	var status int
+	err := c.call(ctx, retryUnsent, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		status = resp.StatusCode
+		if resp.StatusCode >= 400 {
+			return &model.HAStatusError{StatusCode: resp.StatusCode}
+		}
+		return nil
+	})
+	return status, err
+}
+
+// haDown reports whether err means Home Assistant could not serve the request
+func haDown(err error) bool {
+	var status *model.HAStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500
 	}
-	defer resp.Body.Close()
+	return err != nil
+}
+
+// retryRead retries reads on any failure of Home Assistant
+func retryRead(err error) bool {
+	return haDown(err)
+}
+
+// retryUnsent retries when the connection could not be established, the request was never sent
+func retryUnsent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
 
-	if resp.StatusCode >= 400 {
-		slog.Warn("Effect call returned error", "status", resp.StatusCode, "url", url)
+// call runs attempt with a per-request timeout, retrying with jittered exponential backoff while
+// retryable accepts the error. The circuit breaker fails fast while Home Assistant is down.
+func (c *Client) call(ctx context.Context, retryable func(error) bool, attempt func(ctx context.Context) error) error {
+	c.mu.RLock()
+	timeout, maxAttempts := c.timeout, c.maxAttempts
+	c.mu.RUnlock()
+
+	var err error
+	for i := 1; ; i++ {
+		if err = c.breaker.allow(); err != nil {
+			return err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = attempt(attemptCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			// The caller gave up, this says nothing about Home Assistant
+			c.breaker.release()
+			return err
+		}
+		c.breaker.record(!haDown(err))
+		if err == nil || i >= maxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := backoff(i)
+		slog.Warn("HA call failed, retrying", "attempt", i, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
 	}
 }
 
+// backoff returns the delay after the given attempt: half of it fixed, half random
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,214 @@
+package homeassistant
+
+import (
+	"context"
+	"hue-bridge-emulator/internal/domain/model"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a client for a server answering with the given status codes in turn,
+// then 200, and the number of requests received
+func newTestClient(t *testing.T, statuses ...int) (*Client, *atomic.Int32) {
+	t.Helper()
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 250 * time.Millisecond })
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(`[{"entity_id":"light.desk","state":"on","attributes":{}}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewClient()
+	c.Configure(srv.URL, "token")
+	return c, &requests
+}
+
+var testDevice = &model.Device{ExternalID: "light.desk"}
+
+func TestClient_RetriesReads(t *testing.T) {
+	c, requests := newTestClient(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+	states, err := c.GetRawStates(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "light.desk", states[0].EntityID)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Client errors are not retried
+	c, requests = newTestClient(t, http.StatusUnauthorized)
+	_, err = c.GetRawStates(context.Background())
+	assert.EqualError(t, err, "HA API error: 401")
+	assert.Equal(t, int32(1), requests.Load())
+
+	// Attempts are bounded
+	c, requests = newTestClient(t, 500, 500, 500, 500)
+	c.SetMaxAttempts(2)
+	_, err = c.GetRawStates(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestClient_ServiceCallsNotRetriedOnceSent(t *testing.T) {
+	c, requests := newTestClient(t, http.StatusInternalServerError)
+	status, err := c.SetState(context.Background(), testDevice, model.HomeAssistantCommand{Service: "turn_on"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// A refused connection never reached HA and is retried
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	c.Configure("http://"+addr, "token")
+	var dials atomic.Int32
+	c.httpClient.Transport = &http.Transport{DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}}
+	status, err = c.SetState(context.Background(), testDevice, model.HomeAssistantCommand{Service: "turn_on"})
+	assert.Error(t, err)
+	assert.Zero(t, status)
+	assert.Equal(t, int32(DefaultMaxAttempts), dials.Load())
+}
+
+func TestClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewClient()
+	c.Configure(srv.URL, "token")
+	c.SetTimeout(20 * time.Millisecond)
+	c.SetMaxAttempts(1)
+
+	start := time.Now()
+	_, err := c.GetRawStates(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_EffectTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/services/script/slow" {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewClient()
+	c.Configure(srv.URL, "token")
+	c.SetTimeout(20 * time.Millisecond)
+	c.SetMaxAttempts(1)
+
+	// A hanging effect does not block the command, it still counts for the breaker
+	start := time.Now()
+	status, err := c.SetState(context.Background(), testDevice, model.HomeAssistantCommand{Service: "turn_on", Effect: "script.slow"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 1, c.breaker.failures)
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	c, requests := newTestClient(t, 500, 500, 500)
+	c.SetMaxAttempts(1)
+	c.SetBreaker(2, time.Minute)
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := c.GetRawStates(ctx)
+	assert.Error(t, err)
+	assert.True(t, c.Available())
+	_, err = c.GetRawStates(ctx)
+	assert.Error(t, err)
+	assert.False(t, c.Available())
+
+	// Open: fail fast without contacting HA
+	_, err = c.GetRawStates(ctx)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	_, err = c.SetState(ctx, testDevice, model.HomeAssistantCommand{Service: "turn_on"})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// After the cooldown a failed trial opens it again
+	now = now.Add(time.Minute)
+	_, err = c.GetRawStates(ctx)
+	assert.EqualError(t, err, "HA API error: 500")
+	_, err = c.GetRawStates(ctx)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A successful trial closes it
+	now = now.Add(time.Minute)
+	_, err = c.GetRawStates(ctx)
+	assert.NoError(t, err)
+	assert.True(t, c.Available())
+}
+
+func TestClient_CircuitBreakerHalfOpen(t *testing.T) {
+	b := newBreaker()
+	b.configure(1, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	assert.NoError(t, b.allow())
+	b.record(false)
+	assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+
+	// A single trial at a time
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.allow())
+	assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+
+	// An abandoned trial lets the next call try
+	b.release()
+	assert.NoError(t, b.allow())
+
+	// Reconfiguring HA starts over
+	b.reset()
+	assert.True(t, b.closed())
+}
+
+func TestClient_CallerCancellation(t *testing.T) {
+	c, requests := newTestClient(t, 500, 500, 500, 500, 500)
+	c.SetBreaker(1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetRawStates(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(0), requests.Load())
+	// Abandoned calls do not count as failures
+	assert.True(t, c.Available())
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 8; attempt++ {
+		d := backoff(attempt)
+		full := retryBaseDelay << (attempt - 1)
+		if full > retryMaxDelay {
+			full = retryMaxDelay
+		}
+		assert.GreaterOrEqual(t, d, full/2)
+		assert.LessOrEqual(t, d, full)
+	}
+	assert.LessOrEqual(t, backoff(64), retryMaxDelay)
+}
@@ -0,0 +1,241 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ReconnectMinBackoff and ReconnectMaxBackoff bound the delay between two connection attempts
+	ReconnectMinBackoff = 1 * time.Second
+	ReconnectMaxBackoff = 30 * time.Second
+	// PingInterval is the heartbeat period; the connection is dropped after two missed heartbeats
+	PingInterval = 30 * time.Second
+)
+
+const (
+	subscribeID = 1
+	getStatesID = 2
+)
+
+// EventStream subscribes to state_changed events over the Home Assistant WebSocket API
+type EventStream struct {
+	url          string
+	token        string
+	mu           sync.RWMutex
+	reconfigured chan struct{}
+	dialer       *websocket.Dialer
+}
+
+type wsMessage struct {
+	ID          int             `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	Success     *bool           `json:"success,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Event       *wsEvent        `json:"event,omitempty"`
+	AccessToken string          `json:"access_token,omitempty"`
+	EventType   string          `json:"event_type,omitempty"`
+	Message     string          `json:"message,omitempty"`
+}
+
+type wsEvent struct {
+	EventType string `json:"event_type"`
+	Data      struct {
+		EntityID string   `json:"entity_id"`
+		NewState *haState `json:"new_state"`
+	} `json:"data"`
+}
+
+type haState struct {
+	EntityID   string         `json:"entity_id"`
+	State      string         `json:"state"`
+	Attributes model.HAFields `json:"attributes"`
+}
+
+func NewEventStream() *EventStream {
+	return &EventStream{
+		reconfigured: make(chan struct{}, 1),
+		dialer:       &websocket.Dialer{HandshakeTimeout: 10 * time.Second},
+	}
+}
+
+func (e *EventStream) Configure(url, token string) {
+	e.mu.Lock()
+	e.url = strings.TrimSuffix(url, "/")
+	e.token = token
+	e.mu.Unlock()
+
+	// Wake up Run so that the current session is replaced
+	select {
+	case e.reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps a subscription open until ctx is cancelled, reconnecting with exponential backoff
+func (e *EventStream) Run(ctx context.Context, handler ports.StateChangeHandler) {
+	backoff := ReconnectMinBackoff
+	for {
+		e.mu.RLock()
+		url, token := e.url, e.token
+		e.mu.RUnlock()
+
+		if url != "" && token != "" {
+			authenticated, err := e.session(ctx, url, token, handler)
+			if ctx.Err() != nil {
+				return
+			}
+			if authenticated {
+				backoff = ReconnectMinBackoff
+			}
+			slog.Warn("HA WebSocket: disconnected", "error", err, "retry_in", backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.reconfigured:
+			backoff = ReconnectMinBackoff
+		case <-time.After(backoff):
+			backoff *= 2
+			if backoff > ReconnectMaxBackoff {
+				backoff = ReconnectMaxBackoff
+			}
+		}
+	}
+}
+
+// session runs a single connection and reports whether authentication succeeded
+func (e *EventStream) session(ctx context.Context, url, token string, handler ports.StateChangeHandler) (bool, error) {
+	conn, _, err := e.dialer.DialContext(ctx, websocketURL(url), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Close the connection on shutdown or reconfiguration to unblock reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-e.reconfigured:
+			// Re-arm the signal so that Run reconnects immediately
+			select {
+			case e.reconfigured <- struct{}{}:
+			default:
+			}
+		case <-done:
+			return
+		}
+		conn.Close()
+	}()
+
+	if err := e.authenticate(conn, token); err != nil {
+		return false, err
+	}
+	slog.Info("HA WebSocket: authenticated", "url", url)
+
+	if err := conn.WriteJSON(wsMessage{ID: subscribeID, Type: "subscribe_events", EventType: "state_changed"}); err != nil {
+		return true, err
+	}
+	// Resynchronise everything that changed while we were disconnected
+	if err := conn.WriteJSON(wsMessage{ID: getStatesID, Type: "get_states"}); err != nil {
+		return true, err
+	}
+
+	go e.heartbeat(conn, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * PingInterval))
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return true, err
+		}
+
+		switch {
+		case msg.Type == "event" && msg.Event != nil && msg.Event.EventType == "state_changed":
+			handler.ApplyStateChange(ctx, toEntityState(msg.Event.Data.EntityID, msg.Event.Data.NewState))
+		case msg.Type == "result" && msg.Success != nil && !*msg.Success:
+			return true, fmt.Errorf("HA WebSocket: command %d failed", msg.ID)
+		case msg.Type == "result" && msg.ID == getStatesID:
+			var states []haState
+			if err := json.Unmarshal(msg.Result, &states); err != nil {
+				return true, err
+			}
+			for i := range states {
+				handler.ApplyStateChange(ctx, toEntityState(states[i].EntityID, &states[i]))
+			}
+		}
+	}
+}
+
+func (e *EventStream) authenticate(conn *websocket.Conn, token string) error {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("HA WebSocket: unexpected message %q", msg.Type)
+	}
+
+	if err := conn.WriteJSON(wsMessage{Type: "auth", AccessToken: token}); err != nil {
+		return err
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		return err
+	}
+	if msg.Type != "auth_ok" {
+		return fmt.Errorf("HA WebSocket: authentication failed: %s", msg.Message)
+	}
+	return nil
+}
+
+func (e *EventStream) heartbeat(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	id := getStatesID
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			id++
+			if err := conn.WriteJSON(wsMessage{ID: id, Type: "ping"}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// websocketURL derives the WebSocket endpoint from the configured REST base URL
+func websocketURL(url string) string {
+	if strings.HasPrefix(url, "https://") {
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	} else if strings.HasPrefix(url, "http://") {
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return url + "/api/websocket"
+}
+
+func toEntityState(entityID string, s *haState) model.HAEntityState {
+	// A removed entity has no new state
+	if s == nil {
+		return model.HAEntityState{EntityID: entityID, State: "unavailable"}
+	}
+	return model.HAEntityState{
+		EntityID:   entityID,
+		State:      s.State,
+		Attributes: s.Attributes,
+	}
+}
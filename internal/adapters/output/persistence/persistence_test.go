@@ -60,3 +60,28 @@ func TestJSONConfigRepository_NewFormat(t *testing.T) {
 	assert.Len(t, loaded.VirtualDevices, 1)
 	assert.Equal(t, "Test", loaded.VirtualDevices[0].Name)
 }
+
+func TestJSONWhitelistRepository(t *testing.T) {
+	tmpFile := "test_whitelist.json"
+	defer os.Remove(tmpFile)
+
+	repo := NewJSONWhitelistRepository(tmpFile)
+
+	// A missing file means no paired client yet
+	users, err := repo.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	err = repo.Save(context.Background(), []*model.HueUser{{Username: "abc", DeviceType: "Echo"}})
+	assert.NoError(t, err)
+
+	loaded, err := NewJSONWhitelistRepository(tmpFile).Get(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, loaded, 1)
+	assert.Equal(t, "Echo", loaded[0].DeviceType)
+
+	// Corrupted file
+	os.WriteFile(tmpFile, []byte("{"), 0600)
+	_, err = NewJSONWhitelistRepository(tmpFile).Get(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hue-bridge-emulator/internal/domain/model"
+	"os"
+	"sync"
+)
+
+type JSONWhitelistRepository struct {
+	filepath string
+	mu       sync.RWMutex
+	cache    []*model.HueUser
+	loaded   bool
+}
+
+func NewJSONWhitelistRepository(filepath string) *JSONWhitelistRepository {
+	return &JSONWhitelistRepository{filepath: filepath}
+}
+
+func (r *JSONWhitelistRepository) Get(ctx context.Context) ([]*model.HueUser, error) {
+	r.mu.RLock()
+	if r.loaded {
+		defer r.mu.RUnlock()
+		return r.cache, nil
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Double check cache after acquiring write lock
+	if r.loaded {
+		return r.cache, nil
+	}
+
+	data, err := os.ReadFile(r.filepath)
+	if errors.Is(err, os.ErrNotExist) {
+		// No client has paired yet
+		r.loaded = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*model.HueUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+
+	r.cache = users
+	r.loaded = true
+	return users, nil
+}
+
+func (r *JSONWhitelistRepository) Save(ctx context.Context, users []*model.HueUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(r.filepath, data, 0600); err != nil {
+		return err
+	}
+
+	r.cache = users
+	r.loaded = true
+	return nil
+}
+
+func (r *JSONWhitelistRepository) Exists() bool {
+	_, err := os.Stat(r.filepath)
+	return err == nil
+}
@@ -1,6 +1,19 @@
 package model
 
+import "time"
+
 type AuthConfig struct {
 	Username string `json:"username"`
 	Password string `json:"password"` // Hashed
 }
+
+// LegacyHueUsername is the username every Hue client was given before users were whitelisted
+const LegacyHueUsername = "admin"
+
+// HueUser is a Hue API client that has been granted a username
+type HueUser struct {
+	Username    string    `json:"username"`
+	DeviceType  string    `json:"devicetype"`
+	CreateDate  time.Time `json:"create_date"`
+	LastUseDate time.Time `json:"last_use_date"`
+}
@@ -0,0 +1,66 @@
+package model
+
+import "time"
+
+// CommandStatus is the outcome of a command sent to Home Assistant
+type CommandStatus string
+
+const (
+	CommandSucceeded CommandStatus = "ok"
+	CommandFailed    CommandStatus = "failed"
+)
+
+// CommandRecord is a command dispatched to Home Assistant and how it went
+type CommandRecord struct {
+	Time       time.Time     `json:"time"`
+	DeviceID   string        `json:"device_id"`
+	DeviceName string        `json:"device_name"`
+	EntityID   string        `json:"entity_id"`
+	Service    string        `json:"service"`
+	Data       HAFields      `json:"data,omitempty"`
+	Status     CommandStatus `json:"status"`
+	HTTPStatus int           `json:"http_status,omitempty"`
+	LatencyMs  int64         `json:"latency_ms"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// TranslationPreview is what a virtual device translates a state to, computed without calling HA
+type TranslationPreview struct {
+	State   *DeviceState          `json:"state,omitempty"`   // Reported for an HA state
+	Command *HomeAssistantCommand `json:"command,omitempty"` // Sent for a Hue state
+	Sweep   []BriCommand          `json:"sweep,omitempty"`   // Sent for every brightness
+}
+
+// BriCommand is the command sent to set a brightness
+type BriCommand struct {
+	Bri     uint8                `json:"bri"`
+	Command HomeAssistantCommand `json:"command"`
+}
+
+// CommandFilter selects command records, empty fields match everything
+type CommandFilter struct {
+	// Device matches the Hue ID or the entity ID
+	Device string
+	Status CommandStatus
+	Limit  int
+}
+
+// Matches reports whether r is selected by the filter, ignoring the limit
+func (f CommandFilter) Matches(r CommandRecord) bool {
+	if f.Device != "" && f.Device != r.DeviceID && f.Device != r.EntityID {
+		return false
+	}
+	return f.Status == "" || f.Status == r.Status
+}
+
+// StateMismatch counts the commands of an entity whose state HA did not report back in time,
+// typically a translator that does not round-trip
+type StateMismatch struct {
+	EntityID   string      `json:"entity_id"`
+	DeviceID   string      `json:"device_id"`
+	DeviceName string      `json:"device_name"`
+	Count      int         `json:"count"`
+	Last       time.Time   `json:"last"`
+	Expected   DeviceState `json:"expected"`
+	Reported   DeviceState `json:"reported"`
+}
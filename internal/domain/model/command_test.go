@@ -0,0 +1,23 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandFilter_Matches(t *testing.T) {
+	r := CommandRecord{DeviceID: "3", EntityID: "light.desk", Status: CommandFailed}
+
+	assert.True(t, CommandFilter{}.Matches(r))
+	assert.True(t, CommandFilter{Device: "3"}.Matches(r))
+	assert.True(t, CommandFilter{Device: "light.desk", Status: CommandFailed}.Matches(r))
+	assert.False(t, CommandFilter{Device: "4"}.Matches(r))
+	assert.False(t, CommandFilter{Status: CommandSucceeded}.Matches(r))
+}
+
+func TestHAStatusError(t *testing.T) {
+	err := fmt.Errorf("set state: %w", &HAStatusError{StatusCode: 503})
+	assert.EqualError(t, err, "set state: HA API error: 503")
+}
@@ -7,6 +7,12 @@ const (
 	MappingTypeCover   MappingType = "cover"
 	MappingTypeClimate MappingType = "climate"
 	MappingTypeCustom  MappingType = "custom"
+	MappingTypeFan     MappingType = "fan"
+	MappingTypeMedia   MappingType = "media_player"
+	MappingTypeLock    MappingType = "lock"
+	MappingTypeValve   MappingType = "valve"
+	MappingTypeGarage  MappingType = "garage"
+	MappingTypeTrigger MappingType = "trigger"
 )
 
 type ActionConfig struct {
@@ -28,20 +34,156 @@ type ActionConfig struct {
 
 	// Options
 	OmitEntityID bool `json:"omit_entity_id,omitempty"` // For scripts, notify.*
+
+	// Fans: brightness bands sent as preset modes instead of a percentage
+	FanPresets []FanPreset `json:"fan_presets,omitempty"`
+
+	// Media players: on/off plays and pauses instead of turning the player on and off, and a muted
+	// player is reported, muted and unmuted as off
+	MediaPlayback bool `json:"media_playback,omitempty"`
+	MutedAsOff    bool `json:"muted_as_off,omitempty"`
+
+	// Locks, valves and garage doors: unlocking or opening by voice is rejected unless allowed
+	AllowVoiceUnlock bool `json:"allow_voice_unlock,omitempty"`
+
+	// Climate: overrides of the min_temp, max_temp and target_temp_step the entity reports, and
+	// the hvac_mode on sets (off then sets hvac_mode off), climate.turn_on/turn_off when empty
+	ClimateMinTemp  *float64 `json:"climate_min_temp,omitempty"`
+	ClimateMaxTemp  *float64 `json:"climate_max_temp,omitempty"`
+	ClimateTempStep float64  `json:"climate_temp_step,omitempty"`
+	HVACMode        string   `json:"hvac_mode,omitempty"`
+
+	// Mapping rules applied on top of every strategy: HA states reported as "on", "off" or
+	// "unreachable", the attribute read as brightness (0-BriAttributeMax, 254 by default) and
+	// brightness bands sent with their own service and payload
+	StateMap        map[string]string `json:"state_map,omitempty"`
+	BriAttribute    string            `json:"bri_attribute,omitempty"` // Dotted path, e.g. "level"
+	BriAttributeMax float64           `json:"bri_attribute_max,omitempty"`
+	BriBands        []BriBand         `json:"bri_bands,omitempty"`
+
+	// Scenes, scripts and buttons: seconds a triggered device is reported on, 0 uses the default
+	TriggerResetDelay int `json:"trigger_reset_delay,omitempty"`
 }
 
-type VirtualDevice struct {
-	HueID        string        `json:"hue_id"`   // Stable Hue identifier, e.g., "1"
-	Name         string        `json:"name"`     // Displayed in Alexa
-	EntityID     string        `json:"entity_id"` // HA entity reference
-	Type         MappingType   `json:"type"`
+// Values of ActionConfig.StateMap
+const (
+	MappedStateOn          = "on"
+	MappedStateOff         = "off"
+	MappedStateUnreachable = "unreachable"
+)
+
+// BriBand sends Hue brightness values up to MaxBri with its own service and payload
+type BriBand struct {
+	MaxBri  uint8                  `json:"max_bri"`
+	Service string                 `json:"service,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// FanPreset selects an HA preset mode for Hue brightness values up to MaxBri
+type FanPreset struct {
+	MaxBri uint8  `json:"max_bri"`
+	Mode   string `json:"mode"`
+}
+
+// StatePolicy decides the reported state of a virtual device driving several entities
+type StatePolicy string
+
+const (
+	StatePolicyAny    StatePolicy = "any"    // On when any entity is on, the default
+	StatePolicyAll    StatePolicy = "all"    // On when every entity is on
+	StatePolicyLeader StatePolicy = "leader" // The state of the main entity
+)
+
+// DeviceTarget is an additional HA entity driven by a virtual device
+type DeviceTarget struct {
+	EntityID string                 `json:"entity_id"`
+	Type     MappingType            `json:"type,omitempty"`    // Defaults to the type of the device
+	Payload  map[string]interface{} `json:"payload,omitempty"` // Static params added to every command
+
+	// ActionConfig tunes the strategy of the target, e.g. AllowVoiceUnlock for a lock
 	ActionConfig *ActionConfig `json:"action_config,omitempty"`
 }
 
+type VirtualDevice struct {
+	HueID        string          `json:"hue_id"`   // Stable Hue identifier, e.g., "1"
+	Name         string          `json:"name"`     // Displayed in Alexa
+	EntityID     string          `json:"entity_id"` // HA entity reference, the leader of multi-entity devices
+	Type         MappingType     `json:"type"`
+	ActionConfig *ActionConfig   `json:"action_config,omitempty"`
+	BridgeID     string          `json:"bridge_id,omitempty"` // Serving bridge instance, empty for the primary bridge
+	Targets      []*DeviceTarget `json:"targets,omitempty"`   // Entities driven along with EntityID
+	StatePolicy  StatePolicy     `json:"state_policy,omitempty"`
+
+	// The state of EntityID is read from StateEntityID when set, e.g. a gate driven by a script
+	// with its state in a binary_sensor, optionally from the attribute at StateAttribute
+	StateEntityID  string `json:"state_entity_id,omitempty"`
+	StateAttribute string `json:"state_attribute,omitempty"` // Dotted path, e.g. "position" or "status.open"
+}
+
+// StateEntity returns the entity the state of EntityID is read from
+func (vd *VirtualDevice) StateEntity() string {
+	if vd.StateEntityID != "" {
+		return vd.StateEntityID
+	}
+	return vd.EntityID
+}
+
+// HasEntity reports whether the device is backed by entityID
+func (vd *VirtualDevice) HasEntity(entityID string) bool {
+	if vd.EntityID == entityID || vd.StateEntityID == entityID {
+		return true
+	}
+	for _, t := range vd.Targets {
+		if t.EntityID == entityID {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetDevice returns the single-entity virtual device translating the commands of a target.
+// The action config of the device only applies to its main entity, targets have their own.
+func (vd *VirtualDevice) TargetDevice(t *DeviceTarget) *VirtualDevice {
+	typ := t.Type
+	if typ == "" {
+		typ = vd.Type
+	}
+	return &VirtualDevice{
+		HueID:    vd.HueID,
+		Name:     vd.Name,
+		EntityID: t.EntityID,
+		Type:         typ,
+		BridgeID:     vd.BridgeID,
+		ActionConfig: t.ActionConfig,
+	}
+}
+
+// VirtualGroup exposes several virtual devices as one Hue group (room or zone)
+type VirtualGroup struct {
+	ID     string   `json:"id"`              // Stable Hue group identifier, e.g., "1"
+	Name   string   `json:"name"`            // Displayed in Alexa, e.g., "Living Room"
+	Type   string   `json:"type,omitempty"`  // Room, Zone or LightGroup
+	Class  string   `json:"class,omitempty"` // Room class, e.g., "Living room"
+	Lights []string `json:"lights"`          // Member HueIDs
+}
+
+// BridgeInstance is an additional emulated bridge served by the same process.
+// Alexa only talks to port 80, so instances usually listen on an IP alias.
+type BridgeInstance struct {
+	ID       string          `json:"id"`                 // Stable identifier, e.g., "2"
+	IP       string          `json:"ip,omitempty"`       // Defaults to the primary bridge IP
+	Port     int             `json:"port,omitempty"`     // Defaults to 80
+	Identity *BridgeIdentity `json:"identity,omitempty"` // Generated on first start
+}
+
 type Config struct {
 	HassURL              string           `json:"hass_url"`
 	HassToken            string           `json:"hass_token,omitempty"`
 	HassTokenConfigured bool             `json:"-"`
 	LocalIP              string           `json:"local_ip"`
 	VirtualDevices       []*VirtualDevice `json:"virtual_devices"` // Ordered slice
+	VirtualGroups        []*VirtualGroup  `json:"virtual_groups,omitempty"`
+	Identity             *BridgeIdentity  `json:"identity,omitempty"` // Generated on first start
+	Bridges              []*BridgeInstance `json:"bridges,omitempty"`
+	MaxDevicesPerBridge  int               `json:"max_devices_per_bridge,omitempty"` // 0 keeps every unassigned device on the primary bridge
 }
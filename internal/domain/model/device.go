@@ -1,6 +1,10 @@
 package model
 
-import "strings"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 type HueMetadata struct {
 	Type             string
@@ -15,10 +19,32 @@ type DeviceState struct {
 	Sat       uint8     `json:"sat"`
 	Xy        []float32 `json:"xy"`
 	Ct        uint16    `json:"ct"`
+	Effect    string    `json:"effect,omitempty"`
+	ColorMode string    `json:"colormode,omitempty"` // hs, xy or ct
 	Reachable bool      `json:"reachable"`
 
+	// Command-only fields, never reported back to Hue clients
+	TransitionTime *uint16 `json:"transitiontime,omitempty"` // Multiple of 100ms
+	BriInc         *int16  `json:"bri_inc,omitempty"`        // Set instead of Bri when relative
+	CtInc          *int16  `json:"ct_inc,omitempty"`         // Set instead of Ct when relative
+	Alert          string  `json:"alert,omitempty"`
+
+	// Helper field telling whether the update carries "on", other commands keep the current value
+	UpdatedByOn bool `json:"-"`
 	// Helper field to distinguish between direct On/Off vs Brightness update
 	UpdatedByBri bool `json:"-"`
+	// Helper fields telling which colour attributes the update carries
+	UpdatedByHue bool `json:"-"`
+	UpdatedBySat bool `json:"-"`
+	UpdatedByXy  bool `json:"-"`
+	UpdatedByCt  bool `json:"-"`
+	// Helper field telling whether the command carries Effect, it persists in the reported state
+	UpdatedByEffect bool `json:"-"`
+}
+
+// UpdatedByColor reports whether the update carries any colour or white temperature change
+func (s *DeviceState) UpdatedByColor() bool {
+	return s.UpdatedByHue || s.UpdatedBySat || s.UpdatedByXy || s.UpdatedByCt
 }
 
 type Device struct {
@@ -28,10 +54,41 @@ type Device struct {
 	ExternalID    string // Home Assistant Entity ID
 	State         *DeviceState
 	VirtualDevice *VirtualDevice
+	Metadata      *HueMetadata // Set when the translator derives it from the entity, see GetDeviceMetadata otherwise
+}
+
+type GroupState struct {
+	AnyOn bool `json:"any_on"`
+	AllOn bool `json:"all_on"`
+}
+
+type Group struct {
+	ID     string
+	Name   string
+	Type   string
+	Class  string
+	Lights []string // Member HueIDs that currently exist
+	State  GroupState
+	Action *DeviceState // Aggregated state of the members
 }
 
 type HAFields map[string]any
 
+// Lookup returns the value at path, a dotted path into nested fields
+func (f HAFields) Lookup(path string) (any, bool) {
+	var v any = map[string]any(f)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
 type HAEntityState struct {
 	EntityID   string
 	State      string
@@ -39,9 +96,9 @@ type HAEntityState struct {
 }
 
 type HomeAssistantCommand struct {
-	Service string
-	Data    HAFields
-	Effect  string
+	Service string   `json:"service"`
+	Data    HAFields `json:"payload,omitempty"`
+	Effect  string   `json:"effect,omitempty"`
 }
 
 func (s HAEntityState) IsSupported(ignoredDomains []string) bool {
@@ -52,3 +109,51 @@ func (s HAEntityState) IsSupported(ignoredDomains []string) bool {
 	}
 	return strings.Contains(s.EntityID, ".")
 }
+
+// Entity states HA reports when it cannot reach the device
+const (
+	HAStateUnavailable = "unavailable"
+	HAStateUnknown     = "unknown"
+)
+
+// statelessDomains report the time of their last activation, they are unknown until first used
+var statelessDomains = []string{"button.", "input_button.", "scene."}
+
+// Available reports whether HA can reach the device behind the entity
+func (s HAEntityState) Available() bool {
+	if s.State == HAStateUnavailable {
+		return false
+	}
+	if s.State == HAStateUnknown {
+		for _, domain := range statelessDomains {
+			if strings.HasPrefix(s.EntityID, domain) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// WithStateFromAttribute returns the entity state with its state read from the attribute at path,
+// a dotted path into nested attributes. Booleans read as on/off, a missing attribute as unknown.
+func (s HAEntityState) WithStateFromAttribute(path string) HAEntityState {
+	if path == "" || s.State == HAStateUnavailable {
+		return s
+	}
+	v, _ := s.Attributes.Lookup(path)
+	switch val := v.(type) {
+	case nil:
+		s.State = HAStateUnknown
+	case bool:
+		s.State = "off"
+		if val {
+			s.State = "on"
+		}
+	case float64:
+		s.State = strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		s.State = fmt.Sprint(val)
+	}
+	return s
+}
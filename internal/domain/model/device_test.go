@@ -28,3 +28,55 @@ func TestHAEntityState_IsSupported(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceState_UpdatedByColor(t *testing.T) {
+	assert.False(t, (&DeviceState{UpdatedByBri: true}).UpdatedByColor())
+	assert.True(t, (&DeviceState{UpdatedByHue: true}).UpdatedByColor())
+	assert.True(t, (&DeviceState{UpdatedBySat: true}).UpdatedByColor())
+	assert.True(t, (&DeviceState{UpdatedByXy: true}).UpdatedByColor())
+	assert.True(t, (&DeviceState{UpdatedByCt: true}).UpdatedByColor())
+}
+
+func TestHAEntityState_Available(t *testing.T) {
+	tests := []struct {
+		name     string
+		entityID string
+		state    string
+		expected bool
+	}{
+		{"On", "light.test", "on", true},
+		{"Off", "switch.test", "off", true},
+		{"Unavailable", "light.test", HAStateUnavailable, false},
+		{"Unknown", "cover.test", HAStateUnknown, false},
+		{"Button never pressed", "button.test", HAStateUnknown, true},
+		{"Scene never activated", "scene.test", HAStateUnknown, true},
+		{"Unavailable button", "input_button.test", HAStateUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := HAEntityState{EntityID: tt.entityID, State: tt.state}
+			assert.Equal(t, tt.expected, s.Available())
+		})
+	}
+}
+
+func TestHAEntityState_WithStateFromAttribute(t *testing.T) {
+	s := HAEntityState{EntityID: "sensor.gate", State: "42", Attributes: HAFields{
+		"open":     true,
+		"position": 37.5,
+		"status":   map[string]any{"door": "closed"},
+	}}
+
+	assert.Equal(t, "42", s.WithStateFromAttribute("").State)
+	assert.Equal(t, "on", s.WithStateFromAttribute("open").State)
+	assert.Equal(t, "37.5", s.WithStateFromAttribute("position").State)
+	assert.Equal(t, "closed", s.WithStateFromAttribute("status.door").State)
+	assert.Equal(t, HAStateUnknown, s.WithStateFromAttribute("status.window").State)
+	assert.Equal(t, HAStateUnknown, s.WithStateFromAttribute("open.value").State)
+	// The attributes are kept for the translators
+	assert.Equal(t, 37.5, s.WithStateFromAttribute("open").Attributes["position"])
+
+	s.State = HAStateUnavailable
+	assert.Equal(t, HAStateUnavailable, s.WithStateFromAttribute("open").State)
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is wrapped by lookups of devices, groups or users that do not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidConfig is wrapped by configuration updates that are rejected
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrLinkButtonNotPressed is returned when a Hue client tries to pair outside the pairing window
+var ErrLinkButtonNotPressed = errors.New("link button not pressed")
+
+// HAStatusError is an HTTP error status answered by Home Assistant
+type HAStatusError struct {
+	StatusCode int
+}
+
+func (e *HAStatusError) Error() string {
+	return fmt.Sprintf("HA API error: %d", e.StatusCode)
+}
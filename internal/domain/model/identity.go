@@ -0,0 +1,28 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BridgeIdentity is what Hue clients use to tell bridges apart, derived from a MAC address
+type BridgeIdentity struct {
+	MAC      string `json:"mac"`       // e.g. "00:17:88:10:22:01"
+	BridgeID string `json:"bridge_id"` // e.g. "001788FFFE102201"
+	UUID     string `json:"uuid"`      // UPnP UDN without the "uuid:" prefix
+	Serial   string `json:"serial"`    // e.g. "001788102201"
+}
+
+// LegacyBridgeMAC is the address every emulator advertised before identities were generated
+var LegacyBridgeMAC = [6]byte{0x00, 0x17, 0x88, 0x10, 0x22, 0x01}
+
+// NewBridgeIdentity derives the bridge identifiers from mac the way a real Hue bridge does
+func NewBridgeIdentity(mac [6]byte) *BridgeIdentity {
+	serial := fmt.Sprintf("%02x%02x%02x%02x%02x%02x", mac[0], mac[1], mac[2], mac[3], mac[4], mac[5])
+	return &BridgeIdentity{
+		MAC:      fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", mac[0], mac[1], mac[2], mac[3], mac[4], mac[5]),
+		BridgeID: strings.ToUpper(serial[:6] + "fffe" + serial[6:]),
+		UUID:     "2f402f80-da50-11e1-9b23-" + serial,
+		Serial:   serial,
+	}
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBridgeIdentity(t *testing.T) {
+	identity := NewBridgeIdentity(LegacyBridgeMAC)
+	assert.Equal(t, &BridgeIdentity{
+		MAC:      "00:17:88:10:22:01",
+		BridgeID: "001788FFFE102201",
+		UUID:     "2f402f80-da50-11e1-9b23-001788102201",
+		Serial:   "001788102201",
+	}, identity)
+
+	identity = NewBridgeIdentity([6]byte{0xb8, 0x27, 0xeb, 0x0a, 0x1b, 0x2c})
+	assert.Equal(t, "b8:27:eb:0a:1b:2c", identity.MAC)
+	assert.Equal(t, "B827EBFFFE0A1B2C", identity.BridgeID)
+	assert.Equal(t, "2f402f80-da50-11e1-9b23-b827eb0a1b2c", identity.UUID)
+}
@@ -0,0 +1,59 @@
+package model
+
+import "math"
+
+// ApplyStateRules overrides the state a strategy translated from haState with the mapping rules
+// of the action config, a nil config has none
+func (ac *ActionConfig) ApplyStateRules(haState HAEntityState, state *DeviceState) {
+	if ac == nil {
+		return
+	}
+	switch ac.StateMap[haState.State] {
+	case MappedStateOn:
+		state.On = true
+	case MappedStateOff:
+		state.On = false
+	case MappedStateUnreachable:
+		state.On = false
+		state.Reachable = false
+	}
+	if ac.BriAttribute != "" {
+		if v, ok := haState.Attributes.Lookup(ac.BriAttribute); ok {
+			if f, ok := v.(float64); ok {
+				max := ac.BriAttributeMax
+				if max <= 0 {
+					max = 254
+				}
+				state.Bri = uint8(math.Round(math.Max(0, math.Min(max, f)) * 254 / max))
+			}
+		}
+	}
+}
+
+// ApplyCommandRules sends a brightness change within a band of the action config with the
+// service and payload of the band, the narrowest band containing the brightness wins. A band
+// with its own service sends only its payload, the data of the strategy is meant for its service.
+func (ac *ActionConfig) ApplyCommandRules(cmd *HomeAssistantCommand, hueState *DeviceState) {
+	if ac == nil || !hueState.On || !hueState.UpdatedByBri {
+		return
+	}
+	var band *BriBand
+	for i, b := range ac.BriBands {
+		if hueState.Bri <= b.MaxBri && (band == nil || b.MaxBri < band.MaxBri) {
+			band = &ac.BriBands[i]
+		}
+	}
+	if band == nil {
+		return
+	}
+	if band.Service != "" {
+		cmd.Service = band.Service
+		cmd.Data = make(HAFields)
+	}
+	if len(band.Payload) > 0 && cmd.Data == nil {
+		cmd.Data = make(HAFields)
+	}
+	for k, v := range band.Payload {
+		cmd.Data[k] = v
+	}
+}
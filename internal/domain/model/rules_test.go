@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionConfig_ApplyStateRules(t *testing.T) {
+	ac := &ActionConfig{
+		StateMap:        map[string]string{"open": MappedStateOn, "on": MappedStateOff, "jammed": MappedStateUnreachable},
+		BriAttribute:    "status.level",
+		BriAttributeMax: 100,
+	}
+
+	st := &DeviceState{Reachable: true}
+	ac.ApplyStateRules(HAEntityState{State: "open", Attributes: HAFields{"status": map[string]any{"level": 50.0}}}, st)
+	assert.True(t, st.On)
+	assert.Equal(t, uint8(127), st.Bri)
+
+	st = &DeviceState{On: true, Bri: 10, Reachable: true}
+	ac.ApplyStateRules(HAEntityState{State: "on"}, st)
+	assert.False(t, st.On)
+	// Without the attribute the brightness of the strategy is kept
+	assert.Equal(t, uint8(10), st.Bri)
+
+	st = &DeviceState{On: true, Reachable: true}
+	ac.ApplyStateRules(HAEntityState{State: "jammed", Attributes: HAFields{"status": map[string]any{"level": 500.0}}}, st)
+	assert.False(t, st.On)
+	assert.False(t, st.Reachable)
+	assert.Equal(t, uint8(254), st.Bri)
+
+	// Unmapped states and nil configs keep the translated state
+	st = &DeviceState{On: true, Reachable: true}
+	ac.ApplyStateRules(HAEntityState{State: "closing"}, st)
+	assert.True(t, st.On)
+	var none *ActionConfig
+	none.ApplyStateRules(HAEntityState{State: "on"}, st)
+	assert.True(t, st.On)
+}
+
+func TestActionConfig_ApplyCommandRules(t *testing.T) {
+	ac := &ActionConfig{BriBands: []BriBand{
+		{MaxBri: 254, Service: "script.preset_high"},
+		{MaxBri: 84, Service: "script.preset_low", Payload: map[string]interface{}{"level": "low"}},
+		{MaxBri: 169, Payload: map[string]interface{}{"level": "medium"}},
+	}}
+
+	cmd := HomeAssistantCommand{Service: "turn_on"}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 50, UpdatedByBri: true})
+	assert.Equal(t, "script.preset_low", cmd.Service)
+	assert.Equal(t, HAFields{"level": "low"}, cmd.Data)
+
+	cmd = HomeAssistantCommand{Service: "turn_on", Data: HAFields{"brightness": 120}}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 120, UpdatedByBri: true})
+	assert.Equal(t, "turn_on", cmd.Service)
+	assert.Equal(t, HAFields{"brightness": 120, "level": "medium"}, cmd.Data)
+
+	cmd = HomeAssistantCommand{Service: "turn_on"}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 200, UpdatedByBri: true})
+	assert.Equal(t, "script.preset_high", cmd.Service)
+
+	// The data of the strategy is dropped for the service of a band
+	cmd = HomeAssistantCommand{Service: "turn_on", Data: HAFields{"brightness": 50, "percentage": 20}}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 50, UpdatedByBri: true})
+	assert.Equal(t, "script.preset_low", cmd.Service)
+	assert.Equal(t, HAFields{"level": "low"}, cmd.Data)
+	cmd = HomeAssistantCommand{Service: "set_percentage", Data: HAFields{"percentage": 80}}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 200, UpdatedByBri: true})
+	assert.Equal(t, HAFields{}, cmd.Data)
+
+	// Only brightness changes are banded
+	cmd = HomeAssistantCommand{Service: "turn_off"}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: false, Bri: 50})
+	assert.Equal(t, "turn_off", cmd.Service)
+	cmd = HomeAssistantCommand{Service: "turn_on"}
+	ac.ApplyCommandRules(&cmd, &DeviceState{On: true, Bri: 50})
+	assert.Equal(t, "turn_on", cmd.Service)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"hue-bridge-emulator/internal/domain/model"
@@ -29,6 +30,17 @@ type BridgeService struct {
 	ignoredDomains    []string
 	refreshGroup      singleflight.Group
 	workerSem         chan struct{}
+	queues            map[string]*deviceQueue
+	queueMu           sync.Mutex
+	history           *commandHistory
+	optimistic        map[string]*optimisticChange
+	optimisticSeq     uint64
+	convergenceWindow time.Duration
+	mismatches        map[string]*model.StateMismatch
+	reconfigurables   []ports.Reconfigurable
+	identityMu        sync.Mutex
+	primaryIP         string
+	primaryPort       int
 }
 
 func NewBridgeService(haPort ports.ReconfigurableHomeAssistantPort, configRepo ports.ConfigRepository, translatorFactory ports.TranslatorFactory) *BridgeService {
@@ -38,6 +50,12 @@ func NewBridgeService(haPort ports.ReconfigurableHomeAssistantPort, configRepo p
 		translatorFactory: translatorFactory,
 		devices:           make(map[string]*model.Device),
 		workerSem:         make(chan struct{}, 10), // Limit to 10 concurrent HA service calls
+		queues:            make(map[string]*deviceQueue),
+		history:           &commandHistory{size: DefaultCommandHistorySize},
+		optimistic:        make(map[string]*optimisticChange),
+		convergenceWindow: DefaultConvergenceWindow,
+		mismatches:        make(map[string]*model.StateMismatch),
+		primaryPort:       DefaultBridgePort,
 	}
 	return s
 }
@@ -57,6 +75,101 @@ func (s *BridgeService) Start(ctx context.Context) {
 	}()
 }
 
+// AddReconfigurable registers an additional port (e.g. the event stream) to be reconfigured on config updates
+func (s *BridgeService) AddReconfigurable(r ports.Reconfigurable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconfigurables = append(s.reconfigurables, r)
+}
+
+// WatchEvents applies the state changes pushed by events until ctx is cancelled, events follows
+// the HA settings of config updates
+func (s *BridgeService) WatchEvents(ctx context.Context, events ports.HomeAssistantEventsPort) {
+	s.AddReconfigurable(events)
+	go events.Run(ctx, s)
+}
+
+// ApplyStateChange updates the devices backed by a single HA entity without a full refresh
+func (s *BridgeService) ApplyStateChange(ctx context.Context, state model.HAEntityState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Devices are not loaded yet, the first refresh will pick up the current state
+	if !s.initialized {
+		return
+	}
+
+	cached := false
+	for i := range s.cachedHAStates {
+		if s.cachedHAStates[i].EntityID == state.EntityID {
+			s.cachedHAStates[i] = state
+			cached = true
+			break
+		}
+	}
+	if !cached {
+		s.cachedHAStates = append(s.cachedHAStates, state)
+	}
+
+	for _, d := range s.sortedDevices {
+		if !d.VirtualDevice.HasEntity(state.EntityID) {
+			continue
+		}
+		d.State = s.reconcileLocked(d.ID, s.deviceState(d.VirtualDevice, s.cachedStateLocked))
+		d.Metadata = s.entityMetadata(d.VirtualDevice)
+		slog.Debug("Bridge: applied pushed state change", "hue_id", d.ID, "entity_id", state.EntityID, "state", state.State)
+	}
+}
+
+// cachedStateLocked returns the last known state of an entity, must be called with the lock
+func (s *BridgeService) cachedStateLocked(entityID string) model.HAEntityState {
+	for _, st := range s.cachedHAStates {
+		if st.EntityID == entityID {
+			return st
+		}
+	}
+	return model.HAEntityState{EntityID: entityID, State: model.HAStateUnavailable}
+}
+
+// toHue translates an HA state. Reachability is decided here for every strategy, from whether HA
+// can reach the device.
+func (s *BridgeService) toHue(state model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	return translateToHue(s.translatorFactory.GetTranslator(vd.Type), state, vd)
+}
+
+// toHA translates a Hue state into the command for the entity of vd
+func (s *BridgeService) toHA(state *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	return translateToHA(s.translatorFactory.GetTranslator(vd.Type), state, vd)
+}
+
+func translateToHue(t ports.Translator, state model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	hueState := t.ToHue(state, vd)
+	hueState.Reachable = state.Available()
+	if !hueState.Reachable {
+		// Whatever the strategy reads from the state, unreachable entities are off unless mapped
+		hueState.On = false
+	}
+	vd.ActionConfig.ApplyStateRules(state, hueState)
+	return hueState
+}
+
+func translateToHA(t ports.Translator, state *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	cmd := t.ToHA(state, vd)
+	vd.ActionConfig.ApplyCommandRules(&cmd, state)
+	return cmd
+}
+
+// entityMetadata returns the metadata of a device when its translator derives it from the entity,
+// the translator must have seen the entity state first
+func (s *BridgeService) entityMetadata(vd *model.VirtualDevice) *model.HueMetadata {
+	provider, ok := s.translatorFactory.GetTranslator(vd.Type).(ports.EntityMetadataProvider)
+	if !ok {
+		return nil
+	}
+	meta := provider.GetEntityMetadata(vd)
+	return &meta
+}
+
 func (s *BridgeService) TestDeviceAction(ctx context.Context, vd *model.VirtualDevice, state *model.DeviceState) error {
 	// Create a dummy device for SetState
 	dummyDevice := &model.Device{
@@ -68,21 +181,18 @@ func (s *BridgeService) TestDeviceAction(ctx context.Context, vd *model.VirtualD
 		VirtualDevice: vd,
 	}
 
-	t := s.translatorFactory.GetTranslator(vd.Type)
-	cmd := t.ToHA(state, vd)
-
+	// Wait for a free HA slot rather than rejecting the test
 	select {
 	case s.workerSem <- struct{}{}:
-		go func() {
-			defer func() { <-s.workerSem }()
-			err := s.haPort.SetState(context.Background(), dummyDevice, cmd)
-			if err != nil {
-				slog.Error("Error setting HA test state", "error", err)
-			}
-		}()
-	default:
-		return fmt.Errorf("too many concurrent requests, please try again later")
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+	go func() {
+		defer func() { <-s.workerSem }()
+		if err := s.dispatch(dummyDevice, *state); err != nil {
+			slog.Error("Error setting HA test state", "error", err)
+		}
+	}()
 
 	return nil
 }
@@ -122,23 +232,25 @@ func (s *BridgeService) RefreshDevices(ctx context.Context) error {
 		newDevices := make(map[string]*model.Device)
 
 		slog.Debug("Bridge: processing virtual devices", "count", len(cfg.VirtualDevices), "ha_state_map_size", len(stateMap))
-		for _, vd := range cfg.VirtualDevices {
-			state, exists := stateMap[vd.EntityID]
+		lookup := func(entityID string) model.HAEntityState {
+			state, exists := stateMap[entityID]
 			if !exists {
-				slog.Warn("Bridge: entity not found in HA states", "entity_id", vd.EntityID)
-				state = model.HAEntityState{EntityID: vd.EntityID, State: "unavailable"}
+				slog.Warn("Bridge: entity not found in HA states", "entity_id", entityID)
+				state = model.HAEntityState{EntityID: entityID, State: model.HAStateUnavailable}
 			}
-
-			t := s.translatorFactory.GetTranslator(vd.Type)
-			hueState := t.ToHue(state, vd)
+			return state
+		}
+		for _, vd := range cfg.VirtualDevices {
+			hueState := s.deviceState(vd, lookup)
 
 			newDevices[vd.HueID] = &model.Device{
 				ID:            vd.HueID,
 				Name:          vd.Name,
 				Type:          vd.Type,
 				ExternalID:    vd.EntityID,
-				State:         hueState,
+				State:         s.reconcileLocked(vd.HueID, hueState),
 				VirtualDevice: vd,
+				Metadata:      s.entityMetadata(vd),
 			}
 		}
 
@@ -183,21 +295,31 @@ func (s *BridgeService) GetDevices(ctx context.Context) ([]*model.Device, error)
 
 // getDevicesLocked returns the devices list, must be called with at least a read lock
 func (s *BridgeService) getDevicesLocked() []*model.Device {
+	available := s.haPort.Available()
 	devices := make([]*model.Device, 0, len(s.sortedDevices))
 	for _, d := range s.sortedDevices {
-		devices = append(devices, s.copyDevice(d))
+		devices = append(devices, s.reportedDevice(d, available))
 	}
 	return devices
 }
 
+// reportedDevice copies d for the Hue API, nothing is reachable while Home Assistant is down
+func (s *BridgeService) reportedDevice(d *model.Device, haAvailable bool) *model.Device {
+	dCopy := s.copyDevice(d)
+	if !haAvailable && dCopy.State != nil {
+		dCopy.State.Reachable = false
+	}
+	return dCopy
+}
+
 func (s *BridgeService) GetDevice(ctx context.Context, id string) (*model.Device, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	d, ok := s.devices[id]
 	if !ok {
-		return nil, fmt.Errorf("device %s not found", id)
+		return nil, fmt.Errorf("device %s %w", id, model.ErrNotFound)
 	}
-	return s.copyDevice(d), nil
+	return s.reportedDevice(d, s.haPort.Available()), nil
 }
 
 func (s *BridgeService) GetDeviceMetadata(deviceType model.MappingType) model.HueMetadata {
@@ -209,18 +331,11 @@ func (s *BridgeService) UpdateDeviceState(ctx context.Context, id string, stateU
 	device, ok := s.devices[id]
 	if !ok {
 		s.mu.Unlock()
-		return fmt.Errorf("device %s not found", id)
+		return fmt.Errorf("device %s %w", id, model.ErrNotFound)
 	}
 
 	// Create a temporary state merged with the current state to handle partial updates
-	tmpState := *device.State
-	if stateUpdate.UpdatedByBri {
-		tmpState.Bri = stateUpdate.Bri
-		tmpState.UpdatedByBri = true
-		tmpState.On = true
-	} else {
-		tmpState.On = stateUpdate.On
-	}
+	tmpState := mergeState(*device.State, stateUpdate)
 
 	// Check NoOp before starting goroutine
 	vd := device.VirtualDevice
@@ -234,35 +349,108 @@ func (s *BridgeService) UpdateDeviceState(ctx context.Context, id string, stateU
 			return nil
 		}
 	}
-
-	// Optimistic update under lock
-	if stateUpdate.UpdatedByBri {
-		device.State.Bri = stateUpdate.Bri
-		device.State.UpdatedByBri = true
-		device.State.On = true
-	} else {
-		device.State.On = stateUpdate.On
+	// A guarded entity refuses the command for all the entities of the device
+	for _, e := range commandedDevices(vd) {
+		guard, ok := s.translatorFactory.GetTranslator(e.Type).(ports.CommandGuard)
+		if !ok {
+			continue
+		}
+		if reason := guard.Rejects(&tmpState, e); reason != "" {
+			s.mu.Unlock()
+			slog.Warn("Bridge: command rejected", "hue_id", id, "entity_id", e.EntityID, "on", tmpState.On, "reason", reason)
+			return nil
+		}
+	}
+	if s.ignores(&tmpState, vd) {
+		s.mu.Unlock()
+		slog.Debug("Bridge: command ignored", "hue_id", id, "entity_id", vd.EntityID, "on", tmpState.On)
+		return nil
 	}
 
-	deviceCopy := s.copyDevice(device)
-	t := s.translatorFactory.GetTranslator(device.Type)
-	cmd := t.ToHA(&tmpState, device.VirtualDevice)
+	// Optimistic update under lock, command-only fields are not part of the reported state
+	optimistic := tmpState
+	optimistic.TransitionTime = nil
+	optimistic.BriInc = nil
+	optimistic.CtInc = nil
+	optimistic.Alert = ""
+	seq := s.trackOptimisticLocked(id, *device.State, optimistic, *stateUpdate)
+	*device.State = optimistic
+
+	// Enqueued under the lock so that commands keep the order of their optimistic updates
+	s.enqueueCommand(s.copyDevice(device), tmpState, seq)
 	s.mu.Unlock()
+	return nil
+}
 
-	select {
-	case s.workerSem <- struct{}{}:
-		go func() {
-			defer func() { <-s.workerSem }()
-			err := s.haPort.SetState(context.Background(), deviceCopy, cmd)
-			if err != nil {
-				slog.Error("Error setting HA state", "error", err)
-			}
-		}()
-	default:
-		return fmt.Errorf("too many concurrent requests, please try again later")
+// mergeState applies a partial Hue update on top of the current state.
+// Brightness and colour changes imply turning the device on unless "on" is sent, other commands
+// keep it as it is.
+func mergeState(current model.DeviceState, update *model.DeviceState) model.DeviceState {
+	merged := current
+	if update.UpdatedByOn {
+		merged.On = update.On
 	}
 
-	return nil
+	if update.UpdatedByBri {
+		if update.BriInc != nil {
+			merged.Bri = uint8(clampInt(int(current.Bri)+int(*update.BriInc), 0, 254))
+		} else {
+			merged.Bri = update.Bri
+		}
+		if !update.UpdatedByOn {
+			merged.On = true
+		}
+	}
+	if update.UpdatedByHue {
+		merged.Hue = update.Hue
+	}
+	if update.UpdatedBySat {
+		merged.Sat = update.Sat
+	}
+	if update.UpdatedByHue || update.UpdatedBySat {
+		merged.ColorMode = "hs"
+	}
+	if update.UpdatedByCt {
+		if update.CtInc != nil {
+			merged.Ct = uint16(clampInt(int(current.Ct)+int(*update.CtInc), 153, 500))
+		} else {
+			merged.Ct = update.Ct
+		}
+		merged.ColorMode = "ct"
+	}
+	if update.UpdatedByXy {
+		merged.Xy = append([]float32(nil), update.Xy...)
+		merged.ColorMode = "xy"
+	}
+	if update.UpdatedByColor() && !update.UpdatedByOn {
+		merged.On = true
+	}
+	if update.Effect != "" {
+		merged.Effect = update.Effect
+	}
+
+	merged.TransitionTime = update.TransitionTime
+	merged.BriInc = update.BriInc
+	merged.CtInc = update.CtInc
+	merged.Alert = update.Alert
+	merged.UpdatedByOn = update.UpdatedByOn
+	merged.UpdatedByEffect = update.Effect != ""
+	merged.UpdatedByBri = update.UpdatedByBri
+	merged.UpdatedByHue = update.UpdatedByHue
+	merged.UpdatedBySat = update.UpdatedBySat
+	merged.UpdatedByXy = update.UpdatedByXy
+	merged.UpdatedByCt = update.UpdatedByCt
+	return merged
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
 }
 
 func (s *BridgeService) copyDevice(d *model.Device) *model.Device {
@@ -283,13 +471,31 @@ func (s *BridgeService) GetConfig(ctx context.Context) (*model.Config, error) {
 }
 
 func (s *BridgeService) UpdateConfig(ctx context.Context, cfg *model.Config) error {
+	if err := s.validateConfig(cfg); err != nil {
+		return err
+	}
+	// Identities are generated, edits are dropped. Only RegenerateIdentity changes them.
+	s.identityMu.Lock()
+	if current, err := s.configRepo.Get(ctx); err == nil {
+		cfg.Identity = current.Identity
+		keepBridgeIdentities(cfg, current)
+	}
 	s.assignHueIDs(cfg)
+	s.assignGroupIDs(cfg)
+	s.assignBridges(cfg)
+	generateBridgeIdentities(cfg.Bridges)
 
 	err := s.configRepo.Save(ctx, cfg)
+	s.identityMu.Unlock()
 	if err != nil {
 		return err
 	}
 	s.haPort.Configure(cfg.HassURL, cfg.HassToken)
+	s.mu.RLock()
+	for _, r := range s.reconfigurables {
+		r.Configure(cfg.HassURL, cfg.HassToken)
+	}
+	s.mu.RUnlock()
 
 	// Force refresh
 	s.mu.Lock()
@@ -302,6 +508,41 @@ func (s *BridgeService) UpdateConfig(ctx context.Context, cfg *model.Config) err
 	return nil
 }
 
+// validateConfig asks the translator of every device to check its configuration
+func (s *BridgeService) validateConfig(cfg *model.Config) error {
+	var errs []error
+	if err := checkGroupIDs(cfg.VirtualGroups); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.checkBridgeAddresses(cfg.Bridges); err != nil {
+		errs = append(errs, err)
+	}
+	for _, vd := range cfg.VirtualDevices {
+		if err := s.validateDevice(vd); err != nil {
+			errs = append(errs, fmt.Errorf("device %q: %w", vd.Name, err))
+		}
+		// Targets are translated with their own type and action config
+		for _, t := range vd.Targets {
+			if err := s.validateDevice(vd.TargetDevice(t)); err != nil {
+				errs = append(errs, fmt.Errorf("device %q target %s: %w", vd.Name, t.EntityID, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", model.ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateDevice checks vd with the translator of its type, when it validates configs
+func (s *BridgeService) validateDevice(vd *model.VirtualDevice) error {
+	v, ok := s.translatorFactory.GetTranslator(vd.Type).(ports.ConfigValidator)
+	if !ok {
+		return nil
+	}
+	return v.Validate(vd)
+}
+
 func (s *BridgeService) GetAllEntities(ctx context.Context) ([]ports.HomeAssistantEntity, error) {
 	s.mu.RLock()
 	if s.initialized && time.Since(s.lastRefresh) < 2*time.Second {
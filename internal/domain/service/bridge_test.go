@@ -13,6 +13,7 @@ import (
 
 type MockHAPort struct {
 	mock.Mock
+	unavailable bool
 }
 
 func (m *MockHAPort) GetRawStates(ctx context.Context) ([]model.HAEntityState, error) {
@@ -28,9 +29,13 @@ func (m *MockHAPort) GetAllEntities(ctx context.Context) ([]ports.HomeAssistantE
 	return args.Get(0).([]ports.HomeAssistantEntity), args.Error(1)
 }
 
-func (m *MockHAPort) SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) error {
+func (m *MockHAPort) SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) (int, error) {
 	args := m.Called(ctx, device, cmd)
-	return args.Error(0)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockHAPort) Available() bool {
+	return !m.unavailable
 }
 
 func (m *MockHAPort) Configure(url, token string) {
@@ -99,7 +104,7 @@ func TestBridgeService_PayloadMerging(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{
 		Service: "camera.record",
 		Data:    model.HAFields{"duration": 30.0},
@@ -108,12 +113,12 @@ func TestBridgeService_PayloadMerging(t *testing.T) {
 	mockHA.On("SetState", mock.Anything, mock.Anything, mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		p := cmd.Data
 		return cmd.Service == "camera.record" && p["duration"] == 30.0
-	})).Return(nil)
+	})).Return(200, nil)
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
 
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true})
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
@@ -143,13 +148,13 @@ func TestBridgeService_NoOpAndPayload(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true, UpdatedByOn: true})
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
 
 	// Update to OFF - should be NoOp (no call to SetState)
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false})
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
@@ -177,7 +182,7 @@ func TestBridgeService_OmitEntityID(t *testing.T) {
 	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{
 		Service: "script.test",
 		Data:    model.HAFields{},
@@ -187,12 +192,12 @@ func TestBridgeService_OmitEntityID(t *testing.T) {
 		return d.ExternalID == "script.test"
 	}), mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		return cmd.Service == "script.test"
-	})).Return(nil)
+	})).Return(200, nil)
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
 
-	_ = s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true})
+	_ = s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true, UpdatedByOn: true})
 
 	time.Sleep(50 * time.Millisecond)
 	mockHA.AssertExpectations(t)
@@ -271,7 +276,7 @@ func TestBridgeService_MultiIntentions(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	devices, err := s.GetDevices(context.Background())
@@ -304,18 +309,18 @@ func TestBridgeService_UpdateDeviceState(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_on"})
 
 	mockHA.On("SetState", mock.Anything, mock.Anything, mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		return cmd.Service == "turn_on"
-	})).Return(nil)
+	})).Return(200, nil)
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background()) // Load devices
 
 	// Update to ON
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true})
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	d, _ := s.GetDevice(context.Background(), "1")
@@ -440,7 +445,9 @@ func TestBridgeService_UpdateConfig_Errors(t *testing.T) {
 	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
 		{HueID: "invalid"},
 	}}
+	mockRepo.On("Get", mock.Anything).Return((*model.Config)(nil), fmt.Errorf("read error")).Once()
 	mockRepo.On("Save", mock.Anything, mock.Anything).Return(fmt.Errorf("save error")).Once()
+	mockTF.On("GetTranslator", mock.Anything).Return(stateTranslator{})
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	err := s.UpdateConfig(context.Background(), cfg)
@@ -471,15 +478,15 @@ func TestBridgeService_UpdateDeviceState_SetStateError(t *testing.T) {
 	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "light.test", State: "on"}}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_off"})
 
-	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("HA error")).Once()
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("HA error")).Once()
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
 
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false})
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
@@ -506,10 +513,10 @@ func TestBridgeService_TestDeviceAction(t *testing.T) {
 		return d.Name == "Test Light" && d.ExternalID == "light.test"
 	}), mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		return cmd.Service == "turn_on"
-	})).Return(nil).Once()
+	})).Return(200, nil).Once()
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
-	err := s.TestDeviceAction(context.Background(), vd, &model.DeviceState{On: true})
+	err := s.TestDeviceAction(context.Background(), vd, &model.DeviceState{On: true, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	// Test case 2: Bri update without explicit ON
@@ -517,14 +524,14 @@ func TestBridgeService_TestDeviceAction(t *testing.T) {
 		return d.ExternalID == "light.test"
 	}), mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		return cmd.Service == "turn_on"
-	})).Return(nil).Once()
+	})).Return(200, nil).Once()
 
 	err = s.TestDeviceAction(context.Background(), vd, &model.DeviceState{Bri: 200, UpdatedByBri: true})
 	assert.NoError(t, err)
 
 	// Test case 3: Error in SetState
-	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("HA error")).Once()
-	err = s.TestDeviceAction(context.Background(), vd, &model.DeviceState{On: false})
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("HA error")).Once()
+	err = s.TestDeviceAction(context.Background(), vd, &model.DeviceState{On: false, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	time.Sleep(100 * time.Millisecond)
@@ -558,7 +565,7 @@ func TestBridgeService_WorkerPoolLimit(t *testing.T) {
 	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
 	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "light.test", State: "on"}}, nil)
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_off"})
 	_, _ = s.GetDevices(context.Background())
 
@@ -567,25 +574,28 @@ func TestBridgeService_WorkerPoolLimit(t *testing.T) {
 		s.workerSem <- struct{}{}
 	}
 
-	// Test UpdateDeviceState limit
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false})
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "too many concurrent requests")
+	// Commands wait for a free slot instead of being rejected
+	sent := make(chan struct{}, 1)
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil).Run(func(mock.Arguments) { sent <- struct{}{} })
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false, UpdatedByOn: true})
+	assert.NoError(t, err)
 
-	// Test TestDeviceAction limit
-	err = s.TestDeviceAction(context.Background(), vd, &model.DeviceState{On: true})
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "too many concurrent requests")
+	// Test actions wait as long as the caller does
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = s.TestDeviceAction(ctx, vd, &model.DeviceState{On: true, UpdatedByOn: true})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, sent)
 
-	// Drain the semaphore
+	// Drain the semaphore, the waiting command goes through
 	for i := 0; i < 10; i++ {
 		<-s.workerSem
 	}
-
-	// Should work again
-	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	err = s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: false})
-	assert.NoError(t, err)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("queued command was not sent")
+	}
 }
 
 func TestBridgeService_RefreshCooldown(t *testing.T) {
@@ -683,7 +693,7 @@ func TestBridgeService_UpdateDeviceState_NotFound(t *testing.T) {
 	mockTF := new(MockTranslatorFactory)
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
-	err := s.UpdateDeviceState(context.Background(), "99", &model.DeviceState{On: true})
+	err := s.UpdateDeviceState(context.Background(), "99", &model.DeviceState{On: true, UpdatedByOn: true})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
 }
@@ -711,13 +721,13 @@ func TestBridgeService_UpdateDeviceState_NoOpOn(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
 
 	// Update to ON - should be NoOp (no call to SetState)
-	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true})
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{On: true, UpdatedByOn: true})
 	assert.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
@@ -744,12 +754,12 @@ func TestBridgeService_UpdateDeviceState_BriWithoutOn(t *testing.T) {
 	}, nil)
 
 	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
-	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
 	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_on"})
 
 	mockHA.On("SetState", mock.Anything, mock.Anything, mock.MatchedBy(func(cmd model.HomeAssistantCommand) bool {
 		return cmd.Service == "turn_on"
-	})).Return(nil)
+	})).Return(200, nil)
 
 	s := NewBridgeService(mockHA, mockRepo, mockTF)
 	_, _ = s.GetDevices(context.Background())
@@ -814,3 +824,421 @@ func TestBridgeService_GetDeviceMetadata(t *testing.T) {
 	meta := s.GetDeviceMetadata(model.MappingTypeLight)
 	assert.Equal(t, "TestType", meta.Type)
 }
+
+func TestBridgeService_ApplyStateChange(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockT := new(MockTranslator)
+
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{HueID: "1", Name: "Kitchen", EntityID: "light.kitchen", Type: model.MappingTypeLight},
+		{HueID: "2", Name: "Hall", EntityID: "light.hall", Type: model.MappingTypeLight},
+	}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.kitchen", State: "off"},
+		{EntityID: "light.hall", State: "off"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
+	mockT.On("ToHue", mock.MatchedBy(func(s model.HAEntityState) bool { return s.State == "off" }), mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
+	mockT.On("ToHue", mock.MatchedBy(func(s model.HAEntityState) bool { return s.State == "on" }), mock.Anything).Return(&model.DeviceState{On: true, Bri: 180})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+
+	// Ignored before the first refresh
+	s.ApplyStateChange(context.Background(), model.HAEntityState{EntityID: "light.kitchen", State: "on"})
+	assert.Empty(t, s.cachedHAStates)
+
+	_, _ = s.GetDevices(context.Background())
+	s.ApplyStateChange(context.Background(), model.HAEntityState{EntityID: "light.kitchen", State: "on"})
+
+	d, _ := s.GetDevice(context.Background(), "1")
+	assert.True(t, d.State.On)
+	assert.Equal(t, uint8(180), d.State.Bri)
+	other, _ := s.GetDevice(context.Background(), "2")
+	assert.False(t, other.State.On)
+	assert.Equal(t, "on", s.cachedHAStates[0].State)
+
+	// Unknown entities are added to the cache for entity discovery
+	s.ApplyStateChange(context.Background(), model.HAEntityState{EntityID: "switch.new", State: "on"})
+	assert.Len(t, s.cachedHAStates, 3)
+}
+
+func TestBridgeService_AddReconfigurable(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockEvents := new(MockHAPort)
+
+	cfg := &model.Config{HassURL: "http://ha:8123", HassToken: "token"}
+	mockRepo.On("Save", mock.Anything, cfg).Return(nil)
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("Configure", "http://ha:8123", "token").Return()
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{}, nil)
+	mockEvents.On("Configure", "http://ha:8123", "token").Return()
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	s.AddReconfigurable(mockEvents)
+	err := s.UpdateConfig(context.Background(), cfg)
+	assert.NoError(t, err)
+
+	mockEvents.AssertExpectations(t)
+}
+
+type MockEventsPort struct {
+	mock.Mock
+}
+
+func (m *MockEventsPort) Configure(url, token string) {
+	m.Called(url, token)
+}
+
+func (m *MockEventsPort) Run(ctx context.Context, handler ports.StateChangeHandler) {
+	m.Called(ctx, handler)
+}
+
+func TestBridgeService_WatchEvents(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockEvents := new(MockEventsPort)
+
+	cfg := &model.Config{HassURL: "http://ha:8123", HassToken: "token"}
+	mockRepo.On("Save", mock.Anything, cfg).Return(nil)
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("Configure", "http://ha:8123", "token").Return()
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{}, nil)
+	mockEvents.On("Configure", "http://ha:8123", "token").Return()
+
+	s := NewBridgeService(mockHA, mockRepo, new(MockTranslatorFactory))
+	running := make(chan struct{})
+	mockEvents.On("Run", mock.Anything, s).Return().Run(func(args mock.Arguments) { close(running) })
+
+	// The stream feeds the service and follows config updates
+	s.WatchEvents(context.Background(), mockEvents)
+	<-running
+	assert.NoError(t, s.UpdateConfig(context.Background(), cfg))
+	mockEvents.AssertExpectations(t)
+}
+
+func TestMergeState(t *testing.T) {
+	current := model.DeviceState{On: false, Bri: 250, Hue: 100, Sat: 50, Ct: 160, Effect: "none", ColorMode: "hs", Reachable: true}
+	transition := uint16(20)
+
+	inc := func(v int16) *int16 { return &v }
+
+	// Brightness increments are clamped and turn the device on
+	merged := mergeState(current, &model.DeviceState{BriInc: inc(30), UpdatedByBri: true})
+	assert.True(t, merged.On)
+	assert.Equal(t, uint8(254), merged.Bri)
+	assert.Equal(t, inc(30), merged.BriInc)
+	assert.True(t, merged.Reachable)
+	merged = mergeState(current, &model.DeviceState{BriInc: inc(0), UpdatedByBri: true})
+	assert.Equal(t, uint8(250), merged.Bri)
+
+	// Absolute brightness wins
+	merged = mergeState(current, &model.DeviceState{Bri: 10, UpdatedByBri: true})
+	assert.Equal(t, uint8(10), merged.Bri)
+
+	// Partial hue/sat keeps the other component
+	merged = mergeState(current, &model.DeviceState{On: true, Hue: 30000, UpdatedByHue: true})
+	assert.Equal(t, uint16(30000), merged.Hue)
+	assert.Equal(t, uint8(50), merged.Sat)
+	assert.Equal(t, "hs", merged.ColorMode)
+	merged = mergeState(current, &model.DeviceState{On: true, Sat: 200, UpdatedBySat: true})
+	assert.Equal(t, uint8(200), merged.Sat)
+
+	// Colour temperature, absolute and relative
+	merged = mergeState(current, &model.DeviceState{Ct: 370, UpdatedByCt: true, TransitionTime: &transition})
+	assert.True(t, merged.On)
+	assert.Equal(t, uint16(370), merged.Ct)
+	assert.Equal(t, "ct", merged.ColorMode)
+	assert.Equal(t, &transition, merged.TransitionTime)
+	merged = mergeState(current, &model.DeviceState{CtInc: inc(-100), UpdatedByCt: true})
+	assert.Equal(t, uint16(153), merged.Ct)
+	merged = mergeState(current, &model.DeviceState{CtInc: inc(0), UpdatedByCt: true})
+	assert.Equal(t, uint16(160), merged.Ct)
+
+	// xy takes precedence over everything else
+	xy := []float32{0.3, 0.4}
+	merged = mergeState(current, &model.DeviceState{Xy: xy, UpdatedByXy: true, Ct: 200, UpdatedByCt: true})
+	assert.Equal(t, "xy", merged.ColorMode)
+	assert.Equal(t, xy, merged.Xy)
+	xy[0] = 0.9
+	assert.Equal(t, float32(0.3), merged.Xy[0])
+
+	// Effects persist, alerts do not leak into the next update. Neither turns the device on.
+	merged = mergeState(current, &model.DeviceState{Effect: "colorloop", Alert: "select"})
+	assert.False(t, merged.On)
+	assert.Equal(t, "colorloop", merged.Effect)
+	assert.Equal(t, "select", merged.Alert)
+	assert.True(t, merged.UpdatedByEffect)
+	merged = mergeState(merged, &model.DeviceState{On: true, UpdatedByOn: true})
+	assert.True(t, merged.On)
+	assert.Equal(t, "colorloop", merged.Effect)
+	assert.False(t, merged.UpdatedByEffect)
+
+	// An explicit "on": false wins over brightness and colour
+	merged = mergeState(merged, &model.DeviceState{On: false, UpdatedByOn: true, Bri: 1, UpdatedByBri: true})
+	assert.False(t, merged.On)
+	assert.Equal(t, uint8(1), merged.Bri)
+	merged = mergeState(merged, &model.DeviceState{On: false, UpdatedByOn: true, Ct: 300, UpdatedByCt: true})
+	assert.False(t, merged.On)
+	merged = mergeState(merged, &model.DeviceState{On: false, UpdatedByOn: true})
+	assert.False(t, merged.On)
+	assert.Equal(t, "colorloop", merged.Effect)
+	assert.Empty(t, merged.Alert)
+	assert.False(t, merged.UpdatedByXy)
+}
+
+func TestBridgeService_UpdateDeviceState_CommandFieldsNotReported(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockT := new(MockTranslator)
+
+	vd := &model.VirtualDevice{HueID: "1", EntityID: "light.test", Type: model.MappingTypeLight}
+	mockRepo.On("Get", mock.Anything).Return(&model.Config{VirtualDevices: []*model.VirtualDevice{vd}}, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "light.test", State: "on"}}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true, Bri: 100})
+	mockT.On("ToHA", mock.MatchedBy(func(s *model.DeviceState) bool {
+		return s.Alert == "lselect" && s.TransitionTime != nil && s.BriInc != nil && *s.BriInc == 10
+	}), mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_on"})
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil)
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	_, _ = s.GetDevices(context.Background())
+
+	transition := uint16(4)
+	briInc := int16(10)
+	err := s.UpdateDeviceState(context.Background(), "1", &model.DeviceState{BriInc: &briInc, UpdatedByBri: true, Alert: "lselect", TransitionTime: &transition})
+	assert.NoError(t, err)
+
+	d, _ := s.GetDevice(context.Background(), "1")
+	assert.Equal(t, uint8(110), d.State.Bri)
+	assert.Nil(t, d.State.TransitionTime)
+	assert.Nil(t, d.State.BriInc)
+	assert.Empty(t, d.State.Alert)
+
+	time.Sleep(50 * time.Millisecond)
+	mockHA.AssertExpectations(t)
+}
+
+func TestBridgeService_UnreachableWhileHADown(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockT := new(MockTranslator)
+
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{{HueID: "1", EntityID: "light.test", Type: model.MappingTypeLight}}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "light.test", State: "on"}}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: true, Reachable: true})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	devices, err := s.GetDevices(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, devices[0].State.Reachable)
+
+	mockHA.unavailable = true
+	devices, _ = s.GetDevices(context.Background())
+	assert.False(t, devices[0].State.Reachable)
+	d, _ := s.GetDevice(context.Background(), "1")
+	assert.False(t, d.State.Reachable)
+
+	// The last known state is kept for when HA comes back
+	mockHA.unavailable = false
+	d, _ = s.GetDevice(context.Background(), "1")
+	assert.True(t, d.State.Reachable)
+}
+
+func TestBridgeService_Reachability(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+
+	cfg := &model.Config{}
+	for i, entityID := range []string{"light.ok", "light.unavailable", "light.unknown", "light.missing", "button.never_pressed"} {
+		cfg.VirtualDevices = append(cfg.VirtualDevices, &model.VirtualDevice{HueID: fmt.Sprint(i + 1), EntityID: entityID, Type: model.MappingTypeLight})
+	}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.ok", State: "on"},
+		{EntityID: "light.unavailable", State: model.HAStateUnavailable},
+		{EntityID: "light.unknown", State: model.HAStateUnknown},
+		{EntityID: "button.never_pressed", State: model.HAStateUnknown},
+	}, nil)
+	// Strategies do not decide reachability
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	devices, err := s.GetDevices(ctx)
+	assert.NoError(t, err)
+	var reachable []bool
+	for _, d := range devices {
+		reachable = append(reachable, d.State.Reachable)
+	}
+	assert.Equal(t, []bool{true, false, false, false, true}, reachable)
+
+	// Pushed changes update it
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "light.unavailable", State: "off"})
+	d, _ := s.GetDevice(ctx, "2")
+	assert.True(t, d.State.Reachable)
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "light.ok", State: model.HAStateUnavailable})
+	d, _ = s.GetDevice(ctx, "1")
+	assert.False(t, d.State.Reachable)
+}
+
+// metadataTranslator advertises the light type HA reports in the model attribute
+type metadataTranslator struct {
+	stateTranslator
+	models map[string]string
+}
+
+func (tr metadataTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	tr.models[vd.EntityID], _ = haState.Attributes["model"].(string)
+	return tr.stateTranslator.ToHue(haState, vd)
+}
+
+func (tr metadataTranslator) GetEntityMetadata(vd *model.VirtualDevice) model.HueMetadata {
+	return model.HueMetadata{Type: tr.models[vd.EntityID]}
+}
+
+func TestBridgeService_EntityMetadata(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{HueID: "1", EntityID: "light.bulb", Type: model.MappingTypeLight},
+		{HueID: "2", EntityID: "cover.blind", Type: model.MappingTypeCover},
+	}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.bulb", State: "on", Attributes: model.HAFields{"model": "Dimmable light"}},
+		{EntityID: "cover.blind", State: "open"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(metadataTranslator{models: make(map[string]string)})
+	mockTF.On("GetTranslator", model.MappingTypeCover).Return(stateTranslator{})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	devices, err := s.GetDevices(ctx)
+	assert.NoError(t, err)
+	if assert.NotNil(t, devices[0].Metadata) {
+		assert.Equal(t, "Dimmable light", devices[0].Metadata.Type)
+	}
+	// Translators without entity metadata leave it to the mapping type
+	assert.Nil(t, devices[1].Metadata)
+
+	// Pushed changes update it
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "light.bulb", State: "on", Attributes: model.HAFields{"model": "Extended color light"}})
+	d, _ := s.GetDevice(ctx, "1")
+	assert.Equal(t, "Extended color light", d.Metadata.Type)
+}
+
+// onTranslator reports every state on, like strategies reading the state as "not closed"
+type onTranslator struct {
+	stateTranslator
+}
+
+func (onTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	return &model.DeviceState{On: true, Bri: 100}
+}
+
+func TestTranslateToHue_Unavailable(t *testing.T) {
+	vd := &model.VirtualDevice{EntityID: "cover.garden"}
+	for _, state := range []string{model.HAStateUnavailable, model.HAStateUnknown} {
+		hueState := translateToHue(onTranslator{}, model.HAEntityState{EntityID: vd.EntityID, State: state}, vd)
+		assert.False(t, hueState.Reachable, state)
+		assert.False(t, hueState.On, state)
+	}
+
+	hueState := translateToHue(onTranslator{}, model.HAEntityState{EntityID: vd.EntityID, State: "open"}, vd)
+	assert.True(t, hueState.Reachable)
+	assert.True(t, hueState.On)
+
+	// Mapping rules still apply
+	vd.ActionConfig = &model.ActionConfig{StateMap: map[string]string{model.HAStateUnknown: model.MappedStateOn}}
+	hueState = translateToHue(onTranslator{}, model.HAEntityState{EntityID: vd.EntityID, State: model.HAStateUnknown}, vd)
+	assert.False(t, hueState.Reachable)
+	assert.True(t, hueState.On)
+}
+
+func TestBridgeService_MappingRules(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{{
+		HueID: "1", EntityID: "sensor.pump", Type: model.MappingTypeCustom,
+		ActionConfig: &model.ActionConfig{
+			StateMap: map[string]string{"running": model.MappedStateOn, "fault": model.MappedStateUnreachable},
+			BriBands: []model.BriBand{{MaxBri: 84, Service: "script.pump_low"}},
+		},
+	}}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "sensor.pump", State: "running"}}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(stateTranslator{})
+	sent := make(chan model.HomeAssistantCommand, 1)
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil).Run(func(args mock.Arguments) {
+		sent <- args.Get(2).(model.HomeAssistantCommand)
+	})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	devices, err := s.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.True(t, devices[0].State.On)
+
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "sensor.pump", State: "fault"})
+	d, _ := s.GetDevice(ctx, "1")
+	assert.False(t, d.State.Reachable)
+
+	assert.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, Bri: 50, UpdatedByBri: true}))
+	assert.Equal(t, "script.pump_low", (<-sent).Service)
+}
+
+// validatingTranslator rejects devices without a formula
+type validatingTranslator struct {
+	stateTranslator
+}
+
+func (validatingTranslator) Validate(vd *model.VirtualDevice) error {
+	if vd.ActionConfig == nil || vd.ActionConfig.ToHueFormula == "" {
+		return fmt.Errorf("missing formula")
+	}
+	return nil
+}
+
+func TestBridgeService_UpdateConfig_Invalid(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(validatingTranslator{})
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{Name: "Lamp", EntityID: "light.lamp", Type: model.MappingTypeLight},
+		{Name: "Pool", EntityID: "sensor.pool", Type: model.MappingTypeCustom},
+	}}
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	err := s.UpdateConfig(context.Background(), cfg)
+	assert.ErrorIs(t, err, model.ErrInvalidConfig)
+	assert.ErrorContains(t, err, `device "Pool": missing formula`)
+
+	// Targets are validated with their own type and action config
+	formula := &model.ActionConfig{ToHueFormula: "x"}
+	cfg = &model.Config{VirtualDevices: []*model.VirtualDevice{{Name: "Garden", EntityID: "light.garden", Type: model.MappingTypeLight, Targets: []*model.DeviceTarget{
+		{EntityID: "sensor.valid", Type: model.MappingTypeCustom, ActionConfig: formula},
+		{EntityID: "sensor.pump", Type: model.MappingTypeCustom},
+	}}}}
+	err = s.UpdateConfig(context.Background(), cfg)
+	assert.ErrorIs(t, err, model.ErrInvalidConfig)
+	assert.ErrorContains(t, err, `device "Garden" target sensor.pump: missing formula`)
+	assert.NotContains(t, err.Error(), "sensor.valid")
+	// Nothing is saved
+	mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
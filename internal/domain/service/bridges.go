@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+// PrimaryBridgeID selects the bridge serving every device without an explicit bridge
+const PrimaryBridgeID = ""
+
+// DefaultBridgePort is the port of the bridge instances without an explicit one
+const DefaultBridgePort = 80
+
+// SetPrimaryAddress sets where the primary bridge listens, instances without an IP share it
+func (s *BridgeService) SetPrimaryAddress(ip string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.primaryIP = ip
+	s.primaryPort = port
+}
+
+// EnsureBridges returns the additional bridge instances, numbering them, partitioning the devices
+// and generating the missing identities. Changes are persisted so that restarts are stable.
+func (s *BridgeService) EnsureBridges(ctx context.Context) ([]*model.BridgeInstance, error) {
+	s.identityMu.Lock()
+	defer s.identityMu.Unlock()
+
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBridgeAddresses(cfg.Bridges); err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrInvalidConfig, err)
+	}
+
+	changed := s.assignBridges(cfg)
+	if generateBridgeIdentities(cfg.Bridges) {
+		changed = true
+	}
+	if changed {
+		if err := s.configRepo.Save(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg.Bridges, nil
+}
+
+// assignBridges numbers new instances and moves devices referencing a removed instance back to the
+// primary bridge. With MaxDevicesPerBridge set, devices overflowing the primary bridge go to the
+// first instance with room; assignments are sticky so Alexa never sees a device move.
+// It reports whether cfg was modified.
+func (s *BridgeService) assignBridges(cfg *model.Config) bool {
+	changed := false
+	maxID := 1 // The primary bridge is the first one
+	for _, b := range cfg.Bridges {
+		if id, err := strconv.Atoi(b.ID); err == nil && id > maxID {
+			maxID = id
+		}
+	}
+
+	counts := make(map[string]int, len(cfg.Bridges))
+	for _, b := range cfg.Bridges {
+		if b.ID == "" {
+			maxID++
+			b.ID = strconv.Itoa(maxID)
+			changed = true
+		}
+		counts[b.ID] = 0
+	}
+
+	for _, vd := range cfg.VirtualDevices {
+		if vd.BridgeID == PrimaryBridgeID {
+			continue
+		}
+		if _, ok := counts[vd.BridgeID]; !ok {
+			slog.Warn("Bridge: device assigned to an unknown bridge, serving it from the primary bridge", "hue_id", vd.HueID, "bridge", vd.BridgeID)
+			vd.BridgeID = PrimaryBridgeID
+			changed = true
+			continue
+		}
+		counts[vd.BridgeID]++
+	}
+
+	if cfg.MaxDevicesPerBridge <= 0 {
+		return changed
+	}
+
+	primary := 0
+	for _, vd := range cfg.VirtualDevices {
+		if vd.BridgeID != PrimaryBridgeID {
+			continue
+		}
+		primary++
+		if primary <= cfg.MaxDevicesPerBridge {
+			continue
+		}
+		for _, b := range cfg.Bridges {
+			if counts[b.ID] < cfg.MaxDevicesPerBridge {
+				vd.BridgeID = b.ID
+				counts[b.ID]++
+				changed = true
+				break
+			}
+		}
+		if vd.BridgeID == PrimaryBridgeID {
+			slog.Warn("Bridge: no bridge instance has room left, serving device from the primary bridge", "hue_id", vd.HueID, "max", cfg.MaxDevicesPerBridge)
+		}
+	}
+	return changed
+}
+
+// checkBridgeAddresses rejects instances listening on the address of the primary bridge or of
+// another instance, their server could not start
+func (s *BridgeService) checkBridgeAddresses(bridges []*model.BridgeInstance) error {
+	s.mu.RLock()
+	primaryIP, primaryPort := s.primaryIP, s.primaryPort
+	s.mu.RUnlock()
+
+	listening := map[string]string{net.JoinHostPort(primaryIP, strconv.Itoa(primaryPort)): "the primary bridge"}
+	var errs []error
+	for i, b := range bridges {
+		ip, port := b.IP, b.Port
+		if ip == "" {
+			ip = primaryIP
+		}
+		if port == 0 {
+			port = DefaultBridgePort
+		}
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		name := fmt.Sprintf("bridge instance %d", i+1)
+		if other, ok := listening[addr]; ok {
+			errs = append(errs, fmt.Errorf("%s listens on %s like %s", name, addr, other))
+			continue
+		}
+		listening[addr] = name
+	}
+	return errors.Join(errs...)
+}
+
+// generateBridgeIdentities gives the instances without an identity one, and reports whether any
+// was generated
+func generateBridgeIdentities(bridges []*model.BridgeInstance) bool {
+	generated := false
+	for _, b := range bridges {
+		if b.Identity == nil {
+			b.Identity = model.NewBridgeIdentity(randomMAC())
+			slog.Info("Generated bridge identity", "bridge", b.ID, "mac", b.Identity.MAC, "bridgeid", b.Identity.BridgeID)
+			generated = true
+		}
+	}
+	return generated
+}
+
+// keepBridgeIdentities copies the identities of the current instances to the matching new ones,
+// new instances get theirs generated
+func keepBridgeIdentities(cfg, current *model.Config) {
+	identities := make(map[string]*model.BridgeIdentity, len(current.Bridges))
+	for _, b := range current.Bridges {
+		identities[b.ID] = b.Identity
+	}
+	for _, b := range cfg.Bridges {
+		b.Identity = identities[b.ID]
+	}
+}
+
+// BridgeView exposes the devices of a single emulated bridge, groups only contain its members
+type BridgeView struct {
+	service  *BridgeService
+	bridgeID string
+}
+
+// ForBridge returns the view of the bridge with the given id, PrimaryBridgeID for the primary one
+func (s *BridgeService) ForBridge(id string) *BridgeView {
+	return &BridgeView{service: s, bridgeID: id}
+}
+
+func (v *BridgeView) member(d *model.Device) bool {
+	return d.VirtualDevice != nil && d.VirtualDevice.BridgeID == v.bridgeID
+}
+
+func (v *BridgeView) GetDevices(ctx context.Context) ([]*model.Device, error) {
+	devices, err := v.service.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*model.Device, 0, len(devices))
+	for _, d := range devices {
+		if v.member(d) {
+			members = append(members, d)
+		}
+	}
+	return members, nil
+}
+
+func (v *BridgeView) GetDevice(ctx context.Context, id string) (*model.Device, error) {
+	d, err := v.service.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !v.member(d) {
+		return nil, fmt.Errorf("device %s %w", id, model.ErrNotFound)
+	}
+	return d, nil
+}
+
+func (v *BridgeView) GetDeviceMetadata(deviceType model.MappingType) model.HueMetadata {
+	return v.service.GetDeviceMetadata(deviceType)
+}
+
+func (v *BridgeView) UpdateDeviceState(ctx context.Context, id string, stateUpdate *model.DeviceState) error {
+	if _, err := v.GetDevice(ctx, id); err != nil {
+		return err
+	}
+	return v.service.UpdateDeviceState(ctx, id, stateUpdate)
+}
+
+// GetGroups hides the groups without any member on this bridge
+func (v *BridgeView) GetGroups(ctx context.Context) ([]*model.Group, error) {
+	devices, err := v.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := v.service.groups(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*model.Group, 0, len(groups))
+	for _, g := range groups {
+		if len(g.Lights) > 0 {
+			visible = append(visible, g)
+		}
+	}
+	return visible, nil
+}
+
+func (v *BridgeView) GetGroup(ctx context.Context, id string) (*model.Group, error) {
+	devices, err := v.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return v.group(ctx, id, devices)
+}
+
+func (v *BridgeView) UpdateGroupState(ctx context.Context, id string, stateUpdate *model.DeviceState) error {
+	devices, err := v.GetDevices(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := v.group(ctx, id, devices); err != nil {
+		return err
+	}
+	return v.service.updateGroupState(ctx, id, devices, stateUpdate)
+}
+
+// group treats a group without members on this bridge as unknown, group 0 always exists
+func (v *BridgeView) group(ctx context.Context, id string, devices []*model.Device) (*model.Group, error) {
+	g, err := v.service.group(ctx, id, devices)
+	if err != nil {
+		return nil, err
+	}
+	if id != AllLightsGroupID && len(g.Lights) == 0 {
+		return nil, fmt.Errorf("group %s %w", id, model.ErrNotFound)
+	}
+	return g, nil
+}
+
+// GetBridgeIdentity returns the persisted identity of the bridge, it never writes the config.
+// Identities are generated at startup and when bridges are added.
+func (v *BridgeView) GetBridgeIdentity(ctx context.Context) (*model.BridgeIdentity, error) {
+	if v.bridgeID == PrimaryBridgeID {
+		return v.service.GetBridgeIdentity(ctx)
+	}
+	cfg, err := v.service.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range cfg.Bridges {
+		if b.ID == v.bridgeID && b.Identity != nil {
+			return b.Identity, nil
+		}
+	}
+	return nil, fmt.Errorf("bridge %s %w", v.bridgeID, model.ErrNotFound)
+}
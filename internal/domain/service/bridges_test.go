@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBridgeService_AssignBridges(t *testing.T) {
+	s := NewBridgeService(new(MockHAPort), new(MockConfigRepo), new(MockTranslatorFactory))
+
+	t.Run("Numbering And Unknown Bridges", func(t *testing.T) {
+		cfg := &model.Config{
+			Bridges: []*model.BridgeInstance{{ID: "3"}, {}, {ID: "custom"}},
+			VirtualDevices: []*model.VirtualDevice{
+				{HueID: "1", BridgeID: "custom"},
+				{HueID: "2", BridgeID: "9"},
+			},
+		}
+		assert.True(t, s.assignBridges(cfg))
+		assert.Equal(t, "4", cfg.Bridges[1].ID)
+		assert.Equal(t, "custom", cfg.VirtualDevices[0].BridgeID)
+		assert.Equal(t, PrimaryBridgeID, cfg.VirtualDevices[1].BridgeID)
+
+		// Nothing left to do
+		assert.False(t, s.assignBridges(cfg))
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		cfg := &model.Config{
+			MaxDevicesPerBridge: 2,
+			Bridges:             []*model.BridgeInstance{{ID: "2"}, {ID: "3"}},
+			VirtualDevices: []*model.VirtualDevice{
+				{HueID: "1"},
+				{HueID: "2", BridgeID: "2"}, // Explicit assignments count towards the instance
+				{HueID: "3"},
+				{HueID: "4"},
+				{HueID: "5"},
+				{HueID: "6"},
+				{HueID: "7"},
+			},
+		}
+		assert.True(t, s.assignBridges(cfg))
+		var got []string
+		for _, vd := range cfg.VirtualDevices {
+			got = append(got, vd.BridgeID)
+		}
+		// The last device has no room left and stays on the primary bridge
+		assert.Equal(t, []string{"", "2", "", "2", "3", "3", ""}, got)
+
+		// Assignments are sticky once a primary device is removed
+		cfg.VirtualDevices = cfg.VirtualDevices[1:]
+		assert.False(t, s.assignBridges(cfg))
+		assert.Equal(t, PrimaryBridgeID, cfg.VirtualDevices[5].BridgeID)
+		assert.Equal(t, "3", cfg.VirtualDevices[3].BridgeID)
+	})
+}
+
+func TestBridgeService_EnsureBridges(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Generates Identities Once", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		kept := model.NewBridgeIdentity([6]byte{2, 1, 1, 1, 1, 1})
+		cfg := &model.Config{Bridges: []*model.BridgeInstance{{ID: "2", IP: "192.168.1.20", Identity: kept}, {IP: "192.168.1.21"}}}
+		mockRepo.On("Get", ctx).Return(cfg, nil)
+		mockRepo.On("Save", ctx, cfg).Return(nil).Once()
+
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		bridges, err := s.EnsureBridges(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, bridges, 2)
+		assert.Same(t, kept, bridges[0].Identity)
+		assert.Equal(t, "3", bridges[1].ID)
+		assert.NotNil(t, bridges[1].Identity)
+		assert.NotEqual(t, kept.BridgeID, bridges[1].Identity.BridgeID)
+
+		// Nothing is saved when everything is assigned
+		_, err = s.EnsureBridges(ctx)
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		mockRepo.On("Get", ctx).Return((*model.Config)(nil), fmt.Errorf("read error")).Once()
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		_, err := s.EnsureBridges(ctx)
+		assert.Error(t, err)
+
+		mockRepo.On("Get", ctx).Return(&model.Config{Bridges: []*model.BridgeInstance{{IP: "192.168.1.21"}}}, nil)
+		mockRepo.On("Save", ctx, mock.Anything).Return(fmt.Errorf("write error"))
+		_, err = s.EnsureBridges(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("Duplicate Addresses", func(t *testing.T) {
+		s := NewBridgeService(new(MockHAPort), nil, new(MockTranslatorFactory))
+		s.SetPrimaryAddress("192.168.1.20", 80)
+		for _, bridges := range [][]*model.BridgeInstance{
+			{{ID: "2"}},                     // Defaults to the primary address
+			{{ID: "2", IP: "192.168.1.20"}}, // Explicitly
+			{{IP: "192.168.1.21"}, {IP: "192.168.1.21", Port: 80}},
+		} {
+			mockRepo := new(MockConfigRepo)
+			mockRepo.On("Get", ctx).Return(&model.Config{Bridges: bridges}, nil)
+			s.configRepo = mockRepo
+			_, err := s.EnsureBridges(ctx)
+			assert.ErrorIs(t, err, model.ErrInvalidConfig)
+			mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+		}
+
+		// Other ports and IP aliases are fine
+		assert.NoError(t, s.checkBridgeAddresses([]*model.BridgeInstance{{Port: 8080}, {IP: "192.168.1.21"}, {IP: "192.168.1.22"}}))
+	})
+}
+
+func TestBridgeService_UpdateConfig_KeepsBridgeIdentities(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+
+	identity := model.NewBridgeIdentity([6]byte{2, 1, 1, 1, 1, 1})
+	mockRepo.On("Get", mock.Anything).Return(&model.Config{Bridges: []*model.BridgeInstance{{ID: "2", Identity: identity}}}, nil)
+	mockRepo.On("Save", mock.Anything, mock.Anything).Return(nil)
+	mockHA.On("Configure", mock.Anything, mock.Anything).Return()
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{}, nil)
+
+	s := NewBridgeService(mockHA, mockRepo, new(MockTranslatorFactory))
+	cfg := &model.Config{Bridges: []*model.BridgeInstance{{ID: "2", Port: 8080}, {IP: "192.168.1.22"}}}
+	assert.NoError(t, s.UpdateConfig(context.Background(), cfg))
+	assert.Same(t, identity, cfg.Bridges[0].Identity)
+	assert.Equal(t, "3", cfg.Bridges[1].ID)
+	// New instances get theirs when saved, not when Alexa reads them
+	if assert.NotNil(t, cfg.Bridges[1].Identity) {
+		assert.NotEqual(t, identity.BridgeID, cfg.Bridges[1].Identity.BridgeID)
+	}
+
+	// Instances listening on the same address are rejected
+	err := s.UpdateConfig(context.Background(), &model.Config{Bridges: []*model.BridgeInstance{{IP: "192.168.1.22"}, {IP: "192.168.1.22"}}})
+	assert.ErrorIs(t, err, model.ErrInvalidConfig)
+}
+
+func bridgeViewTestConfig() *model.Config {
+	cfg := groupTestConfig()
+	cfg.Bridges = []*model.BridgeInstance{{ID: "2", IP: "192.168.1.21", Identity: model.NewBridgeIdentity([6]byte{2, 1, 1, 1, 1, 1})}}
+	cfg.Identity = model.NewBridgeIdentity(model.LegacyBridgeMAC)
+	cfg.VirtualDevices[1].BridgeID = "2" // The desk is served by the second bridge
+	return cfg
+}
+
+func TestBridgeView_Devices(t *testing.T) {
+	ctx := context.Background()
+	s, mockHA := newGroupTestService(t, bridgeViewTestConfig())
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil)
+	primary, second := s.ForBridge(PrimaryBridgeID), s.ForBridge("2")
+
+	devices, err := primary.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "Sofa", devices[0].Name)
+
+	devices, err = second.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "Desk", devices[0].Name)
+
+	d, err := second.GetDevice(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Desk", d.Name)
+
+	_, err = second.GetDevice(ctx, "1")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	_, err = second.GetDevice(ctx, "99")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+
+	assert.NoError(t, second.UpdateDeviceState(ctx, "2", &model.DeviceState{On: true, UpdatedByOn: true}))
+	assert.ErrorIs(t, second.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, UpdatedByOn: true}), model.ErrNotFound)
+}
+
+func TestBridgeView_Groups(t *testing.T) {
+	ctx := context.Background()
+	s, mockHA := newGroupTestService(t, bridgeViewTestConfig())
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil)
+	second := s.ForBridge("2")
+
+	// The sofa zone has no member on the second bridge
+	groups, err := second.GetGroups(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"2"}, groups[0].Lights)
+
+	_, err = second.GetGroup(ctx, "2")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	all, err := second.GetGroup(ctx, AllLightsGroupID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, all.Lights)
+
+	// Only the members of this bridge are switched
+	assert.NoError(t, second.UpdateGroupState(ctx, "1", &model.DeviceState{On: true, Bri: 10, UpdatedByBri: true}))
+	desk, _ := s.GetDevice(ctx, "2")
+	assert.Equal(t, uint8(10), desk.State.Bri)
+	sofa, _ := s.GetDevice(ctx, "1")
+	assert.Equal(t, uint8(200), sofa.State.Bri)
+	assert.ErrorIs(t, second.UpdateGroupState(ctx, "2", &model.DeviceState{On: true, UpdatedByOn: true}), model.ErrNotFound)
+
+	mockT := s.translatorFactory.GetTranslator(model.MappingTypeLight).(*MockTranslator)
+	mockT.On("GetMetadata").Return(model.HueMetadata{ModelID: "LCT015"})
+	assert.Equal(t, "LCT015", second.GetDeviceMetadata(model.MappingTypeLight).ModelID)
+}
+
+func TestBridgeView_GetBridgeIdentity(t *testing.T) {
+	ctx := context.Background()
+	cfg := bridgeViewTestConfig()
+	s, _ := newGroupTestService(t, cfg)
+
+	identity, err := s.ForBridge(PrimaryBridgeID).GetBridgeIdentity(ctx)
+	assert.NoError(t, err)
+	assert.Same(t, cfg.Identity, identity)
+
+	identity, err = s.ForBridge("2").GetBridgeIdentity(ctx)
+	assert.NoError(t, err)
+	assert.Same(t, cfg.Bridges[0].Identity, identity)
+
+	_, err = s.ForBridge("7").GetBridgeIdentity(ctx)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestBridgeView_GetBridgeIdentity_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockConfigRepo)
+	mockRepo.On("Get", ctx).Return(&model.Config{Bridges: []*model.BridgeInstance{{IP: "192.168.1.21"}}}, nil)
+	s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+
+	// Nothing is generated nor numbered on reads
+	_, err := s.ForBridge(PrimaryBridgeID).GetBridgeIdentity(ctx)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	_, err = s.ForBridge("2").GetBridgeIdentity(ctx)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
+
+func TestBridgeView_Errors(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockConfigRepo)
+	s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+	view := s.ForBridge("2")
+
+	// Devices cannot be loaded
+	mockRepo.On("Get", mock.Anything).Return((*model.Config)(nil), fmt.Errorf("repo error"))
+	_, err := view.GetDevices(ctx)
+	assert.Error(t, err)
+	_, err = view.GetDevice(ctx, "1")
+	assert.Error(t, err)
+	_, err = view.GetGroups(ctx)
+	assert.Error(t, err)
+	_, err = view.GetGroup(ctx, "1")
+	assert.Error(t, err)
+	assert.Error(t, view.UpdateGroupState(ctx, "1", &model.DeviceState{}))
+	_, err = view.GetBridgeIdentity(ctx)
+	assert.Error(t, err)
+
+	// Devices are loaded but the config cannot be read afterwards
+	s.initialized = true
+	_, err = view.GetGroups(ctx)
+	assert.Error(t, err)
+	_, err = view.GetGroup(ctx, "1")
+	assert.Error(t, err)
+}
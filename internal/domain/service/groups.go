@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
+	"strconv"
+)
+
+// AllLightsGroupID is the special Hue group that always contains every light
+const AllLightsGroupID = "0"
+
+func (s *BridgeService) GetGroups(ctx context.Context) ([]*model.Group, error) {
+	devices, err := s.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.groups(ctx, devices)
+}
+
+// groups builds the configured groups restricted to the given devices
+func (s *BridgeService) groups(ctx context.Context, devices []*model.Device) ([]*model.Group, error) {
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index := indexDevices(devices)
+	groups := make([]*model.Group, 0, len(cfg.VirtualGroups))
+	for _, vg := range cfg.VirtualGroups {
+		groups = append(groups, buildGroup(vg, index))
+	}
+	return groups, nil
+}
+
+func (s *BridgeService) GetGroup(ctx context.Context, id string) (*model.Group, error) {
+	devices, err := s.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.group(ctx, id, devices)
+}
+
+func (s *BridgeService) group(ctx context.Context, id string, devices []*model.Device) (*model.Group, error) {
+	vg, err := s.findGroup(ctx, id, devices)
+	if err != nil {
+		return nil, err
+	}
+	return buildGroup(vg, indexDevices(devices)), nil
+}
+
+// UpdateGroupState fans the action out to every member light
+func (s *BridgeService) UpdateGroupState(ctx context.Context, id string, stateUpdate *model.DeviceState) error {
+	devices, err := s.GetDevices(ctx)
+	if err != nil {
+		return err
+	}
+	return s.updateGroupState(ctx, id, devices, stateUpdate)
+}
+
+// updateGroupState only reaches the members found in devices
+func (s *BridgeService) updateGroupState(ctx context.Context, id string, devices []*model.Device, stateUpdate *model.DeviceState) error {
+	vg, err := s.findGroup(ctx, id, devices)
+	if err != nil {
+		return err
+	}
+
+	index := indexDevices(devices)
+	var errs []error
+	for _, lightID := range vg.Lights {
+		if _, ok := index[lightID]; !ok {
+			slog.Warn("Bridge: group member not found", "group_id", id, "hue_id", lightID)
+			continue
+		}
+		update := *stateUpdate
+		if err := s.UpdateDeviceState(ctx, lightID, &update); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *BridgeService) findGroup(ctx context.Context, id string, devices []*model.Device) (*model.VirtualGroup, error) {
+	if id == AllLightsGroupID {
+		vg := &model.VirtualGroup{ID: AllLightsGroupID, Name: "All lights", Type: "LightGroup"}
+		for _, d := range devices {
+			vg.Lights = append(vg.Lights, d.ID)
+		}
+		return vg, nil
+	}
+
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, vg := range cfg.VirtualGroups {
+		if vg.ID == id {
+			return vg, nil
+		}
+	}
+	return nil, fmt.Errorf("group %s %w", id, model.ErrNotFound)
+}
+
+func (s *BridgeService) assignGroupIDs(cfg *model.Config) {
+	// Group 0 is reserved for "all lights"
+	maxID := 0
+	for _, vg := range cfg.VirtualGroups {
+		if id, err := strconv.Atoi(vg.ID); err == nil && id > maxID {
+			maxID = id
+		}
+	}
+
+	for _, vg := range cfg.VirtualGroups {
+		if vg.ID == "" {
+			maxID++
+			vg.ID = strconv.Itoa(maxID)
+		}
+	}
+}
+
+// checkGroupIDs rejects the reserved all-lights ID and IDs used by several groups, Hue clients
+// could not tell the groups apart. Empty IDs are assigned later.
+func checkGroupIDs(groups []*model.VirtualGroup) error {
+	var errs []error
+	seen := make(map[string]bool, len(groups))
+	for _, vg := range groups {
+		switch {
+		case vg.ID == "":
+			continue
+		case vg.ID == AllLightsGroupID:
+			errs = append(errs, fmt.Errorf("group %q: ID %s is reserved for all lights", vg.Name, vg.ID))
+		case seen[vg.ID]:
+			errs = append(errs, fmt.Errorf("group %q: ID %s is already used", vg.Name, vg.ID))
+		}
+		seen[vg.ID] = true
+	}
+	return errors.Join(errs...)
+}
+
+func indexDevices(devices []*model.Device) map[string]*model.Device {
+	index := make(map[string]*model.Device, len(devices))
+	for _, d := range devices {
+		index[d.ID] = d
+	}
+	return index
+}
+
+// buildGroup aggregates the member states into any_on/all_on and a representative action
+func buildGroup(vg *model.VirtualGroup, index map[string]*model.Device) *model.Group {
+	g := &model.Group{
+		ID:     vg.ID,
+		Name:   vg.Name,
+		Type:   vg.Type,
+		Class:  vg.Class,
+		Lights: []string{},
+		Action: &model.DeviceState{},
+	}
+	if g.Type == "" {
+		g.Type = "Room"
+	}
+
+	onCount, briSum := 0, 0
+	for _, id := range vg.Lights {
+		d, ok := index[id]
+		if !ok {
+			continue
+		}
+		g.Lights = append(g.Lights, id)
+		if d.State != nil && d.State.On {
+			onCount++
+			briSum += int(d.State.Bri)
+		}
+	}
+
+	g.State.AnyOn = onCount > 0
+	g.State.AllOn = len(g.Lights) > 0 && onCount == len(g.Lights)
+	g.Action.On = g.State.AnyOn
+	if onCount > 0 {
+		g.Action.Bri = uint8(briSum / onCount)
+	}
+	return g
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newGroupTestService(t *testing.T, cfg *model.Config) (*BridgeService, *MockHAPort) {
+	t.Helper()
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	mockT := new(MockTranslator)
+
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.sofa", State: "on"},
+		{EntityID: "light.desk", State: "off"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(mockT)
+	mockT.On("ToHue", mock.MatchedBy(func(s model.HAEntityState) bool { return s.State == "on" }), mock.Anything).Return(&model.DeviceState{On: true, Bri: 200})
+	mockT.On("ToHue", mock.Anything, mock.Anything).Return(&model.DeviceState{On: false, UpdatedByOn: true})
+	mockT.On("ToHA", mock.Anything, mock.Anything).Return(model.HomeAssistantCommand{Service: "turn_off"})
+
+	return NewBridgeService(mockHA, mockRepo, mockTF), mockHA
+}
+
+func groupTestConfig() *model.Config {
+	return &model.Config{
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Sofa", EntityID: "light.sofa", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+		},
+		VirtualGroups: []*model.VirtualGroup{
+			{ID: "1", Name: "Living Room", Lights: []string{"1", "2", "99"}},
+			{ID: "2", Name: "Sofa Zone", Type: "Zone", Lights: []string{"1"}},
+		},
+	}
+}
+
+func TestBridgeService_GetGroups(t *testing.T) {
+	s, _ := newGroupTestService(t, groupTestConfig())
+
+	groups, err := s.GetGroups(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+
+	living := groups[0]
+	assert.Equal(t, "Room", living.Type)
+	assert.Equal(t, []string{"1", "2"}, living.Lights) // Unknown member is dropped
+	assert.True(t, living.State.AnyOn)
+	assert.False(t, living.State.AllOn)
+	assert.True(t, living.Action.On)
+	assert.Equal(t, uint8(200), living.Action.Bri)
+
+	zone := groups[1]
+	assert.Equal(t, "Zone", zone.Type)
+	assert.True(t, zone.State.AllOn)
+}
+
+func TestBridgeService_GetGroup(t *testing.T) {
+	s, _ := newGroupTestService(t, groupTestConfig())
+
+	g, err := s.GetGroup(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sofa Zone", g.Name)
+
+	// Group 0 contains every light
+	all, err := s.GetGroup(context.Background(), AllLightsGroupID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, all.Lights)
+	assert.True(t, all.State.AnyOn)
+
+	_, err = s.GetGroup(context.Background(), "42")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestBridgeService_UpdateGroupState(t *testing.T) {
+	s, mockHA := newGroupTestService(t, groupTestConfig())
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil)
+
+	err := s.UpdateGroupState(context.Background(), "1", &model.DeviceState{On: false, UpdatedByOn: true})
+	assert.NoError(t, err)
+
+	g, _ := s.GetGroup(context.Background(), "1")
+	assert.False(t, g.State.AnyOn)
+	assert.Equal(t, uint8(0), g.Action.Bri)
+
+	err = s.UpdateGroupState(context.Background(), "42", &model.DeviceState{On: true, UpdatedByOn: true})
+	assert.Error(t, err)
+}
+
+func TestBridgeService_UpdateGroupState_MemberError(t *testing.T) {
+	s, _ := newGroupTestService(t, groupTestConfig())
+
+	// The member vanished between the device snapshot and the update
+	devices := []*model.Device{{ID: "1"}}
+	err := s.updateGroupState(context.Background(), "2", devices, &model.DeviceState{On: false, UpdatedByOn: true})
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestBridgeService_Groups_Errors(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+
+	// Devices cannot be loaded
+	mockRepo.On("Get", mock.Anything).Return((*model.Config)(nil), fmt.Errorf("repo error")).Times(3)
+	_, err := s.GetGroups(context.Background())
+	assert.Error(t, err)
+	_, err = s.GetGroup(context.Background(), "1")
+	assert.Error(t, err)
+	err = s.UpdateGroupState(context.Background(), "1", &model.DeviceState{})
+	assert.Error(t, err)
+
+	// Devices are loaded but the config cannot be read afterwards
+	s.initialized = true
+	mockRepo.On("Get", mock.Anything).Return((*model.Config)(nil), fmt.Errorf("repo error")).Times(3)
+	_, err = s.GetGroups(context.Background())
+	assert.Error(t, err)
+	_, err = s.GetGroup(context.Background(), "1")
+	assert.Error(t, err)
+	err = s.UpdateGroupState(context.Background(), "1", &model.DeviceState{})
+	assert.Error(t, err)
+}
+
+func TestBridgeService_AssignGroupIDs(t *testing.T) {
+	s := NewBridgeService(new(MockHAPort), new(MockConfigRepo), new(MockTranslatorFactory))
+	cfg := &model.Config{VirtualGroups: []*model.VirtualGroup{
+		{ID: "3", Name: "Kitchen"},
+		{Name: "Bedroom"},
+		{ID: "invalid", Name: "Legacy"},
+	}}
+	s.assignGroupIDs(cfg)
+	assert.Equal(t, "4", cfg.VirtualGroups[1].ID)
+	assert.Equal(t, "invalid", cfg.VirtualGroups[2].ID)
+}
+
+func TestCheckGroupIDs(t *testing.T) {
+	assert.NoError(t, checkGroupIDs([]*model.VirtualGroup{{ID: "1"}, {ID: "2"}, {}, {}}))
+	assert.Error(t, checkGroupIDs([]*model.VirtualGroup{{ID: AllLightsGroupID, Name: "All"}}))
+	assert.Error(t, checkGroupIDs([]*model.VirtualGroup{{ID: "1", Name: "Kitchen"}, {ID: "1", Name: "Bedroom"}}))
+
+	// Rejected when the configuration is saved
+	s := NewBridgeService(new(MockHAPort), new(MockConfigRepo), new(MockTranslatorFactory))
+	err := s.UpdateConfig(context.Background(), &model.Config{VirtualGroups: []*model.VirtualGroup{{ID: "0", Name: "All"}}})
+	assert.ErrorIs(t, err, model.ErrInvalidConfig)
+}
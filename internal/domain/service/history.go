@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"hue-bridge-emulator/internal/domain/model"
+	"sync"
+	"time"
+)
+
+const DefaultCommandHistorySize = 500
+
+// commandHistory keeps the latest dispatched commands, the oldest are dropped once it is full
+type commandHistory struct {
+	mu      sync.Mutex
+	size    int
+	records []model.CommandRecord
+}
+
+func (h *commandHistory) add(r model.CommandRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.size <= 0 {
+		return
+	}
+	if len(h.records) >= h.size {
+		n := copy(h.records, h.records[len(h.records)-h.size+1:])
+		h.records = h.records[:n]
+	}
+	h.records = append(h.records, r)
+}
+
+// list returns the records selected by filter, newest first
+func (h *commandHistory) list(filter model.CommandFilter) []model.CommandRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := []model.CommandRecord{}
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(res) >= filter.Limit {
+			break
+		}
+		if filter.Matches(h.records[i]) {
+			res = append(res, h.records[i])
+		}
+	}
+	return res
+}
+
+func (h *commandHistory) resize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.size = size
+	if len(h.records) > size {
+		h.records = append([]model.CommandRecord(nil), h.records[len(h.records)-max(size, 0):]...)
+	}
+}
+
+// SetCommandHistorySize sets how many dispatched commands are kept for the admin, 0 disables the history
+func (s *BridgeService) SetCommandHistorySize(size int) {
+	s.history.resize(size)
+}
+
+// GetCommands returns the dispatched commands selected by filter, newest first
+func (s *BridgeService) GetCommands(ctx context.Context, filter model.CommandFilter) []model.CommandRecord {
+	return s.history.list(filter)
+}
+
+// recordCommand adds the outcome of a command sent to HA at start to the history, with the HTTP
+// status HA answered with when it did
+func (s *BridgeService) recordCommand(device *model.Device, cmd model.HomeAssistantCommand, start time.Time, status int, err error) {
+	r := model.CommandRecord{
+		Time:       start,
+		DeviceID:   device.ID,
+		DeviceName: device.Name,
+		EntityID:   device.ExternalID,
+		Service:    cmd.Service,
+		Data:       cmd.Data,
+		Status:     model.CommandSucceeded,
+		HTTPStatus: status,
+		LatencyMs:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		r.Status = model.CommandFailed
+		r.Error = err.Error()
+		var statusErr *model.HAStatusError
+		if errors.As(err, &statusErr) {
+			r.HTTPStatus = statusErr.StatusCode
+		}
+	}
+	s.history.add(r)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandHistory(t *testing.T) {
+	h := &commandHistory{size: 3}
+	for i := 1; i <= 5; i++ {
+		status := model.CommandSucceeded
+		if i%2 == 0 {
+			status = model.CommandFailed
+		}
+		h.add(model.CommandRecord{DeviceID: fmt.Sprint(i % 2), EntityID: fmt.Sprintf("light.l%d", i), Status: status})
+	}
+
+	// Bounded, newest first
+	all := h.list(model.CommandFilter{})
+	require.Len(t, all, 3)
+	assert.Equal(t, "light.l5", all[0].EntityID)
+	assert.Equal(t, "light.l3", all[2].EntityID)
+
+	failed := h.list(model.CommandFilter{Status: model.CommandFailed})
+	require.Len(t, failed, 1)
+	assert.Equal(t, "light.l4", failed[0].EntityID)
+
+	assert.Len(t, h.list(model.CommandFilter{Device: "1"}), 2)
+	assert.Len(t, h.list(model.CommandFilter{Device: "light.l3"}), 1)
+	assert.Len(t, h.list(model.CommandFilter{Limit: 2}), 2)
+	assert.Empty(t, h.list(model.CommandFilter{Device: "light.l1"}))
+
+	// Shrinking keeps the newest records
+	h.resize(1)
+	all = h.list(model.CommandFilter{})
+	require.Len(t, all, 1)
+	assert.Equal(t, "light.l5", all[0].EntityID)
+
+	// A size of 0 disables the history
+	h.resize(0)
+	h.add(model.CommandRecord{})
+	assert.Empty(t, h.list(model.CommandFilter{}))
+}
+
+func TestBridgeService_RecordsCommands(t *testing.T) {
+	ctx := context.Background()
+	s, mockHA := newGroupTestService(t, groupTestConfig())
+	sent := make(chan struct{}, 2)
+	mockHA.On("SetState", mock.Anything, mock.MatchedBy(func(d *model.Device) bool { return d.ID == "1" }), mock.Anything).
+		Return(201, nil).Run(func(mock.Arguments) { sent <- struct{}{} })
+	mockHA.On("SetState", mock.Anything, mock.MatchedBy(func(d *model.Device) bool { return d.ID == "test" }), mock.Anything).
+		Return(0, fmt.Errorf("connection refused")).Run(func(mock.Arguments) { sent <- struct{}{} })
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).
+		Return(502, fmt.Errorf("call failed: %w", &model.HAStatusError{StatusCode: 502})).Run(func(mock.Arguments) { sent <- struct{}{} })
+	_, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: false, UpdatedByOn: true}))
+	<-sent
+	require.Eventually(t, func() bool { return len(s.GetCommands(ctx, model.CommandFilter{})) == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, s.UpdateDeviceState(ctx, "2", &model.DeviceState{On: false, UpdatedByOn: true}))
+	<-sent
+
+	var commands []model.CommandRecord
+	require.Eventually(t, func() bool {
+		commands = s.GetCommands(ctx, model.CommandFilter{})
+		return len(commands) == 2
+	}, time.Second, time.Millisecond)
+
+	failed := commands[0]
+	assert.Equal(t, "2", failed.DeviceID)
+	assert.Equal(t, "Desk", failed.DeviceName)
+	assert.Equal(t, "light.desk", failed.EntityID)
+	assert.Equal(t, "turn_off", failed.Service)
+	assert.Equal(t, model.CommandFailed, failed.Status)
+	assert.Equal(t, 502, failed.HTTPStatus)
+	assert.Equal(t, "call failed: HA API error: 502", failed.Error)
+
+	ok := commands[1]
+	assert.Equal(t, model.CommandSucceeded, ok.Status)
+	// The status is the one HA answered with
+	assert.Equal(t, 201, ok.HTTPStatus)
+	assert.Empty(t, ok.Error)
+
+	// Errors without an HTTP status, e.g. HA unreachable
+	s.SetCommandHistorySize(1)
+	assert.NoError(t, s.TestDeviceAction(ctx, &model.VirtualDevice{Name: "Test", EntityID: "light.test", Type: model.MappingTypeLight}, &model.DeviceState{}))
+	<-sent
+	require.Eventually(t, func() bool {
+		commands = s.GetCommands(ctx, model.CommandFilter{Status: model.CommandFailed})
+		return len(commands) == 1 && commands[0].DeviceID == "test"
+	}, time.Second, time.Millisecond)
+	assert.Zero(t, commands[0].HTTPStatus)
+	assert.Equal(t, "Test", commands[0].DeviceName)
+}
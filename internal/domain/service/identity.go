@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
+)
+
+// EnsureIdentity returns the persisted bridge identity, generating it on first start from mac,
+// or from a random locally administered address when mac is not a 6 byte hardware address.
+func (s *BridgeService) EnsureIdentity(ctx context.Context, mac []byte) (*model.BridgeIdentity, error) {
+	s.identityMu.Lock()
+	defer s.identityMu.Unlock()
+
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Identity != nil {
+		return cfg.Identity, nil
+	}
+
+	var addr [6]byte
+	switch {
+	case len(cfg.VirtualDevices) > 0:
+		// Installations from before generated identities keep the bridge Alexa already paired with
+		addr = model.LegacyBridgeMAC
+	case len(mac) == 6:
+		copy(addr[:], mac)
+	default:
+		addr = randomMAC()
+	}
+
+	cfg.Identity = model.NewBridgeIdentity(addr)
+	if err := s.configRepo.Save(ctx, cfg); err != nil {
+		return nil, err
+	}
+	slog.Info("Generated bridge identity", "mac", cfg.Identity.MAC, "bridgeid", cfg.Identity.BridgeID)
+	return cfg.Identity, nil
+}
+
+// RegenerateIdentity replaces the identity of the primary bridge with one derived from a random
+// address. Alexa sees a new bridge: its devices have to be discovered again, and the ones of the
+// old bridge removed in the Alexa app. SSDP advertises the new identity after a restart.
+func (s *BridgeService) RegenerateIdentity(ctx context.Context) (*model.BridgeIdentity, error) {
+	s.identityMu.Lock()
+	defer s.identityMu.Unlock()
+
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Identity = model.NewBridgeIdentity(randomMAC())
+	if err := s.configRepo.Save(ctx, cfg); err != nil {
+		return nil, err
+	}
+	slog.Warn("Regenerated bridge identity, Alexa has to discover the devices again", "mac", cfg.Identity.MAC, "bridgeid", cfg.Identity.BridgeID)
+	return cfg.Identity, nil
+}
+
+// GetBridgeIdentity returns the persisted identity without generating it, EnsureIdentity does
+// at startup
+func (s *BridgeService) GetBridgeIdentity(ctx context.Context) (*model.BridgeIdentity, error) {
+	cfg, err := s.configRepo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Identity == nil {
+		return nil, fmt.Errorf("bridge identity %w", model.ErrNotFound)
+	}
+	return cfg.Identity, nil
+}
+
+// randomMAC returns a unicast, locally administered address
+func randomMAC() [6]byte {
+	var addr [6]byte
+	rand.Read(addr[:])
+	addr[0] = addr[0]&0xfe | 0x02
+	return addr
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBridgeService_EnsureIdentity(t *testing.T) {
+	ctx := context.Background()
+	mac := []byte{0xb8, 0x27, 0xeb, 0x0a, 0x1b, 0x2c}
+
+	t.Run("Persisted", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		existing := model.NewBridgeIdentity([6]byte{1, 2, 3, 4, 5, 6})
+		mockRepo.On("Get", ctx).Return(&model.Config{Identity: existing}, nil)
+
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		identity, err := s.EnsureIdentity(ctx, mac)
+		assert.NoError(t, err)
+		assert.Same(t, existing, identity)
+		mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	})
+
+	t.Run("From Interface MAC", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		cfg := &model.Config{}
+		mockRepo.On("Get", ctx).Return(cfg, nil)
+		mockRepo.On("Save", ctx, cfg).Return(nil).Once()
+
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		identity, err := s.EnsureIdentity(ctx, mac)
+		assert.NoError(t, err)
+		assert.Equal(t, "B827EBFFFE0A1B2C", identity.BridgeID)
+		assert.Same(t, identity, cfg.Identity)
+
+		// Later lookups reuse the persisted identity
+		again, err := s.GetBridgeIdentity(ctx)
+		assert.NoError(t, err)
+		assert.Same(t, identity, again)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		mockRepo.On("Get", ctx).Return(&model.Config{}, nil)
+		mockRepo.On("Save", ctx, mock.Anything).Return(nil)
+
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		identity, err := s.EnsureIdentity(ctx, nil)
+		assert.NoError(t, err)
+		assert.Len(t, identity.Serial, 12)
+		// Unicast, locally administered
+		assert.Contains(t, "26ae", identity.Serial[1:2])
+	})
+
+	t.Run("Legacy Installation", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		mockRepo.On("Get", ctx).Return(&model.Config{VirtualDevices: []*model.VirtualDevice{{HueID: "1"}}}, nil)
+		mockRepo.On("Save", ctx, mock.Anything).Return(nil)
+
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		identity, err := s.EnsureIdentity(ctx, mac)
+		assert.NoError(t, err)
+		assert.Equal(t, "001788FFFE102201", identity.BridgeID)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		mockRepo := new(MockConfigRepo)
+		mockRepo.On("Get", ctx).Return((*model.Config)(nil), fmt.Errorf("read error")).Once()
+		s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+		_, err := s.EnsureIdentity(ctx, mac)
+		assert.Error(t, err)
+
+		mockRepo.On("Get", ctx).Return(&model.Config{}, nil)
+		mockRepo.On("Save", ctx, mock.Anything).Return(fmt.Errorf("write error"))
+		_, err = s.EnsureIdentity(ctx, mac)
+		assert.Error(t, err)
+	})
+}
+
+func TestBridgeService_UpdateConfig_KeepsIdentity(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+
+	identity := model.NewBridgeIdentity(model.LegacyBridgeMAC)
+	mockRepo.On("Get", mock.Anything).Return(&model.Config{Identity: identity}, nil)
+	mockRepo.On("Save", mock.Anything, mock.MatchedBy(func(cfg *model.Config) bool {
+		return cfg.Identity == identity
+	})).Return(nil)
+	mockHA.On("Configure", mock.Anything, mock.Anything).Return()
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{}, nil)
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	assert.NoError(t, s.UpdateConfig(context.Background(), &model.Config{HassURL: "http://ha"}))
+
+	// Edited identities are dropped too
+	edited := &model.Config{HassURL: "http://ha", Identity: model.NewBridgeIdentity([6]byte{2, 0, 0, 0, 0, 1})}
+	assert.NoError(t, s.UpdateConfig(context.Background(), edited))
+	assert.Same(t, identity, edited.Identity)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBridgeService_RegenerateIdentity(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockConfigRepo)
+	old := model.NewBridgeIdentity(model.LegacyBridgeMAC)
+	cfg := &model.Config{Identity: old}
+	mockRepo.On("Get", ctx).Return(cfg, nil)
+	mockRepo.On("Save", ctx, cfg).Return(nil).Once()
+
+	s := NewBridgeService(new(MockHAPort), mockRepo, new(MockTranslatorFactory))
+	identity, err := s.RegenerateIdentity(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, old.BridgeID, identity.BridgeID)
+	assert.Same(t, identity, cfg.Identity)
+
+	mockRepo.On("Save", ctx, cfg).Return(fmt.Errorf("write error"))
+	_, err = s.RegenerateIdentity(ctx)
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+)
+
+// PreviewTranslation computes what the main entity of vd translates to without calling HA: the
+// state reported for haState, the command sent for hueState, and with sweep the commands setting
+// every brightness from 0 to 254. Invalid device configurations are rejected like on save.
+// Stateful translators are replaced by a blank instance, what they learn from haState must not
+// leak into the live devices.
+func (s *BridgeService) PreviewTranslation(ctx context.Context, vd *model.VirtualDevice, haState *model.HAEntityState, hueState *model.DeviceState, sweep bool) (*model.TranslationPreview, error) {
+	if err := s.validateConfig(&model.Config{VirtualDevices: []*model.VirtualDevice{vd}}); err != nil {
+		return nil, err
+	}
+
+	t := s.translatorFactory.GetTranslator(vd.Type)
+	if stateful, ok := t.(ports.StatefulTranslator); ok {
+		t = stateful.Blank()
+	}
+
+	preview := &model.TranslationPreview{}
+	if haState != nil {
+		st := *haState
+		if st.EntityID == "" {
+			st.EntityID = vd.StateEntity()
+		}
+		preview.State = translateToHue(t, st.WithStateFromAttribute(vd.StateAttribute), vd)
+	}
+	if hueState != nil {
+		// Without a current state, brightness and colour turn the device on and the rest keeps it off
+		state := mergeState(model.DeviceState{Reachable: true}, hueState)
+		cmd := translateToHA(t, &state, vd)
+		preview.Command = &cmd
+	}
+	if sweep {
+		preview.Sweep = make([]model.BriCommand, 0, 255)
+		for bri := 0; bri <= 254; bri++ {
+			state := model.DeviceState{On: true, UpdatedByOn: true, Bri: uint8(bri), UpdatedByBri: true}
+			preview.Sweep = append(preview.Sweep, model.BriCommand{Bri: uint8(bri), Command: translateToHA(t, &state, vd)})
+		}
+	}
+	return preview, nil
+}
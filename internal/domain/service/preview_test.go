@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeService_PreviewTranslation(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockTF := new(MockTranslatorFactory)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(briTranslator{})
+	s := NewBridgeService(mockHA, new(MockConfigRepo), mockTF)
+	ctx := context.Background()
+	vd := &model.VirtualDevice{
+		EntityID:     "light.desk",
+		Type:         model.MappingTypeLight,
+		ActionConfig: &model.ActionConfig{BriBands: []model.BriBand{{MaxBri: 10, Service: "script.night"}}},
+	}
+
+	preview, err := s.PreviewTranslation(ctx, vd, &model.HAEntityState{State: "on", Attributes: model.HAFields{"brightness": 80}}, nil, false)
+	require.NoError(t, err)
+	assert.True(t, preview.State.On)
+	assert.True(t, preview.State.Reachable)
+	assert.Equal(t, uint8(80), preview.State.Bri)
+	assert.Nil(t, preview.Command)
+
+	preview, err = s.PreviewTranslation(ctx, vd, nil, &model.DeviceState{On: true, Bri: 5, UpdatedByBri: true}, false)
+	require.NoError(t, err)
+	assert.Nil(t, preview.State)
+	assert.Equal(t, "script.night", preview.Command.Service)
+
+	preview, err = s.PreviewTranslation(ctx, vd, nil, nil, true)
+	require.NoError(t, err)
+	require.Len(t, preview.Sweep, 255)
+	assert.Equal(t, "script.night", preview.Sweep[10].Command.Service)
+	assert.Equal(t, uint8(254), preview.Sweep[254].Bri)
+	assert.Equal(t, model.HAFields{"brightness": 254}, preview.Sweep[254].Command.Data)
+
+	// Nothing is sent to HA
+	mockHA.AssertNotCalled(t, "SetState")
+
+	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(validatingTranslator{})
+	_, err = s.PreviewTranslation(ctx, &model.VirtualDevice{Name: "Pool", Type: model.MappingTypeCustom}, nil, nil, true)
+	assert.ErrorIs(t, err, model.ErrInvalidConfig)
+}
+
+// learningTranslator remembers the last state it translated
+type learningTranslator struct {
+	briTranslator
+	learnt *string
+}
+
+func (tr learningTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	*tr.learnt = haState.State
+	return tr.briTranslator.ToHue(haState, vd)
+}
+
+func (tr learningTranslator) Blank() ports.Translator {
+	return learningTranslator{learnt: new(string)}
+}
+
+func TestBridgeService_PreviewTranslation_NoSideEffects(t *testing.T) {
+	live := learningTranslator{learnt: new(string)}
+	mockTF := new(MockTranslatorFactory)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(live)
+	s := NewBridgeService(new(MockHAPort), new(MockConfigRepo), mockTF)
+
+	vd := &model.VirtualDevice{EntityID: "light.desk", Type: model.MappingTypeLight}
+	preview, err := s.PreviewTranslation(context.Background(), vd, &model.HAEntityState{State: "on"}, nil, false)
+	require.NoError(t, err)
+	assert.True(t, preview.State.On)
+	assert.Empty(t, *live.learnt)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
+)
+
+// deviceQueue serializes the HA calls of one device. At most one command waits behind the one in
+// flight: a newer command replaces it, so only the latest state is sent once the device is free.
+type deviceQueue struct {
+	pending *queuedCommand
+	running bool
+}
+
+type queuedCommand struct {
+	device *model.Device
+	state  model.DeviceState
+	// seq is the optimistic change settled by the command
+	seq uint64
+}
+
+// enqueueCommand schedules state for device, coalescing it with a command still waiting
+func (s *BridgeService) enqueueCommand(device *model.Device, state model.DeviceState, seq uint64) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	q, ok := s.queues[device.ID]
+	if !ok {
+		q = &deviceQueue{}
+		s.queues[device.ID] = q
+	}
+	if q.pending != nil {
+		state = coalesceState(q.pending.state, state)
+		slog.Debug("Bridge: coalesced superseded command", "hue_id", device.ID)
+	}
+	q.pending = &queuedCommand{device: device, state: state, seq: seq}
+
+	if !q.running {
+		q.running = true
+		go s.drainQueue(device.ID, q)
+	}
+}
+
+// drainQueue sends the commands of a device one at a time. Commands wait for a free HA slot
+// instead of being rejected, coalescing keeps the wait bounded to one command per device.
+func (s *BridgeService) drainQueue(id string, q *deviceQueue) {
+	for {
+		s.queueMu.Lock()
+		c := q.pending
+		if c == nil {
+			q.running = false
+			delete(s.queues, id)
+			s.queueMu.Unlock()
+			return
+		}
+		q.pending = nil
+		s.queueMu.Unlock()
+
+		s.workerSem <- struct{}{}
+		s.sendCommand(c)
+		<-s.workerSem
+	}
+}
+
+func (s *BridgeService) sendCommand(c *queuedCommand) {
+	err := s.dispatch(c.device, c.state)
+	s.settleOptimistic(c.device.ID, c.seq, err)
+	if err != nil {
+		slog.Error("Error setting HA state", "hue_id", c.device.ID, "error", err)
+	}
+}
+
+// coalesceState combines a superseded command with the next one. The next state already carries
+// the superseded values through the optimistic update, only the one-shot intents must be kept.
+func coalesceState(superseded, next model.DeviceState) model.DeviceState {
+	merged := next
+	// A new colour replaces the superseded one, otherwise the superseded colour is still sent
+	if !next.UpdatedByColor() {
+		merged.UpdatedByHue = superseded.UpdatedByHue
+		merged.UpdatedBySat = superseded.UpdatedBySat
+		merged.UpdatedByXy = superseded.UpdatedByXy
+		merged.UpdatedByCt = superseded.UpdatedByCt
+	}
+	merged.UpdatedByBri = superseded.UpdatedByBri || next.UpdatedByBri
+	if merged.Alert == "" {
+		merged.Alert = superseded.Alert
+	}
+	if merged.TransitionTime == nil {
+		merged.TransitionTime = superseded.TransitionTime
+	}
+	return merged
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stateTranslator sends the Hue state as is, so tests can see which state reached HA
+type stateTranslator struct{}
+
+func (stateTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	return &model.DeviceState{On: haState.State == "on"}
+}
+
+func (stateTranslator) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	return model.HomeAssistantCommand{Service: "turn_on", Data: model.HAFields{"state": *hueState}}
+}
+
+func (stateTranslator) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{}
+}
+
+// recordingHA records the states sent per entity and fails the test when a device has two
+// commands in flight. release, when set, holds every SetState until it is closed.
+type recordingHA struct {
+	t        *testing.T
+	states   []model.HAEntityState
+	release  chan struct{}
+	delay    func() time.Duration
+	mu       sync.Mutex
+	sent     map[string][]model.DeviceState
+	inFlight map[string]bool
+}
+
+func (h *recordingHA) GetRawStates(ctx context.Context) ([]model.HAEntityState, error) {
+	return h.states, nil
+}
+
+func (h *recordingHA) SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) (int, error) {
+	h.mu.Lock()
+	if h.inFlight[device.ExternalID] {
+		h.t.Errorf("concurrent commands for %s", device.ExternalID)
+	}
+	h.inFlight[device.ExternalID] = true
+	h.mu.Unlock()
+
+	if h.release != nil {
+		<-h.release
+	}
+	if h.delay != nil {
+		time.Sleep(h.delay())
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inFlight[device.ExternalID] = false
+	h.sent[device.ExternalID] = append(h.sent[device.ExternalID], cmd.Data["state"].(model.DeviceState))
+	return 200, nil
+}
+
+func (h *recordingHA) Available() bool { return true }
+
+func (h *recordingHA) Configure(url, token string) {}
+
+func (h *recordingHA) sentStates(entityID string) []model.DeviceState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]model.DeviceState(nil), h.sent[entityID]...)
+}
+
+func newQueueTestService(t *testing.T, devices int) (*BridgeService, *recordingHA) {
+	t.Helper()
+	ha := &recordingHA{t: t, sent: map[string][]model.DeviceState{}, inFlight: map[string]bool{}}
+	cfg := &model.Config{}
+	for i := 1; i <= devices; i++ {
+		entityID := fmt.Sprintf("light.l%d", i)
+		cfg.VirtualDevices = append(cfg.VirtualDevices, &model.VirtualDevice{HueID: fmt.Sprint(i), EntityID: entityID, Type: model.MappingTypeLight})
+		ha.states = append(ha.states, model.HAEntityState{EntityID: entityID, State: "off"})
+	}
+
+	mockRepo := new(MockConfigRepo)
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockTF := new(MockTranslatorFactory)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+
+	s := NewBridgeService(ha, mockRepo, mockTF)
+	_, err := s.GetDevices(context.Background())
+	require.NoError(t, err)
+	return s, ha
+}
+
+func briUpdate(bri uint8) *model.DeviceState {
+	return &model.DeviceState{On: true, Bri: bri, UpdatedByBri: true}
+}
+
+func TestBridgeService_CommandQueue_Coalesces(t *testing.T) {
+	s, ha := newQueueTestService(t, 1)
+	ha.release = make(chan struct{})
+	ctx := context.Background()
+
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(50)))
+	// Wait for the first command to be in flight
+	require.Eventually(t, func() bool {
+		ha.mu.Lock()
+		defer ha.mu.Unlock()
+		return ha.inFlight["light.l1"]
+	}, time.Second, time.Millisecond)
+
+	// Superseded while the first one is in flight
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, Hue: 1000, UpdatedByHue: true}))
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(120)))
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, Alert: "select"}))
+	close(ha.release)
+
+	require.Eventually(t, func() bool { return len(ha.sentStates("light.l1")) == 2 }, time.Second, time.Millisecond)
+	sent := ha.sentStates("light.l1")
+	assert.Equal(t, uint8(50), sent[0].Bri)
+
+	// Only the latest state is sent, carrying the intents of the commands it replaced
+	last := sent[1]
+	assert.Equal(t, uint8(120), last.Bri)
+	assert.True(t, last.UpdatedByBri)
+	assert.Equal(t, uint16(1000), last.Hue)
+	assert.True(t, last.UpdatedByHue)
+	assert.Equal(t, "select", last.Alert)
+
+	// The queue of an idle device is released
+	require.Eventually(t, func() bool {
+		s.queueMu.Lock()
+		defer s.queueMu.Unlock()
+		return len(s.queues) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestBridgeService_CommandQueue_OrderingUnderConcurrency(t *testing.T) {
+	const devices, updates = 8, 50
+	s, ha := newQueueTestService(t, devices)
+	ha.delay = func() time.Duration { return time.Duration(rand.IntN(500)) * time.Microsecond }
+	ctx := context.Background()
+
+	// Each device gets an increasing brightness sequence, all devices at once
+	var wg sync.WaitGroup
+	for d := 1; d <= devices; d++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bri := 1; bri <= updates; bri++ {
+				assert.NoError(t, s.UpdateDeviceState(ctx, fmt.Sprint(d), briUpdate(uint8(bri))))
+				if rand.IntN(4) == 0 {
+					time.Sleep(time.Duration(rand.IntN(300)) * time.Microsecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for d := 1; d <= devices; d++ {
+		entityID := fmt.Sprintf("light.l%d", d)
+		require.Eventually(t, func() bool {
+			sent := ha.sentStates(entityID)
+			return len(sent) > 0 && sent[len(sent)-1].Bri == updates
+		}, 2*time.Second, time.Millisecond, entityID)
+
+		// Never out of order: an older brightness never reaches HA after a newer one
+		sent := ha.sentStates(entityID)
+		for i := 1; i < len(sent); i++ {
+			assert.Greater(t, sent[i].Bri, sent[i-1].Bri, "%s command %d", entityID, i)
+		}
+		assert.LessOrEqual(t, len(sent), updates)
+	}
+}
+
+func TestCoalesceState(t *testing.T) {
+	transition := uint16(4)
+	tests := []struct {
+		name       string
+		superseded model.DeviceState
+		next       model.DeviceState
+		check      func(t *testing.T, got model.DeviceState)
+	}{
+		{
+			"colour kept when the next command has none",
+			model.DeviceState{On: true, Xy: []float32{0.3, 0.3}, UpdatedByXy: true, TransitionTime: &transition},
+			model.DeviceState{On: true, Xy: []float32{0.3, 0.3}, Bri: 10, UpdatedByBri: true},
+			func(t *testing.T, got model.DeviceState) {
+				assert.True(t, got.UpdatedByXy)
+				assert.True(t, got.UpdatedByBri)
+				assert.Equal(t, &transition, got.TransitionTime)
+			},
+		},
+		{
+			"new colour replaces the superseded one",
+			model.DeviceState{On: true, UpdatedByXy: true, Alert: "lselect"},
+			model.DeviceState{On: true, Ct: 300, UpdatedByCt: true, Alert: "select"},
+			func(t *testing.T, got model.DeviceState) {
+				assert.False(t, got.UpdatedByXy)
+				assert.True(t, got.UpdatedByCt)
+				assert.Equal(t, "select", got.Alert)
+			},
+		},
+		{
+			"turning off wins",
+			model.DeviceState{On: true, Bri: 200, UpdatedByBri: true},
+			model.DeviceState{On: false, Bri: 200},
+			func(t *testing.T, got model.DeviceState) {
+				assert.False(t, got.On)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, coalesceState(tt.superseded, tt.next))
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const DefaultConvergenceWindow = 10 * time.Second
+
+// Differences tolerated between the requested state and the state HA reports, conversions between
+// Hue and HA units round
+const (
+	briTolerance = 3
+	hueTolerance = 656 // 1% of the hue circle
+	satTolerance = 3
+	ctTolerance  = 5
+	xyTolerance  = 0.01
+)
+
+// optimisticChange is a state reported to Hue clients before HA confirmed it
+type optimisticChange struct {
+	seq      uint64
+	expected model.DeviceState
+	// intent carries the Updated* flags of the requested changes, only those fields are compared
+	intent model.DeviceState
+	// reported is the latest state translated from HA, restored on rollback
+	reported model.DeviceState
+	timer    *time.Timer
+}
+
+// SetConvergenceWindow sets how long HA has to report a requested state before it is rolled back
+func (s *BridgeService) SetConvergenceWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.convergenceWindow = window
+}
+
+// trackOptimisticLocked records the optimistic state of a device, confirmed is the state it replaces.
+// Must be called with the write lock.
+func (s *BridgeService) trackOptimisticLocked(id string, confirmed, expected, intent model.DeviceState) uint64 {
+	s.optimisticSeq++
+	p, ok := s.optimistic[id]
+	if !ok {
+		p = &optimisticChange{reported: confirmed}
+		s.optimistic[id] = p
+	} else {
+		// The new request settles the change, a previous window no longer applies
+		p.stopTimer()
+		intent = coalesceState(p.intent, intent)
+	}
+	p.seq = s.optimisticSeq
+	p.expected = expected
+	p.intent = intent
+	return p.seq
+}
+
+// settleOptimistic accounts for the outcome of the command of change seq. A failed command is
+// rolled back at once, a sent one has the convergence window to be reported by HA.
+func (s *BridgeService) settleOptimistic(id string, seq uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.optimistic[id]
+	if !ok || p.seq != seq {
+		// Already confirmed by HA, or superseded by a newer request that settles it
+		return
+	}
+	if err != nil {
+		slog.Warn("Bridge: rolling back optimistic state, command failed", "hue_id", id, "error", err)
+		s.rollbackLocked(id, p)
+		return
+	}
+	if d, ok := s.devices[id]; ok {
+		if m, ok := s.translatorFactory.GetTranslator(d.Type).(ports.MomentaryTranslator); ok {
+			// Stateless entities never report the trigger, the device is reported off again after the delay
+			p.timer = time.AfterFunc(m.ResetDelay(d.VirtualDevice), func() { s.resetMomentary(id, seq) })
+			return
+		}
+	}
+	p.timer = time.AfterFunc(s.convergenceWindow, func() { s.expireOptimistic(id, seq) })
+}
+
+// resetMomentary restores the state reported by HA once a triggered device can be triggered again
+func (s *BridgeService) resetMomentary(id string, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.optimistic[id]
+	if !ok || p.seq != seq {
+		return
+	}
+	s.rollbackLocked(id, p)
+}
+
+// expireOptimistic rolls back change seq when HA did not report it within the window. Without
+// pushed state changes HA is only polled every RefreshInterval, so its states are read first.
+func (s *BridgeService) expireOptimistic(id string, seq uint64) {
+	s.mu.Lock()
+	if p, ok := s.optimistic[id]; !ok || p.seq != seq {
+		s.mu.Unlock()
+		return
+	}
+	s.lastRefresh = time.Now().Add(-5 * time.Second) // Ensure we can refresh
+	s.mu.Unlock()
+	if err := s.RefreshDevices(context.Background()); err != nil {
+		slog.Warn("Bridge: could not read HA states before judging convergence", "hue_id", id, "error", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.optimistic[id]
+	if !ok || p.seq != seq {
+		// Confirmed by the refresh, or superseded meanwhile
+		return
+	}
+	d, ok := s.devices[id]
+	if ok {
+		slog.Warn("Bridge: HA state did not converge, rolling back", "hue_id", id, "entity_id", d.ExternalID,
+			"expected", describeState(p.expected), "reported", describeState(p.reported))
+		s.countMismatchLocked(d, p)
+	}
+	s.rollbackLocked(id, p)
+}
+
+// rollbackLocked restores the state reported by HA, must be called with the write lock
+func (s *BridgeService) rollbackLocked(id string, p *optimisticChange) {
+	p.stopTimer()
+	delete(s.optimistic, id)
+	if d, ok := s.devices[id]; ok {
+		reported := p.reported
+		d.State = &reported
+	}
+}
+
+// reconcileLocked returns the state to report for a device given the state HA reported: the
+// optimistic state while a change is pending, the reported one once it converged.
+// Must be called with the write lock.
+func (s *BridgeService) reconcileLocked(id string, reported *model.DeviceState) *model.DeviceState {
+	p, ok := s.optimistic[id]
+	if !ok {
+		return reported
+	}
+	p.reported = *reported
+	if converged(p.expected, *reported, p.intent) {
+		p.stopTimer()
+		delete(s.optimistic, id)
+		return reported
+	}
+	expected := p.expected
+	return &expected
+}
+
+func (s *BridgeService) countMismatchLocked(d *model.Device, p *optimisticChange) {
+	m, ok := s.mismatches[d.ExternalID]
+	if !ok {
+		m = &model.StateMismatch{EntityID: d.ExternalID}
+		s.mismatches[d.ExternalID] = m
+	}
+	m.DeviceID = d.ID
+	m.DeviceName = d.Name
+	m.Count++
+	m.Last = time.Now()
+	m.Expected = p.expected
+	m.Reported = p.reported
+}
+
+// GetStateMismatches returns the entities whose reported state did not converge, most frequent first
+func (s *BridgeService) GetStateMismatches(ctx context.Context) []model.StateMismatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]model.StateMismatch, 0, len(s.mismatches))
+	for _, m := range s.mismatches {
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].EntityID < res[j].EntityID
+	})
+	return res
+}
+
+func (p *optimisticChange) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// converged reports whether HA reports the requested fields of expected, within rounding
+func converged(expected, reported, intent model.DeviceState) bool {
+	if expected.On != reported.On {
+		return false
+	}
+	if !expected.On {
+		return true
+	}
+	if intent.UpdatedByBri && absDiff(int(expected.Bri), int(reported.Bri)) > briTolerance {
+		return false
+	}
+	// The hue wraps around
+	if d := absDiff(int(expected.Hue), int(reported.Hue)); intent.UpdatedByHue && min(d, 65536-d) > hueTolerance {
+		return false
+	}
+	if intent.UpdatedBySat && absDiff(int(expected.Sat), int(reported.Sat)) > satTolerance {
+		return false
+	}
+	if intent.UpdatedByCt && absDiff(int(expected.Ct), int(reported.Ct)) > ctTolerance {
+		return false
+	}
+	if intent.UpdatedByXy {
+		if len(expected.Xy) != 2 || len(reported.Xy) != 2 {
+			return false
+		}
+		for i := range expected.Xy {
+			if d := expected.Xy[i] - reported.Xy[i]; d > xyTolerance || d < -xyTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// describeState summarizes the fields of a state compared for convergence
+func describeState(st model.DeviceState) map[string]any {
+	return map[string]any{"on": st.On, "bri": st.Bri, "hue": st.Hue, "sat": st.Sat, "ct": st.Ct, "xy": st.Xy}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// briTranslator reports the on/off state and the brightness attribute of an entity
+type briTranslator struct{}
+
+func (briTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	bri, _ := haState.Attributes["brightness"].(int)
+	return &model.DeviceState{On: haState.State == "on", Bri: uint8(bri)}
+}
+
+func (briTranslator) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	return model.HomeAssistantCommand{Service: "turn_on", Data: model.HAFields{"brightness": int(hueState.Bri)}}
+}
+
+func (briTranslator) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{}
+}
+
+func briState(bri int) model.HAEntityState {
+	return model.HAEntityState{EntityID: "light.desk", State: "on", Attributes: model.HAFields{"brightness": bri}}
+}
+
+// newReconcileTestService returns a service with the desk light at brightness 100, SetState answers
+// err and signals sent
+func newReconcileTestService(t *testing.T, err error) (*BridgeService, *MockHAPort, chan struct{}) {
+	t.Helper()
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{HueID: "1", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+	}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{briState(100)}, nil).Once()
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(briTranslator{})
+	sent := make(chan struct{}, 10)
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(0, err).Run(func(mock.Arguments) { sent <- struct{}{} })
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	_, loadErr := s.GetDevices(context.Background())
+	require.NoError(t, loadErr)
+	return s, mockHA, sent
+}
+
+func deskBri(t *testing.T, s *BridgeService) uint8 {
+	t.Helper()
+	d, err := s.GetDevice(context.Background(), "1")
+	require.NoError(t, err)
+	return d.State.Bri
+}
+
+func pendingChanges(s *BridgeService) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.optimistic)
+}
+
+func TestBridgeService_Optimistic_RollbackOnFailure(t *testing.T) {
+	s, _, sent := newReconcileTestService(t, fmt.Errorf("HA API error: 500"))
+
+	require.NoError(t, s.UpdateDeviceState(context.Background(), "1", briUpdate(50)))
+	assert.Equal(t, uint8(50), deskBri(t, s))
+	<-sent
+
+	require.Eventually(t, func() bool { return deskBri(t, s) == 100 }, time.Second, time.Millisecond)
+	assert.Zero(t, pendingChanges(s))
+	// A failed command is not a mismatch, it is in the command history
+	assert.Empty(t, s.GetStateMismatches(context.Background()))
+}
+
+func TestBridgeService_Optimistic_Converges(t *testing.T) {
+	s, _, sent := newReconcileTestService(t, nil)
+	ctx := context.Background()
+
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(50)))
+	<-sent
+
+	// An intermediate state, e.g. during a transition, does not replace the requested one
+	s.ApplyStateChange(ctx, briState(80))
+	assert.Equal(t, uint8(50), deskBri(t, s))
+
+	// Rounding is tolerated
+	s.ApplyStateChange(ctx, briState(52))
+	assert.Equal(t, uint8(52), deskBri(t, s))
+	assert.Zero(t, pendingChanges(s))
+
+	// Converged before the command settles
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(200)))
+	s.ApplyStateChange(ctx, briState(200))
+	<-sent
+	assert.Never(t, func() bool { return pendingChanges(s) != 0 }, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestBridgeService_Optimistic_RollbackWithoutConvergence(t *testing.T) {
+	s, mockHA, sent := newReconcileTestService(t, nil)
+	s.SetConvergenceWindow(30 * time.Millisecond)
+	ctx := context.Background()
+
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(50)))
+	<-sent
+
+	// A full refresh keeps the requested state while it is pending
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{briState(100)}, nil).Once()
+	s.mu.Lock()
+	s.lastRefresh = time.Time{}
+	s.mu.Unlock()
+	require.NoError(t, s.RefreshDevices(ctx))
+	assert.Equal(t, uint8(50), deskBri(t, s))
+
+	// The translator does not round-trip, HA keeps reporting another brightness
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{briState(180)}, nil)
+	s.ApplyStateChange(ctx, briState(180))
+	require.Eventually(t, func() bool { return deskBri(t, s) == 180 }, time.Second, time.Millisecond)
+	assert.Zero(t, pendingChanges(s))
+
+	mismatches := s.GetStateMismatches(ctx)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, "light.desk", mismatches[0].EntityID)
+	assert.Equal(t, "Desk", mismatches[0].DeviceName)
+	assert.Equal(t, 1, mismatches[0].Count)
+	assert.Equal(t, uint8(50), mismatches[0].Expected.Bri)
+	assert.Equal(t, uint8(180), mismatches[0].Reported.Bri)
+
+	// Mismatches are counted per entity
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(60)))
+	<-sent
+	require.Eventually(t, func() bool {
+		m := s.GetStateMismatches(ctx)
+		return len(m) == 1 && m[0].Count == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestBridgeService_Optimistic_ConvergesThroughPolling(t *testing.T) {
+	s, mockHA, sent := newReconcileTestService(t, nil)
+	s.SetConvergenceWindow(30 * time.Millisecond)
+	ctx := context.Background()
+
+	// HA pushes nothing, it only reports the new brightness when polled
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{briState(50)}, nil)
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", briUpdate(50)))
+	<-sent
+
+	require.Eventually(t, func() bool { return pendingChanges(s) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint8(50), deskBri(t, s))
+	assert.Empty(t, s.GetStateMismatches(ctx))
+}
+
+func TestBridgeService_Optimistic_Superseded(t *testing.T) {
+	s, mockHA, _ := newReconcileTestService(t, nil)
+
+	s.mu.Lock()
+	first := s.trackOptimisticLocked("1", model.DeviceState{On: true, Bri: 100}, model.DeviceState{On: true, Bri: 50}, *briUpdate(50))
+	second := s.trackOptimisticLocked("1", model.DeviceState{On: true, Bri: 50}, model.DeviceState{On: false, Bri: 50}, model.DeviceState{})
+	s.mu.Unlock()
+
+	// The newer request settles the change, the outcome of the superseded command is ignored
+	s.settleOptimistic("1", first, fmt.Errorf("failed"))
+	s.expireOptimistic("1", first)
+	assert.Equal(t, 1, pendingChanges(s))
+
+	// Intents are combined and the state confirmed before the first request is kept
+	s.mu.RLock()
+	p := s.optimistic["1"]
+	assert.True(t, p.intent.UpdatedByBri)
+	assert.Equal(t, uint8(100), p.reported.Bri)
+	s.mu.RUnlock()
+
+	// A change of a device removed in the meantime is dropped, even when HA cannot be polled
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState(nil), fmt.Errorf("api error"))
+	s.mu.Lock()
+	delete(s.devices, "1")
+	s.mu.Unlock()
+	s.expireOptimistic("1", second)
+	assert.Zero(t, pendingChanges(s))
+	assert.Empty(t, s.GetStateMismatches(context.Background()))
+}
+
+// momentaryTranslator is always off, triggered for delay and ignores off
+type momentaryTranslator struct {
+	stateTranslator
+	delay time.Duration
+}
+
+func (momentaryTranslator) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	return &model.DeviceState{}
+}
+
+func (momentaryTranslator) Ignores(hueState *model.DeviceState, vd *model.VirtualDevice) bool {
+	return !hueState.On
+}
+
+func (tr momentaryTranslator) ResetDelay(vd *model.VirtualDevice) time.Duration {
+	return tr.delay
+}
+
+func TestBridgeService_Momentary(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{HueID: "1", Name: "Movie", EntityID: "scene.movie", Type: model.MappingTypeTrigger},
+	}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{{EntityID: "scene.movie", State: "unknown"}}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeTrigger).Return(momentaryTranslator{delay: 50 * time.Millisecond})
+	sent := make(chan struct{}, 10)
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil).Run(func(mock.Arguments) { sent <- struct{}{} })
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	s.SetConvergenceWindow(10 * time.Millisecond)
+	ctx := context.Background()
+	_, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+	isOn := func() bool {
+		d, err := s.GetDevice(ctx, "1")
+		require.NoError(t, err)
+		return d.State.On
+	}
+
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, UpdatedByOn: true}))
+	<-sent
+	assert.True(t, isOn())
+
+	// The activation HA reports does not end the trigger, neither does the convergence window
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "scene.movie", State: "2026-10-16T21:00:00+00:00"})
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, isOn())
+
+	require.Eventually(t, func() bool { return !isOn() }, time.Second, time.Millisecond)
+	assert.Zero(t, pendingChanges(s))
+	assert.Empty(t, s.GetStateMismatches(ctx))
+
+	// Ignored commands are not sent
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: false, UpdatedByOn: true}))
+	assert.Never(t, func() bool { return len(sent) > 0 }, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestBridgeService_GetStateMismatches_Order(t *testing.T) {
+	s := NewBridgeService(new(MockHAPort), new(MockConfigRepo), new(MockTranslatorFactory))
+	s.mismatches["light.b"] = &model.StateMismatch{EntityID: "light.b", Count: 1}
+	s.mismatches["light.a"] = &model.StateMismatch{EntityID: "light.a", Count: 1}
+	s.mismatches["light.c"] = &model.StateMismatch{EntityID: "light.c", Count: 4}
+
+	var got []string
+	for _, m := range s.GetStateMismatches(context.Background()) {
+		got = append(got, m.EntityID)
+	}
+	assert.Equal(t, []string{"light.c", "light.a", "light.b"}, got)
+}
+
+func TestConverged(t *testing.T) {
+	on := func(st model.DeviceState) model.DeviceState { st.On = true; return st }
+	tests := []struct {
+		name     string
+		expected model.DeviceState
+		reported model.DeviceState
+		intent   model.DeviceState
+		want     bool
+	}{
+		{"on differs", model.DeviceState{On: true}, model.DeviceState{}, model.DeviceState{}, false},
+		{"off ignores the rest", model.DeviceState{Bri: 10}, model.DeviceState{Bri: 200}, model.DeviceState{UpdatedByBri: true}, true},
+		{"unrequested field", on(model.DeviceState{Bri: 10}), on(model.DeviceState{Bri: 200}), model.DeviceState{}, true},
+		{"bri", on(model.DeviceState{Bri: 10}), on(model.DeviceState{Bri: 20}), model.DeviceState{UpdatedByBri: true}, false},
+		{"hue wraps around", on(model.DeviceState{Hue: 65500}), on(model.DeviceState{Hue: 100}), model.DeviceState{UpdatedByHue: true}, true},
+		{"hue", on(model.DeviceState{Hue: 10000}), on(model.DeviceState{Hue: 12000}), model.DeviceState{UpdatedByHue: true}, false},
+		{"sat", on(model.DeviceState{Sat: 100}), on(model.DeviceState{Sat: 110}), model.DeviceState{UpdatedBySat: true}, false},
+		{"ct", on(model.DeviceState{Ct: 300}), on(model.DeviceState{Ct: 304}), model.DeviceState{UpdatedByCt: true}, true},
+		{"ct off", on(model.DeviceState{Ct: 300}), on(model.DeviceState{Ct: 350}), model.DeviceState{UpdatedByCt: true}, false},
+		{"xy", on(model.DeviceState{Xy: []float32{0.3, 0.3}}), on(model.DeviceState{Xy: []float32{0.305, 0.295}}), model.DeviceState{UpdatedByXy: true}, true},
+		{"xy off", on(model.DeviceState{Xy: []float32{0.3, 0.3}}), on(model.DeviceState{Xy: []float32{0.3, 0.4}}), model.DeviceState{UpdatedByXy: true}, false},
+		{"xy not reported", on(model.DeviceState{Xy: []float32{0.3, 0.3}}), on(model.DeviceState{}), model.DeviceState{UpdatedByXy: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, converged(tt.expected, tt.reported, tt.intent))
+		})
+	}
+}
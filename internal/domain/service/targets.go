@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// deviceState translates the HA states of every entity of a device into the state reported to
+// Hue clients, state returns the HA state of an entity
+func (s *BridgeService) deviceState(vd *model.VirtualDevice, state func(entityID string) model.HAEntityState) *model.DeviceState {
+	leader := s.toHue(state(vd.StateEntity()).WithStateFromAttribute(vd.StateAttribute), vd)
+	if len(vd.Targets) == 0 {
+		return leader
+	}
+	states := []*model.DeviceState{leader}
+	for _, t := range vd.Targets {
+		states = append(states, s.toHue(state(t.EntityID), vd.TargetDevice(t)))
+	}
+	return aggregateState(vd.StatePolicy, states)
+}
+
+// aggregateState combines the states of the entities of a device, the leader first. The
+// brightness and colour are those of the leader, or of the first entity that is on when the
+// leader is off.
+func aggregateState(policy model.StatePolicy, states []*model.DeviceState) *model.DeviceState {
+	leader := states[0]
+	if policy == model.StatePolicyLeader {
+		return leader
+	}
+
+	res := *leader
+	anyOn, allOn := false, true
+	anyReachable, allReachable := false, true
+	for _, st := range states {
+		if st.On && !anyOn && !leader.On {
+			res = *st
+		}
+		anyOn = anyOn || st.On
+		allOn = allOn && st.On
+		anyReachable = anyReachable || st.Reachable
+		allReachable = allReachable && st.Reachable
+	}
+	if policy == model.StatePolicyAll {
+		res.On, res.Reachable = allOn, allReachable
+	} else {
+		res.On, res.Reachable = anyOn, anyReachable
+	}
+	return &res
+}
+
+// commandedDevices returns the single-entity virtual devices the commands of vd are
+// translated for, its main entity first
+func commandedDevices(vd *model.VirtualDevice) []*model.VirtualDevice {
+	res := []*model.VirtualDevice{vd}
+	for _, t := range vd.Targets {
+		res = append(res, vd.TargetDevice(t))
+	}
+	return res
+}
+
+// ignores reports whether the momentary translator of vd has no command for state
+func (s *BridgeService) ignores(state *model.DeviceState, vd *model.VirtualDevice) bool {
+	m, ok := s.translatorFactory.GetTranslator(vd.Type).(ports.MomentaryTranslator)
+	return ok && m.Ignores(state, vd)
+}
+
+// dispatch sends state to every entity of device, in parallel, and returns the failures.
+// Targets whose momentary translator ignores state are skipped.
+func (s *BridgeService) dispatch(device *model.Device, state model.DeviceState) error {
+	vd := device.VirtualDevice
+	if vd == nil || len(vd.Targets) == 0 {
+		return s.send(device, state, nil)
+	}
+
+	errs := make([]error, len(vd.Targets)+1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[0] = s.send(device, state, nil)
+	}()
+	for i, t := range vd.Targets {
+		target := *device
+		target.VirtualDevice = vd.TargetDevice(t)
+		target.Type = target.VirtualDevice.Type
+		target.ExternalID = t.EntityID
+		if s.ignores(&state, target.VirtualDevice) {
+			slog.Debug("Bridge: command ignored", "hue_id", device.ID, "entity_id", t.EntityID, "on", state.On)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i+1] = s.send(&target, state, t.Payload)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// send translates state for the entity of device, adds payload and sends it to HA
+func (s *BridgeService) send(device *model.Device, state model.DeviceState, payload map[string]interface{}) error {
+	cmd := s.toHA(&state, device.VirtualDevice)
+	if len(payload) > 0 && cmd.Data == nil {
+		cmd.Data = make(model.HAFields)
+	}
+	for k, v := range payload {
+		cmd.Data[k] = v
+	}
+	start := time.Now()
+	status, err := s.haPort.SetState(context.Background(), device, cmd)
+	s.recordCommand(device, cmd, start, status, err)
+	return err
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateState(t *testing.T) {
+	leaderOff := &model.DeviceState{On: false, Bri: 10, Reachable: true}
+	onBri := &model.DeviceState{On: true, Bri: 200, Reachable: true}
+	unreachable := &model.DeviceState{On: false, Reachable: false}
+
+	st := aggregateState("", []*model.DeviceState{leaderOff, onBri, unreachable})
+	assert.True(t, st.On)
+	assert.True(t, st.Reachable)
+	// The brightness is that of an entity that is on
+	assert.Equal(t, uint8(200), st.Bri)
+
+	st = aggregateState(model.StatePolicyAll, []*model.DeviceState{onBri, onBri, unreachable})
+	assert.False(t, st.On)
+	assert.False(t, st.Reachable)
+	st = aggregateState(model.StatePolicyAll, []*model.DeviceState{onBri, onBri})
+	assert.True(t, st.On)
+
+	st = aggregateState(model.StatePolicyLeader, []*model.DeviceState{leaderOff, onBri})
+	assert.False(t, st.On)
+	assert.Equal(t, uint8(10), st.Bri)
+}
+
+func TestBridgeService_MultiEntityDevice(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{{
+		HueID: "1", Name: "Bedside", EntityID: "light.left", Type: model.MappingTypeLight,
+		Targets: []*model.DeviceTarget{
+			{EntityID: "light.right"},
+			{EntityID: "switch.lamp", Type: model.MappingTypeCustom, Payload: map[string]interface{}{"extra": 1}},
+		},
+	}}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.left", State: "off"},
+		{EntityID: "light.right", State: "off"},
+		{EntityID: "switch.lamp", State: "off"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+	mockTF.On("GetTranslator", model.MappingTypeCustom).Return(stateTranslator{})
+
+	var mu sync.Mutex
+	sent := make(map[string]model.HomeAssistantCommand)
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[args.Get(1).(*model.Device).ExternalID] = args.Get(2).(model.HomeAssistantCommand)
+	})
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	_, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+	d, err := s.GetDevice(ctx, "1")
+	require.NoError(t, err)
+	assert.False(t, d.State.On)
+
+	// A change of any entity updates the device
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "light.right", State: "on"})
+	d, _ = s.GetDevice(ctx, "1")
+	assert.True(t, d.State.On)
+
+	// One command reaches every entity, with its payload
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: false, UpdatedByOn: true}))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 3
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 1, sent["switch.lamp"].Data["extra"])
+	assert.NotContains(t, sent["light.right"].Data, "extra")
+	mu.Unlock()
+
+	records := s.GetCommands(ctx, model.CommandFilter{Device: "1"})
+	assert.Len(t, records, 3)
+}
+
+func TestBridgeService_MultiEntityDevice_PartialFailure(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{{
+		HueID: "1", Name: "Bedside", EntityID: "light.left", Type: model.MappingTypeLight,
+		Targets: []*model.DeviceTarget{{EntityID: "light.right"}},
+	}}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.left", State: "off"},
+		{EntityID: "light.right", State: "off"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+	mockHA.On("SetState", mock.Anything, mock.MatchedBy(func(d *model.Device) bool { return d.ExternalID == "light.left" }), mock.Anything).Return(200, nil)
+	mockHA.On("SetState", mock.Anything, mock.MatchedBy(func(d *model.Device) bool { return d.ExternalID == "light.right" }), mock.Anything).Return(0, fmt.Errorf("HA API error: 500"))
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	_, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+
+	// A failed target rolls the optimistic state back
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, UpdatedByOn: true}))
+	require.Eventually(t, func() bool {
+		d, _ := s.GetDevice(ctx, "1")
+		return !d.State.On && pendingChanges(s) == 0
+	}, time.Second, time.Millisecond)
+	assert.Len(t, s.GetCommands(ctx, model.CommandFilter{Status: model.CommandFailed}), 1)
+}
+
+func TestBridgeService_StateEntity(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{
+		{HueID: "1", Name: "Gate", EntityID: "script.open_gate", Type: model.MappingTypeLight, StateEntityID: "binary_sensor.gate"},
+		{HueID: "2", Name: "Garage", EntityID: "script.garage", Type: model.MappingTypeLight, StateEntityID: "sensor.garage", StateAttribute: "door.open"},
+	}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "script.open_gate", State: "off"},
+		{EntityID: "binary_sensor.gate", State: "on"},
+		{EntityID: "script.garage", State: "off"},
+		{EntityID: "sensor.garage", State: "ok", Attributes: model.HAFields{"door": map[string]any{"open": false}}},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil)
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	devices, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+	assert.True(t, devices[0].State.On)
+	assert.False(t, devices[1].State.On)
+
+	// Pushed changes of the state entity update the device, those of the action target do not
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "script.open_gate", State: "on"})
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "sensor.garage", State: "ok", Attributes: model.HAFields{"door": map[string]any{"open": true}}})
+	d, _ := s.GetDevice(ctx, "1")
+	assert.True(t, d.State.On)
+	d, _ = s.GetDevice(ctx, "2")
+	assert.True(t, d.State.On)
+	s.ApplyStateChange(ctx, model.HAEntityState{EntityID: "binary_sensor.gate", State: "off"})
+	d, _ = s.GetDevice(ctx, "1")
+	assert.False(t, d.State.On)
+
+	// Commands still target the action entity
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: true, UpdatedByOn: true}))
+	require.Eventually(t, func() bool {
+		return len(s.GetCommands(ctx, model.CommandFilter{Device: "script.open_gate"})) == 1
+	}, time.Second, time.Millisecond)
+}
+
+// guardTranslator refuses to turn off, unless voice unlock is allowed
+type guardTranslator struct {
+	stateTranslator
+}
+
+func (guardTranslator) Rejects(hueState *model.DeviceState, vd *model.VirtualDevice) string {
+	if hueState.On || (vd.ActionConfig != nil && vd.ActionConfig.AllowVoiceUnlock) {
+		return ""
+	}
+	return "voice unlock not allowed"
+}
+
+func TestBridgeService_MultiEntityDevice_TargetChecks(t *testing.T) {
+	mockHA := new(MockHAPort)
+	mockRepo := new(MockConfigRepo)
+	mockTF := new(MockTranslatorFactory)
+	lock := &model.DeviceTarget{EntityID: "lock.door", Type: model.MappingTypeLock}
+	cfg := &model.Config{VirtualDevices: []*model.VirtualDevice{{
+		HueID: "1", Name: "Hall", EntityID: "light.hall", Type: model.MappingTypeLight,
+		Targets: []*model.DeviceTarget{lock, {EntityID: "scene.hall", Type: model.MappingTypeTrigger}},
+	}}}
+	mockRepo.On("Get", mock.Anything).Return(cfg, nil)
+	mockHA.On("GetRawStates", mock.Anything).Return([]model.HAEntityState{
+		{EntityID: "light.hall", State: "on"},
+		{EntityID: "lock.door", State: "on"},
+	}, nil)
+	mockTF.On("GetTranslator", model.MappingTypeLight).Return(stateTranslator{})
+	mockTF.On("GetTranslator", model.MappingTypeLock).Return(guardTranslator{})
+	mockTF.On("GetTranslator", model.MappingTypeTrigger).Return(momentaryTranslator{})
+
+	var mu sync.Mutex
+	var sent []string
+	mockHA.On("SetState", mock.Anything, mock.Anything, mock.Anything).Return(200, nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, args.Get(1).(*model.Device).ExternalID)
+	})
+	sentTo := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+
+	s := NewBridgeService(mockHA, mockRepo, mockTF)
+	ctx := context.Background()
+	_, err := s.GetDevices(ctx)
+	require.NoError(t, err)
+
+	// A guarded target refuses the command for the whole device
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: false, UpdatedByOn: true}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, sentTo())
+	d, _ := s.GetDevice(ctx, "1")
+	assert.True(t, d.State.On)
+
+	// The target's own action config lifts the guard, the momentary target ignores off
+	lock.ActionConfig = &model.ActionConfig{AllowVoiceUnlock: true}
+	require.NoError(t, s.UpdateDeviceState(ctx, "1", &model.DeviceState{On: false, UpdatedByOn: true}))
+	require.Eventually(t, func() bool { return len(sentTo()) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"light.hall", "lock.door"}, sentTo())
+}
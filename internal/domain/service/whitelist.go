@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"hue-bridge-emulator/internal/ports"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// lastUseSaveInterval limits how often a last-use date refresh is written to disk
+const lastUseSaveInterval = time.Minute
+
+// DefaultLinkButtonWindow is how long pairing stays open after the link button is pressed
+const DefaultLinkButtonWindow = 30 * time.Second
+
+type WhitelistService struct {
+	repo             ports.WhitelistPort
+	mu               sync.Mutex
+	now              func() time.Time
+	linkButtonWindow time.Duration
+	linkButtonUntil  time.Time
+}
+
+func NewWhitelistService(repo ports.WhitelistPort) *WhitelistService {
+	return &WhitelistService{repo: repo, now: time.Now, linkButtonWindow: DefaultLinkButtonWindow}
+}
+
+func (s *WhitelistService) SetLinkButtonWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.linkButtonWindow = window
+}
+
+// PressLinkButton opens the pairing window and returns when it closes
+func (s *WhitelistService) PressLinkButton(ctx context.Context) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.linkButtonUntil = s.now().Add(s.linkButtonWindow)
+	slog.Info("Link button pressed, pairing open", "until", s.linkButtonUntil)
+	return s.linkButtonUntil
+}
+
+func (s *WhitelistService) LinkButtonPressed(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now().Before(s.linkButtonUntil)
+}
+
+// Register creates a user with a random 40 character username, like a real bridge does.
+// It only succeeds while the pairing window is open.
+func (s *WhitelistService) Register(ctx context.Context, deviceType string) (*model.HueUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.now().Before(s.linkButtonUntil) {
+		return nil, model.ErrLinkButtonNotPressed
+	}
+
+	users, err := s.repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 20)
+	rand.Read(b)
+	now := s.now().UTC()
+	user := &model.HueUser{
+		Username:    hex.EncodeToString(b),
+		DeviceType:  deviceType,
+		CreateDate:  now,
+		LastUseDate: now,
+	}
+
+	if err := s.repo.Save(ctx, append(copyUsers(users), user)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// EnsureLegacyUser whitelists LegacyHueUsername when no whitelist has been saved yet and
+// paired reports an installation from before the whitelist, so existing clients keep working.
+func (s *WhitelistService) EnsureLegacyUser(ctx context.Context, paired bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !paired || s.repo.Exists() {
+		return nil
+	}
+
+	now := s.now().UTC()
+	user := &model.HueUser{
+		Username:    model.LegacyHueUsername,
+		DeviceType:  "legacy",
+		CreateDate:  now,
+		LastUseDate: now,
+	}
+	if err := s.repo.Save(ctx, []*model.HueUser{user}); err != nil {
+		return err
+	}
+	slog.Info("Whitelisted the legacy Hue username of the existing installation", "username", user.Username)
+	return nil
+}
+
+// Authorize reports whether username is whitelisted and records its use
+func (s *WhitelistService) Authorize(ctx context.Context, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.repo.Get(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	users = copyUsers(users)
+	for _, u := range users {
+		if u.Username != username {
+			continue
+		}
+		now := s.now().UTC()
+		if now.Sub(u.LastUseDate) < lastUseSaveInterval {
+			return true, nil
+		}
+		u.LastUseDate = now
+		return true, s.repo.Save(ctx, users)
+	}
+	return false, nil
+}
+
+func (s *WhitelistService) GetUsers(ctx context.Context) ([]*model.HueUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return copyUsers(users), nil
+}
+
+func (s *WhitelistService) DeleteUser(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.repo.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, u := range users {
+		if u.Username == username {
+			remaining := append(copyUsers(users[:i]), copyUsers(users[i+1:])...)
+			return s.repo.Save(ctx, remaining)
+		}
+	}
+	return fmt.Errorf("user %s %w", username, model.ErrNotFound)
+}
+
+// copyUsers detaches the users from the repository cache before they are modified
+func copyUsers(users []*model.HueUser) []*model.HueUser {
+	res := make([]*model.HueUser, len(users))
+	for i, u := range users {
+		c := *u
+		res[i] = &c
+	}
+	return res
+}
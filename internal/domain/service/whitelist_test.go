@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockWhitelistRepo struct {
+	mock.Mock
+}
+
+func (m *MockWhitelistRepo) Get(ctx context.Context) ([]*model.HueUser, error) {
+	args := m.Called(ctx)
+	res := args.Get(0)
+	if res == nil {
+		return nil, args.Error(1)
+	}
+	return res.([]*model.HueUser), args.Error(1)
+}
+
+func (m *MockWhitelistRepo) Save(ctx context.Context, users []*model.HueUser) error {
+	args := m.Called(ctx, users)
+	return args.Error(0)
+}
+
+func (m *MockWhitelistRepo) Exists() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
+func TestWhitelistService(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Register", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		s.now = func() time.Time { return now }
+
+		s.PressLinkButton(ctx)
+		existing := &model.HueUser{Username: "existing"}
+		mockRepo.On("Get", ctx).Return([]*model.HueUser{existing}, nil)
+		mockRepo.On("Save", ctx, mock.MatchedBy(func(users []*model.HueUser) bool {
+			return len(users) == 2 && users[0].Username == "existing" && users[1].DeviceType == "Echo"
+		})).Return(nil).Once()
+
+		user, err := s.Register(ctx, "Echo")
+		assert.NoError(t, err)
+		assert.Len(t, user.Username, 40)
+		assert.Equal(t, "Echo", user.DeviceType)
+		assert.Equal(t, now, user.CreateDate)
+		assert.Equal(t, now, user.LastUseDate)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Register Errors", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		s.PressLinkButton(ctx)
+
+		mockRepo.On("Get", ctx).Return(nil, fmt.Errorf("read error")).Once()
+		_, err := s.Register(ctx, "Echo")
+		assert.Error(t, err)
+
+		mockRepo.On("Get", ctx).Return(nil, nil)
+		mockRepo.On("Save", ctx, mock.Anything).Return(fmt.Errorf("write error")).Once()
+		_, err = s.Register(ctx, "Echo")
+		assert.Error(t, err)
+	})
+
+	t.Run("Link Button", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		current := now
+		s.now = func() time.Time { return current }
+		s.SetLinkButtonWindow(10 * time.Second)
+
+		// Closed until pressed
+		assert.False(t, s.LinkButtonPressed(ctx))
+		_, err := s.Register(ctx, "Echo")
+		assert.ErrorIs(t, err, model.ErrLinkButtonNotPressed)
+
+		until := s.PressLinkButton(ctx)
+		assert.Equal(t, now.Add(10*time.Second), until)
+		assert.True(t, s.LinkButtonPressed(ctx))
+
+		// Closes again once the window has elapsed
+		current = until
+		assert.False(t, s.LinkButtonPressed(ctx))
+		_, err = s.Register(ctx, "Echo")
+		assert.ErrorIs(t, err, model.ErrLinkButtonNotPressed)
+		mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Authorize", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		s.now = func() time.Time { return now }
+
+		recent := &model.HueUser{Username: "recent", LastUseDate: now.Add(-10 * time.Second)}
+		stale := &model.HueUser{Username: "stale", LastUseDate: now.Add(-time.Hour)}
+		mockRepo.On("Get", ctx).Return([]*model.HueUser{recent, stale}, nil)
+
+		// Recent use is not written again
+		ok, err := s.Authorize(ctx, "recent")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+
+		// Stale last-use date is refreshed without touching the cached users
+		mockRepo.On("Save", ctx, mock.MatchedBy(func(users []*model.HueUser) bool {
+			return users[1].LastUseDate.Equal(now)
+		})).Return(nil).Once()
+		ok, err = s.Authorize(ctx, "stale")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, now.Add(-time.Hour), stale.LastUseDate)
+
+		ok, err = s.Authorize(ctx, "unknown")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("EnsureLegacyUser", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		s.now = func() time.Time { return now }
+
+		// New installations and existing whitelists are left alone
+		assert.NoError(t, s.EnsureLegacyUser(ctx, false))
+		mockRepo.On("Exists").Return(true).Once()
+		assert.NoError(t, s.EnsureLegacyUser(ctx, true))
+		mockRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+
+		mockRepo.On("Exists").Return(false)
+		mockRepo.On("Save", ctx, []*model.HueUser{{Username: model.LegacyHueUsername, DeviceType: "legacy", CreateDate: now, LastUseDate: now}}).Return(nil).Once()
+		assert.NoError(t, s.EnsureLegacyUser(ctx, true))
+
+		mockRepo.On("Save", ctx, mock.Anything).Return(fmt.Errorf("write error")).Once()
+		assert.Error(t, s.EnsureLegacyUser(ctx, true))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Authorize Error", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		mockRepo.On("Get", ctx).Return(nil, fmt.Errorf("read error"))
+
+		ok, err := s.Authorize(ctx, "any")
+		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("GetUsers", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+
+		user := &model.HueUser{Username: "u1"}
+		mockRepo.On("Get", ctx).Return([]*model.HueUser{user}, nil).Once()
+		users, err := s.GetUsers(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.HueUser{{Username: "u1"}}, users)
+		assert.NotSame(t, user, users[0])
+
+		mockRepo.On("Get", ctx).Return(nil, fmt.Errorf("read error")).Once()
+		_, err = s.GetUsers(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+
+		users := []*model.HueUser{{Username: "u1"}, {Username: "u2"}, {Username: "u3"}}
+		mockRepo.On("Get", ctx).Return(users, nil)
+		mockRepo.On("Save", ctx, []*model.HueUser{{Username: "u1"}, {Username: "u3"}}).Return(nil).Once()
+
+		assert.NoError(t, s.DeleteUser(ctx, "u2"))
+		assert.Len(t, users, 3)
+
+		err := s.DeleteUser(ctx, "unknown")
+		assert.ErrorIs(t, err, model.ErrNotFound)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("DeleteUser Error", func(t *testing.T) {
+		mockRepo := new(MockWhitelistRepo)
+		s := NewWhitelistService(mockRepo)
+		mockRepo.On("Get", ctx).Return(nil, fmt.Errorf("read error"))
+
+		assert.Error(t, s.DeleteUser(ctx, "u1"))
+	})
+}
@@ -2,59 +2,170 @@ package translator
 
 import (
 	"hue-bridge-emulator/internal/domain/model"
+	"math"
+	"sync"
 )
 
-type ClimateStrategy struct{}
+// Defaults for entities that do not report their range
+const (
+	defaultClimateMinTemp = 7.0
+	defaultClimateMaxTemp = 28.0
+	defaultClimateStep    = 0.5
+)
+
+// ClimateStrategy maps Hue brightness to the target temperature within the range of the entity,
+// and on/off to its HVAC mode
+type ClimateStrategy struct {
+	mu       sync.RWMutex
+	entities map[string]climateEntity // learnt from the states HA reports, by entity ID
+}
+
+func (s *ClimateStrategy) Blank() Translator {
+	return &ClimateStrategy{}
+}
+
+// climateEntity is the range of a climate entity, dual setpoint entities have a target_temp_low
+// and target_temp_high span instead of a single temperature
+type climateEntity struct {
+	min, max, step float64
+	dual           bool
+	span           float64
+}
 
 func (s *ClimateStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
 	state := &model.DeviceState{}
-	state.On = true
-	if temp, ok := haState.Attributes["temperature"].(float64); ok {
-		// Map 7-28°C to 0-254
-		if temp < 7 {
-			temp = 7
-		}
-		if temp > 28 {
-			temp = 28
+	state.On = (haState.State != "off" && haState.State != model.HAStateUnavailable && haState.State != model.HAStateUnknown)
+
+	e := climateEntity{min: defaultClimateMinTemp, max: defaultClimateMaxTemp, step: defaultClimateStep}
+	if v, ok := haState.Attributes["min_temp"].(float64); ok {
+		e.min = v
+	}
+	if v, ok := haState.Attributes["max_temp"].(float64); ok {
+		e.max = v
+	}
+	if v, ok := haState.Attributes["target_temp_step"].(float64); ok && v > 0 {
+		e.step = v
+	}
+
+	temp, ok := haState.Attributes["temperature"].(float64)
+	low, lowOk := haState.Attributes["target_temp_low"].(float64)
+	high, highOk := haState.Attributes["target_temp_high"].(float64)
+	if !ok && lowOk && highOk {
+		e.dual = true
+		e.span = high - low
+		temp, ok = (low+high)/2, true
+	}
+
+	if len(haState.Attributes) > 0 {
+		s.mu.Lock()
+		if s.entities == nil {
+			s.entities = make(map[string]climateEntity)
 		}
-		state.Bri = uint8((temp - 7) * 254 / (28 - 7))
+		s.entities[vd.EntityID] = e
+		s.mu.Unlock()
+	}
+
+	if ok {
+		min, max, _ := s.climateRange(vd)
+		state.Bri = uint8(math.Round((math.Max(min, math.Min(max, temp)) - min) * 254 / (max - min)))
 	}
-	state.Reachable = true
 	return state
 }
 
 func (s *ClimateStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
-	service := "set_temperature"
 	params := make(model.HAFields)
-	temp := 7.0 + (float64(hueState.Bri) * (28.0 - 7.0) / 254.0)
-	params["temperature"] = temp
-
-	var effect string
+	var mode string
 	if vd.ActionConfig != nil {
-		if hueState.On {
-			if vd.ActionConfig.OnService != "" {
-				service = vd.ActionConfig.OnService
-			}
-			effect = vd.ActionConfig.OnEffect
-			for k, v := range vd.ActionConfig.OnPayload {
-				params[k] = v
-			}
-		} else {
-			if vd.ActionConfig.OffService != "" {
-				service = vd.ActionConfig.OffService
-			}
-			effect = vd.ActionConfig.OffEffect
-			for k, v := range vd.ActionConfig.OffPayload {
-				params[k] = v
-			}
+		mode = vd.ActionConfig.HVACMode
+	}
+
+	// Older configs set the mode through the payloads, those keep calling set_hvac_mode
+	legacy := payloadHVACMode(hueState.On, vd)
+
+	service := "turn_on"
+	switch {
+	case !hueState.On && (mode != "" || legacy):
+		service = "set_hvac_mode"
+		params["hvac_mode"] = "off"
+	case !hueState.On:
+		service = "turn_off"
+	case hueState.UpdatedByBri:
+		service = "set_temperature"
+		s.setTemperature(params, hueState.Bri, vd)
+		if mode != "" {
+			params["hvac_mode"] = mode
+		}
+	case mode != "" || legacy:
+		service = "set_hvac_mode"
+		if mode != "" {
+			params["hvac_mode"] = mode
 		}
 	}
+	return withActionConfig(service, params, hueState, vd)
+}
+
+// payloadHVACMode tells whether the payload sent for the requested on state carries hvac_mode
+func payloadHVACMode(on bool, vd *model.VirtualDevice) bool {
+	ac := vd.ActionConfig
+	if ac == nil {
+		return false
+	}
+	payload := ac.OffPayload
+	if on {
+		payload = ac.OnPayload
+	}
+	_, ok := payload["hvac_mode"]
+	return ok
+}
 
-	return model.HomeAssistantCommand{
-		Service: service,
-		Data:    params,
-		Effect:  effect,
+// setTemperature sets the target temperature of bri, snapped to the step of the entity. Dual
+// setpoint entities keep their span centred on it.
+func (s *ClimateStrategy) setTemperature(params model.HAFields, bri uint8, vd *model.VirtualDevice) {
+	min, max, step := s.climateRange(vd)
+	temp := snapTemperature(min+float64(bri)*(max-min)/254, step)
+
+	s.mu.RLock()
+	e := s.entities[vd.EntityID]
+	s.mu.RUnlock()
+	if !e.dual {
+		params["temperature"] = temp
+		return
 	}
+	low := snapTemperature(math.Max(min, temp-e.span/2), step)
+	high := snapTemperature(math.Min(max, low+e.span), step)
+	params["target_temp_low"] = low
+	params["target_temp_high"] = high
+}
+
+// climateRange returns the range and step of an entity, per-device overrides win over those the
+// entity reports
+func (s *ClimateStrategy) climateRange(vd *model.VirtualDevice) (min, max, step float64) {
+	s.mu.RLock()
+	e, ok := s.entities[vd.EntityID]
+	s.mu.RUnlock()
+	if !ok {
+		e = climateEntity{min: defaultClimateMinTemp, max: defaultClimateMaxTemp, step: defaultClimateStep}
+	}
+	if ac := vd.ActionConfig; ac != nil {
+		if ac.ClimateMinTemp != nil {
+			e.min = *ac.ClimateMinTemp
+		}
+		if ac.ClimateMaxTemp != nil {
+			e.max = *ac.ClimateMaxTemp
+		}
+		if ac.ClimateTempStep > 0 {
+			e.step = ac.ClimateTempStep
+		}
+	}
+	if e.max <= e.min {
+		return defaultClimateMinTemp, defaultClimateMaxTemp, e.step
+	}
+	return e.min, e.max, e.step
+}
+
+// snapTemperature rounds temp to a multiple of step, without floating point noise
+func snapTemperature(temp, step float64) float64 {
+	return math.Round(math.Round(temp/step)*step*100) / 100
 }
 
 func (s *ClimateStrategy) GetMetadata() model.HueMetadata {
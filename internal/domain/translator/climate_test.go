@@ -0,0 +1,102 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func climateState(state string, attrs model.HAFields) model.HAEntityState {
+	return model.HAEntityState{EntityID: "climate.living", State: state, Attributes: attrs}
+}
+
+func TestClimateStrategy_Range(t *testing.T) {
+	s := &ClimateStrategy{}
+	vd := &model.VirtualDevice{EntityID: "climate.living", Type: model.MappingTypeClimate}
+
+	// The range and step come from the entity
+	hueState := s.ToHue(climateState("heat", model.HAFields{"temperature": 20.0, "min_temp": 16.0, "max_temp": 24.0, "target_temp_step": 1.0}), vd)
+	assert.True(t, hueState.On)
+	assert.Equal(t, uint8(127), hueState.Bri)
+	assert.False(t, s.ToHue(climateState("off", model.HAFields{"temperature": 20.0, "min_temp": 16.0, "max_temp": 24.0, "target_temp_step": 1.0}), vd).On)
+
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 100, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_temperature", cmd.Service)
+	assert.Equal(t, 19.0, cmd.Data["temperature"])
+
+	// Per-device overrides win
+	min, max := 18.0, 22.0
+	vd.ActionConfig = &model.ActionConfig{ClimateMinTemp: &min, ClimateMaxTemp: &max, ClimateTempStep: 0.1}
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 200, UpdatedByBri: true}, vd)
+	assert.Equal(t, 21.1, cmd.Data["temperature"])
+	assert.Equal(t, uint8(254), s.ToHue(climateState("heat", model.HAFields{"temperature": 23.0}), vd).Bri)
+
+	// Unknown entities use the default range
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 127, UpdatedByBri: true}, &model.VirtualDevice{EntityID: "climate.other"})
+	assert.Equal(t, 17.5, cmd.Data["temperature"])
+}
+
+func TestClimateStrategy_Blank(t *testing.T) {
+	s := &ClimateStrategy{}
+	vd := &model.VirtualDevice{EntityID: "climate.living", Type: model.MappingTypeClimate}
+	s.ToHue(climateState("heat", model.HAFields{"temperature": 20.0, "min_temp": 16.0, "max_temp": 24.0}), vd)
+
+	// A blank instance learns on its own, the range of the original is kept
+	blank := s.Blank()
+	blank.ToHue(climateState("heat", model.HAFields{"temperature": 20.0, "min_temp": 10.0, "max_temp": 30.0}), vd)
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, 24.0, cmd.Data["temperature"])
+	cmd = blank.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, 30.0, cmd.Data["temperature"])
+
+	var _ StatefulTranslator = &ColorLightStrategy{}
+	var _ StatefulTranslator = &CustomStrategy{}
+	var _ StatefulTranslator = &FanStrategy{}
+}
+
+func TestClimateStrategy_HVACMode(t *testing.T) {
+	s := &ClimateStrategy{}
+	vd := &model.VirtualDevice{EntityID: "climate.living", Type: model.MappingTypeClimate}
+
+	// Without a mode on/off turn the entity on and off
+	assert.Equal(t, "turn_on", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+	assert.Equal(t, "turn_off", s.ToHA(&model.DeviceState{On: false}, vd).Service)
+
+	vd.ActionConfig = &model.ActionConfig{HVACMode: "cool"}
+	cmd := s.ToHA(&model.DeviceState{On: true}, vd)
+	assert.Equal(t, "set_hvac_mode", cmd.Service)
+	assert.Equal(t, model.HAFields{"hvac_mode": "cool"}, cmd.Data)
+	cmd = s.ToHA(&model.DeviceState{On: false}, vd)
+	assert.Equal(t, "set_hvac_mode", cmd.Service)
+	assert.Equal(t, model.HAFields{"hvac_mode": "off"}, cmd.Data)
+
+	// Setting a temperature also turns the entity on
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_temperature", cmd.Service)
+	assert.Equal(t, "cool", cmd.Data["hvac_mode"])
+	assert.Equal(t, 28.0, cmd.Data["temperature"])
+}
+
+func TestClimateStrategy_DualSetpoint(t *testing.T) {
+	s := &ClimateStrategy{}
+	vd := &model.VirtualDevice{EntityID: "climate.living", Type: model.MappingTypeClimate}
+
+	hueState := s.ToHue(climateState("heat_cool", model.HAFields{
+		"temperature": nil, "target_temp_low": 19.0, "target_temp_high": 23.0,
+		"min_temp": 10.0, "max_temp": 30.0, "target_temp_step": 0.5,
+	}), vd)
+	assert.True(t, hueState.On)
+	assert.Equal(t, uint8(140), hueState.Bri)
+
+	// The span is kept around the requested temperature, and within the range
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 152, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_temperature", cmd.Service)
+	assert.NotContains(t, cmd.Data, "temperature")
+	assert.Equal(t, 20.0, cmd.Data["target_temp_low"])
+	assert.Equal(t, 24.0, cmd.Data["target_temp_high"])
+
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, 28.0, cmd.Data["target_temp_low"])
+	assert.Equal(t, 30.0, cmd.Data["target_temp_high"])
+}
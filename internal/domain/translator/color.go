@@ -0,0 +1,255 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"math"
+	"strings"
+	"sync"
+)
+
+// ColorLightStrategy translates lights according to the colour modes HA reports for them. Colour
+// requests are converted to a mode the entity supports, clamped to the advertised gamut, and the
+// metadata matches what the light can do. Entities without supported_color_modes are translated
+// like LightStrategy does.
+type ColorLightStrategy struct {
+	LightStrategy
+	mu           sync.RWMutex
+	capabilities map[string]colorCapabilities // By entity ID, learnt from the states HA reports
+}
+
+func (s *ColorLightStrategy) Blank() Translator {
+	return &ColorLightStrategy{}
+}
+
+// colorCapabilities are the HA colour modes of a light and its colour temperature range
+type colorCapabilities struct {
+	modes     map[string]bool
+	minKelvin float64
+	maxKelvin float64
+}
+
+func (c colorCapabilities) colour() bool {
+	return c.modes["hs"] || c.modes["xy"] || c.rgb() != ""
+}
+
+// rgb returns the RGB colour mode of the light, if any
+func (c colorCapabilities) rgb() string {
+	for _, mode := range []string{"rgb", "rgbw", "rgbww"} {
+		if c.modes[mode] {
+			return mode
+		}
+	}
+	return ""
+}
+
+func (c colorCapabilities) dimmable() bool {
+	return len(c.modes) > 0 && !(len(c.modes) == 1 && c.modes["onoff"])
+}
+
+func (s *ColorLightStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := s.LightStrategy.ToHue(haState, vd)
+	caps, ok := capabilitiesOf(haState)
+	if !ok {
+		return state
+	}
+	s.mu.Lock()
+	if s.capabilities == nil {
+		s.capabilities = make(map[string]colorCapabilities)
+	}
+	s.capabilities[vd.EntityID] = caps
+	s.mu.Unlock()
+
+	if !caps.colour() {
+		state.Hue, state.Sat, state.Xy, state.ColorMode = 0, 0, nil, ""
+		if caps.modes["color_temp"] {
+			state.ColorMode = "ct"
+		}
+		return state
+	}
+
+	// Lights reporting RGB only still get a chromaticity and a hue
+	if state.Xy == nil {
+		if rgb, ok := floatTriple(haState.Attributes["rgb_color"]); ok {
+			xy := rgbToXY(rgb)
+			state.Xy = []float32{float32(xy.X), float32(xy.Y)}
+			if _, ok := haState.Attributes["hs_color"]; !ok {
+				h, sat := rgbToHS([3]int{int(rgb[0]), int(rgb[1]), int(rgb[2])})
+				state.Hue = uint16(math.Round(h / 360 * 65535))
+				state.Sat = uint8(math.Round(sat / 100 * 254))
+			}
+		}
+	}
+	if len(state.Xy) == 2 {
+		xy := clampToGamut(xyPoint{float64(state.Xy[0]), float64(state.Xy[1])}, gamutC)
+		state.Xy = []float32{float32(xy.X), float32(xy.Y)}
+	}
+	return state
+}
+
+func (s *ColorLightStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service, params := s.LightStrategy.params(hueState, vd)
+	if caps, ok := s.capabilitiesFor(vd); ok && hueState.On && strings.HasPrefix(vd.EntityID, "light.") {
+		s.adaptToCapabilities(params, hueState, caps)
+	}
+	return withActionConfig(service, params, hueState, vd)
+}
+
+// adaptToCapabilities converts the colour of params to a mode the light supports, dropping what
+// it cannot do
+func (s *ColorLightStrategy) adaptToCapabilities(params model.HAFields, hueState *model.DeviceState, caps colorCapabilities) {
+	if !caps.dimmable() {
+		delete(params, "brightness")
+	}
+	requested, ok := requestedColor(hueState)
+	if !ok {
+		return
+	}
+	delete(params, "xy_color")
+	delete(params, "hs_color")
+	delete(params, "color_temp_kelvin")
+	for k, v := range caps.colorParams(requested) {
+		params[k] = v
+	}
+}
+
+// GetMetadata is advertised for lights whose colour modes HA has not reported
+func (s *ColorLightStrategy) GetMetadata() model.HueMetadata {
+	return s.LightStrategy.GetMetadata()
+}
+
+// GetEntityMetadata advertises the Hue light type matching the capabilities of the entity
+func (s *ColorLightStrategy) GetEntityMetadata(vd *model.VirtualDevice) model.HueMetadata {
+	caps, ok := s.capabilitiesFor(vd)
+	switch {
+	case !strings.HasPrefix(vd.EntityID, "light.") && vd.EntityID != "":
+		return model.HueMetadata{Type: "On/Off light", ModelID: "LOM001", ManufacturerName: "Philips"}
+	case !ok:
+		return s.GetMetadata()
+	case caps.colour():
+		return model.HueMetadata{Type: "Extended color light", ModelID: "LCT015", ManufacturerName: "Philips"}
+	case caps.modes["color_temp"]:
+		return model.HueMetadata{Type: "Color temperature light", ModelID: "LTW001", ManufacturerName: "Philips"}
+	case caps.dimmable():
+		return model.HueMetadata{Type: "Dimmable light", ModelID: "LWB010", ManufacturerName: "Philips"}
+	default:
+		return model.HueMetadata{Type: "On/Off light", ModelID: "LOM001", ManufacturerName: "Philips"}
+	}
+}
+
+func (s *ColorLightStrategy) capabilitiesFor(vd *model.VirtualDevice) (colorCapabilities, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	caps, ok := s.capabilities[vd.EntityID]
+	return caps, ok
+}
+
+// capabilitiesOf reads the colour capabilities HA reports for a light
+func capabilitiesOf(haState model.HAEntityState) (colorCapabilities, bool) {
+	list, ok := haState.Attributes["supported_color_modes"].([]any)
+	if !ok {
+		return colorCapabilities{}, false
+	}
+	caps := colorCapabilities{modes: make(map[string]bool, len(list))}
+	for _, m := range list {
+		if mode, ok := m.(string); ok {
+			caps.modes[mode] = true
+		}
+	}
+	caps.minKelvin, _ = haState.Attributes["min_color_temp_kelvin"].(float64)
+	caps.maxKelvin, _ = haState.Attributes["max_color_temp_kelvin"].(float64)
+	return caps, true
+}
+
+// colorRequest is the colour of a Hue command, in the representation it was requested in
+type colorRequest struct {
+	mode string // xy, ct or hs
+	xy   xyPoint
+	ct   uint16
+	hue  float64 // HA degrees
+	sat  float64 // HA percent
+}
+
+// requestedColor returns the colour of a command with the precedence Hue applies: xy, ct, hue/sat
+func requestedColor(st *model.DeviceState) (colorRequest, bool) {
+	switch {
+	case st.UpdatedByXy && len(st.Xy) == 2:
+		return colorRequest{mode: "xy", xy: xyPoint{float64(st.Xy[0]), float64(st.Xy[1])}}, true
+	case st.UpdatedByCt && st.Ct > 0:
+		return colorRequest{mode: "ct", ct: st.Ct}, true
+	case st.UpdatedByHue || st.UpdatedBySat:
+		return colorRequest{mode: "hs", hue: float64(st.Hue) / 65535 * 360, sat: float64(st.Sat) / 254 * 100}, true
+	}
+	return colorRequest{}, false
+}
+
+// colorParams expresses a requested colour in a mode the light supports
+func (c colorCapabilities) colorParams(req colorRequest) model.HAFields {
+	// White temperatures go to the white channel when there is one
+	if req.mode == "ct" && c.modes["color_temp"] {
+		return model.HAFields{"color_temp_kelvin": c.clampKelvin(1e6 / float64(req.ct))}
+	}
+	if req.mode == "hs" && c.modes["hs"] {
+		return model.HAFields{"hs_color": []float64{round2(req.hue), round2(req.sat)}}
+	}
+
+	var xy xyPoint
+	switch req.mode {
+	case "xy":
+		xy = req.xy
+	case "ct":
+		xy = kelvinToXY(1e6 / float64(req.ct))
+	default:
+		xy = rgbToXY(hsToRGB(req.hue, req.sat))
+	}
+	xy = clampToGamut(xy, gamutC)
+
+	switch {
+	case c.modes["xy"]:
+		return model.HAFields{"xy_color": []float64{round4(xy.X), round4(xy.Y)}}
+	case c.modes["hs"]:
+		h, s := rgbToHS(xyToRGB(xy))
+		return model.HAFields{"hs_color": []float64{round2(h), round2(s)}}
+	case c.rgb() != "":
+		rgb := xyToRGB(xy)
+		return model.HAFields{"rgb_color": []int{rgb[0], rgb[1], rgb[2]}}
+	case c.modes["color_temp"]:
+		// The closest white a temperature-only light can show
+		return model.HAFields{"color_temp_kelvin": c.clampKelvin(xyToKelvin(xy))}
+	}
+	return nil
+}
+
+func (c colorCapabilities) clampKelvin(k float64) int {
+	if c.minKelvin > 0 && k < c.minKelvin {
+		k = c.minKelvin
+	}
+	if c.maxKelvin > 0 && k > c.maxKelvin {
+		k = c.maxKelvin
+	}
+	return int(math.Round(k))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+// floatTriple reads a three-element numeric HA attribute such as rgb_color
+func floatTriple(v any) ([3]float64, bool) {
+	list, ok := v.([]any)
+	if !ok || len(list) != 3 {
+		return [3]float64{}, false
+	}
+	var res [3]float64
+	for i, item := range list {
+		f, ok := item.(float64)
+		if !ok {
+			return [3]float64{}, false
+		}
+		res[i] = f
+	}
+	return res, true
+}
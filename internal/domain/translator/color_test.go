@@ -0,0 +1,269 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func colorLight(entityID string, modes ...any) model.HAEntityState {
+	return model.HAEntityState{
+		EntityID: entityID,
+		State:    "on",
+		Attributes: model.HAFields{
+			"brightness":            200.0,
+			"supported_color_modes": modes,
+			"min_color_temp_kelvin": 2000.0,
+			"max_color_temp_kelvin": 6500.0,
+		},
+	}
+}
+
+func TestColorSpace_Conversions(t *testing.T) {
+	// sRGB primaries and white
+	red := rgbToXY([3]float64{255, 0, 0})
+	assert.InDelta(t, 0.6401, red.X, 0.001)
+	assert.InDelta(t, 0.3300, red.Y, 0.001)
+	assert.Equal(t, [3]int{255, 0, 0}, xyToRGB(red))
+	assert.Equal(t, [3]int{0, 255, 0}, xyToRGB(rgbToXY([3]float64{0, 255, 0})))
+	assert.Equal(t, [3]int{0, 0, 255}, xyToRGB(rgbToXY([3]float64{0, 0, 255})))
+	assert.Equal(t, [3]int{255, 255, 255}, xyToRGB(rgbToXY([3]float64{255, 255, 255})))
+	// Brightness is sent separately, colours are returned at full brightness
+	assert.Equal(t, [3]int{255, 134, 0}, xyToRGB(rgbToXY([3]float64{128, 64, 0})))
+	// Black and invalid chromaticities fall back to white
+	assert.Equal(t, whitePoint, rgbToXY([3]float64{0, 0, 0}))
+	assert.Equal(t, xyToRGB(whitePoint), xyToRGB(xyPoint{0.3, 0}))
+
+	for _, tt := range []struct {
+		h, s float64
+		rgb  [3]int
+	}{
+		{0, 100, [3]int{255, 0, 0}},
+		{60, 100, [3]int{255, 255, 0}},
+		{120, 100, [3]int{0, 255, 0}},
+		{180, 100, [3]int{0, 255, 255}},
+		{240, 100, [3]int{0, 0, 255}},
+		{300, 100, [3]int{255, 0, 255}},
+		{30, 50, [3]int{255, 191, 128}},
+	} {
+		got := hsToRGB(tt.h, tt.s)
+		rgb := [3]int{int(got[0] + 0.5), int(got[1] + 0.5), int(got[2] + 0.5)}
+		assert.Equal(t, tt.rgb, rgb, "hs %v/%v", tt.h, tt.s)
+		h, s := rgbToHS(rgb)
+		assert.InDelta(t, tt.h, h, 0.5, "hue of %v", rgb)
+		assert.InDelta(t, tt.s, s, 0.5, "saturation of %v", rgb)
+	}
+	// Hues wrap around, saturation is bounded
+	assert.Equal(t, hsToRGB(330, 100), hsToRGB(-30, 120))
+	h, _ := rgbToHS([3]int{255, 0, 128})
+	assert.InDelta(t, 329.9, h, 0.1)
+	h, s := rgbToHS([3]int{90, 90, 90})
+	assert.Zero(t, h)
+	assert.Zero(t, s)
+}
+
+func TestColorSpace_Kelvin(t *testing.T) {
+	for _, k := range []float64{2000, 2700, 4000, 6500} {
+		assert.InDelta(t, k, xyToKelvin(kelvinToXY(k)), k*0.02, "%vK", k)
+	}
+	warm := kelvinToXY(2700)
+	assert.InDelta(t, 0.4599, warm.X, 0.002)
+	assert.InDelta(t, 0.4106, warm.Y, 0.002)
+	// Out of range temperatures are clamped
+	assert.Equal(t, kelvinToXY(minKelvin), kelvinToXY(1000))
+	assert.Equal(t, float64(maxKelvin), xyToKelvin(xyPoint{0.24, 0.24}))
+	assert.Equal(t, float64(minKelvin), xyToKelvin(xyPoint{0.6, 0.38}))
+}
+
+func TestColorSpace_Gamut(t *testing.T) {
+	inside := xyPoint{0.4, 0.4}
+	assert.Equal(t, inside, clampToGamut(inside, gamutC))
+
+	// Beyond an edge, the closest point of the edge
+	p := clampToGamut(xyPoint{0.5, 0.6}, gamutC)
+	assert.True(t, inTriangle(p, gamutC) || cross(p, gamutC[0], gamutC[1]) < 1e-9)
+	assert.InDelta(t, 0.4290, p.X, 0.001)
+	assert.InDelta(t, 0.5055, p.Y, 0.001)
+
+	// Beyond a corner, the corner itself
+	for _, tt := range []struct{ p, corner xyPoint }{
+		{xyPoint{0.9, 0.25}, gamutC[0]},
+		{xyPoint{0.1, 0.9}, gamutC[1]},
+	} {
+		got := clampToGamut(tt.p, gamutC)
+		assert.InDelta(t, tt.corner.X, got.X, 1e-9)
+		assert.InDelta(t, tt.corner.Y, got.Y, 1e-9)
+	}
+}
+
+func TestColorLightStrategy_ToHue(t *testing.T) {
+	s := &ColorLightStrategy{}
+
+	// Without supported colour modes, lights are translated as before
+	vd := &model.VirtualDevice{EntityID: "light.plain"}
+	plain := model.HAEntityState{EntityID: "light.plain", State: "on", Attributes: model.HAFields{"xy_color": []any{0.8, 0.2}}}
+	assert.Equal(t, []float32{0.8, 0.2}, s.ToHue(plain, vd).Xy)
+
+	// Colour is clamped to the gamut
+	vd = &model.VirtualDevice{EntityID: "light.color"}
+	state := colorLight("light.color", "hs", "xy", "color_temp")
+	state.Attributes["xy_color"] = []any{0.8, 0.2}
+	state.Attributes["hs_color"] = []any{350.0, 100.0}
+	state.Attributes["color_mode"] = "xy"
+	hueState := s.ToHue(state, vd)
+	assert.Equal(t, []float32{float32(gamutC[0].X), float32(gamutC[0].Y)}, hueState.Xy)
+	assert.Equal(t, "xy", hueState.ColorMode)
+	assert.Equal(t, uint16(63715), hueState.Hue)
+
+	// RGB lights get a chromaticity and a hue
+	vd = &model.VirtualDevice{EntityID: "light.strip"}
+	state = colorLight("light.strip", "rgb")
+	state.Attributes["rgb_color"] = []any{0.0, 255.0, 0.0}
+	state.Attributes["color_mode"] = "rgb"
+	hueState = s.ToHue(state, vd)
+	require.Len(t, hueState.Xy, 2)
+	assert.InDelta(t, 0.3, hueState.Xy[0], 0.001)
+	assert.InDelta(t, 0.6, hueState.Xy[1], 0.001)
+	assert.InDelta(t, 21845, int(hueState.Hue), 2)
+	assert.Equal(t, uint8(254), hueState.Sat)
+
+	// A reported hue is kept
+	state.Attributes["hs_color"] = []any{100.0, 50.0}
+	hueState = s.ToHue(state, vd)
+	assert.Equal(t, uint16(18204), hueState.Hue)
+	assert.Equal(t, uint8(127), hueState.Sat)
+
+	// Malformed RGB values are ignored
+	for _, rgb := range []any{"red", []any{1.0, 2.0}, []any{1.0, "2", 3.0}} {
+		state = colorLight("light.strip", "rgb")
+		state.Attributes["rgb_color"] = rgb
+		assert.Nil(t, s.ToHue(state, vd).Xy, "%v", rgb)
+	}
+
+	// White lights report no colour
+	vd = &model.VirtualDevice{EntityID: "light.white"}
+	state = colorLight("light.white", "color_temp")
+	state.Attributes["color_temp_kelvin"] = 2700.0
+	state.Attributes["xy_color"] = []any{0.46, 0.41}
+	state.Attributes["hs_color"] = []any{30.0, 60.0}
+	state.Attributes["color_mode"] = "color_temp"
+	hueState = s.ToHue(state, vd)
+	assert.Nil(t, hueState.Xy)
+	assert.Zero(t, hueState.Hue)
+	assert.Zero(t, hueState.Sat)
+	assert.Equal(t, uint16(370), hueState.Ct)
+	assert.Equal(t, "ct", hueState.ColorMode)
+
+	vd = &model.VirtualDevice{EntityID: "light.dimmable"}
+	state = colorLight("light.dimmable", "brightness")
+	state.Attributes["color_mode"] = "xy"
+	state.Attributes["xy_color"] = []any{0.46, 0.41}
+	hueState = s.ToHue(state, vd)
+	assert.Nil(t, hueState.Xy)
+	assert.Empty(t, hueState.ColorMode)
+	assert.Equal(t, uint8(200), hueState.Bri)
+}
+
+func TestColorLightStrategy_ToHA(t *testing.T) {
+	s := &ColorLightStrategy{}
+	for _, e := range []model.HAEntityState{
+		colorLight("light.xy", "xy", "color_temp"),
+		colorLight("light.hs", "hs"),
+		colorLight("light.rgb", "rgbww"),
+		colorLight("light.white", "color_temp"),
+		colorLight("light.dimmable", "brightness"),
+		colorLight("light.onoff", "onoff"),
+	} {
+		s.ToHue(e, &model.VirtualDevice{EntityID: e.EntityID})
+	}
+
+	xy := &model.DeviceState{On: true, Bri: 100, UpdatedByBri: true, Xy: []float32{0.8, 0.2}, UpdatedByXy: true}
+	ct := &model.DeviceState{On: true, Ct: 153, UpdatedByCt: true}
+	warm := &model.DeviceState{On: true, Ct: 500, UpdatedByCt: true}
+	red := &model.DeviceState{On: true, Hue: 0, Sat: 254, UpdatedByHue: true, UpdatedBySat: true}
+	bri := &model.DeviceState{On: true, Bri: 100, UpdatedByBri: true}
+
+	tests := []struct {
+		name     string
+		entityID string
+		state    *model.DeviceState
+		want     model.HAFields
+	}{
+		{"xy clamped", "light.xy", xy, model.HAFields{"brightness": uint8(100), "xy_color": []float64{0.6915, 0.3083}}},
+		{"ct to white channel", "light.xy", ct, model.HAFields{"color_temp_kelvin": 6500}},
+		{"ct clamped to range", "light.white", &model.DeviceState{On: true, Ct: 550, UpdatedByCt: true}, model.HAFields{"color_temp_kelvin": 2000}},
+		{"hs kept", "light.hs", red, model.HAFields{"hs_color": []float64{0, 100}}},
+		{"hs to xy", "light.xy", red, model.HAFields{"xy_color": []float64{0.6401, 0.33}}},
+		{"xy to hs", "light.hs", xy, model.HAFields{"brightness": uint8(100), "hs_color": []float64{0, 100}}},
+		{"ct to hs", "light.hs", warm, model.HAFields{"hs_color": []float64{30.13, 91.37}}},
+		{"hs to rgb", "light.rgb", red, model.HAFields{"rgb_color": []int{255, 0, 0}}},
+		{"xy to ct", "light.white", &model.DeviceState{On: true, Xy: []float32{0.46, 0.41}, UpdatedByXy: true}, model.HAFields{"color_temp_kelvin": 2690}},
+		{"colour on a dimmable light", "light.dimmable", red, model.HAFields{}},
+		{"brightness only", "light.dimmable", bri, model.HAFields{"brightness": uint8(100)}},
+		{"no brightness for on/off lights", "light.onoff", xy, model.HAFields{}},
+		{"unknown capabilities", "light.other", xy, model.HAFields{"brightness": uint8(100), "xy_color": []float64{float64(float32(0.8)), float64(float32(0.2))}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := s.ToHA(tt.state, &model.VirtualDevice{EntityID: tt.entityID})
+			assert.Equal(t, "turn_on", cmd.Service)
+			assert.Equal(t, tt.want, cmd.Data)
+		})
+	}
+
+	// Turning off and other domains are unaffected
+	cmd := s.ToHA(&model.DeviceState{On: false}, &model.VirtualDevice{EntityID: "light.onoff"})
+	assert.Equal(t, "turn_off", cmd.Service)
+	s.ToHue(colorLight("switch.plug", "onoff"), &model.VirtualDevice{EntityID: "switch.plug"})
+	cmd = s.ToHA(xy, &model.VirtualDevice{EntityID: "switch.plug"})
+	assert.Empty(t, cmd.Data)
+
+	// Custom payloads win over the converted colour
+	vd := &model.VirtualDevice{EntityID: "light.rgb", ActionConfig: &model.ActionConfig{OnPayload: model.HAFields{"rgb_color": []int{1, 2, 3}}}}
+	cmd = s.ToHA(red, vd)
+	assert.Equal(t, []int{1, 2, 3}, cmd.Data["rgb_color"])
+
+	// Custom services and effects apply to colour commands too
+	vd.ActionConfig = &model.ActionConfig{OnService: "script.paint", OnEffect: "script.chime", OnPayload: model.HAFields{"scene": "red"}}
+	cmd = s.ToHA(red, vd)
+	assert.Equal(t, "script.paint", cmd.Service)
+	assert.Equal(t, "script.chime", cmd.Effect)
+	assert.Equal(t, "red", cmd.Data["scene"])
+	assert.Contains(t, cmd.Data, "rgb_color")
+}
+
+func TestColorLightStrategy_Metadata(t *testing.T) {
+	s := &ColorLightStrategy{}
+	tests := []struct {
+		entityID string
+		modes    []any
+		want     string
+		modelID  string
+	}{
+		{"light.color", []any{"hs", "color_temp"}, "Extended color light", "LCT015"},
+		{"light.strip", []any{"rgbw"}, "Extended color light", "LCT015"},
+		{"light.white", []any{"color_temp"}, "Color temperature light", "LTW001"},
+		{"light.dimmable", []any{"brightness"}, "Dimmable light", "LWB010"},
+		{"light.onoff", []any{"onoff"}, "On/Off light", "LOM001"},
+		{"light.none", []any{}, "On/Off light", "LOM001"},
+		{"switch.plug", nil, "On/Off light", "LOM001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entityID, func(t *testing.T) {
+			vd := &model.VirtualDevice{EntityID: tt.entityID}
+			if tt.modes != nil {
+				s.ToHue(colorLight(tt.entityID, tt.modes...), vd)
+			}
+			meta := s.GetEntityMetadata(vd)
+			assert.Equal(t, tt.want, meta.Type)
+			assert.Equal(t, tt.modelID, meta.ModelID)
+			assert.Equal(t, "Philips", meta.ManufacturerName)
+		})
+	}
+
+	// Until HA reported the capabilities, the light is advertised as before
+	assert.Equal(t, s.GetMetadata(), s.GetEntityMetadata(&model.VirtualDevice{EntityID: "light.unseen"}))
+	assert.Equal(t, s.GetMetadata(), s.GetEntityMetadata(&model.VirtualDevice{}))
+}
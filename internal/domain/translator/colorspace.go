@@ -0,0 +1,192 @@
+package translator
+
+import "math"
+
+// xyPoint is a CIE 1931 chromaticity
+type xyPoint struct {
+	X, Y float64
+}
+
+// gamutC is the colour gamut of current Hue colour bulbs (LCT015), advertised for colour lights
+var gamutC = [3]xyPoint{{0.6915, 0.3083}, {0.17, 0.7}, {0.1532, 0.0475}}
+
+// whitePoint is D65, used when a colour has no chromaticity (black)
+var whitePoint = xyPoint{0.3127, 0.3290}
+
+// Colour temperatures the conversions are valid for
+const (
+	minKelvin = 1667
+	maxKelvin = 25000
+)
+
+// clampToGamut returns p, or the closest point of the gamut triangle when p is outside of it
+func clampToGamut(p xyPoint, gamut [3]xyPoint) xyPoint {
+	if inTriangle(p, gamut) {
+		return p
+	}
+	best, bestDist := p, math.Inf(1)
+	for i := range gamut {
+		q := closestOnSegment(p, gamut[i], gamut[(i+1)%3])
+		if d := math.Hypot(p.X-q.X, p.Y-q.Y); d < bestDist {
+			best, bestDist = q, d
+		}
+	}
+	return best
+}
+
+func inTriangle(p xyPoint, t [3]xyPoint) bool {
+	d1 := cross(p, t[0], t[1])
+	d2 := cross(p, t[1], t[2])
+	d3 := cross(p, t[2], t[0])
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNeg && hasPos)
+}
+
+func cross(p, a, b xyPoint) float64 {
+	return (p.X-b.X)*(a.Y-b.Y) - (a.X-b.X)*(p.Y-b.Y)
+}
+
+func closestOnSegment(p, a, b xyPoint) xyPoint {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return xyPoint{a.X + t*dx, a.Y + t*dy}
+}
+
+// xyToRGB converts a chromaticity to full brightness sRGB, brightness is sent separately
+func xyToRGB(p xyPoint) [3]int {
+	if p.Y <= 0 {
+		p = whitePoint
+	}
+	X := p.X / p.Y
+	Z := (1 - p.X - p.Y) / p.Y
+	lin := [3]float64{
+		X*3.2406 - 1.5372 - Z*0.4986,
+		-X*0.9689 + 1.8758 + Z*0.0415,
+		X*0.0557 - 0.2040 + Z*1.0570,
+	}
+	maxC := 0.0
+	for i, c := range lin {
+		lin[i] = math.Max(c, 0)
+		maxC = math.Max(maxC, lin[i])
+	}
+	var rgb [3]int
+	for i, c := range lin {
+		if maxC > 0 {
+			c /= maxC
+		}
+		rgb[i] = int(math.Round(gammaCompress(c) * 255))
+	}
+	return rgb
+}
+
+// rgbToXY converts sRGB to a chromaticity
+func rgbToXY(rgb [3]float64) xyPoint {
+	r := gammaExpand(rgb[0] / 255)
+	g := gammaExpand(rgb[1] / 255)
+	b := gammaExpand(rgb[2] / 255)
+	X := r*0.4124 + g*0.3576 + b*0.1805
+	Y := r*0.2126 + g*0.7152 + b*0.0722
+	Z := r*0.0193 + g*0.1192 + b*0.9505
+	sum := X + Y + Z
+	if sum == 0 {
+		return whitePoint
+	}
+	return xyPoint{X / sum, Y / sum}
+}
+
+func gammaCompress(c float64) float64 {
+	if c <= 0.0031308 {
+		return 12.92 * c
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func gammaExpand(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// hsToRGB converts HA hue (degrees) and saturation (percent) to full brightness sRGB
+func hsToRGB(h, s float64) [3]float64 {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	s = math.Max(0, math.Min(100, s)) / 100
+	c := s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := 1 - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return [3]float64{(r + m) * 255, (g + m) * 255, (b + m) * 255}
+}
+
+// rgbToHS converts sRGB to HA hue (degrees) and saturation (percent)
+func rgbToHS(rgb [3]int) (float64, float64) {
+	r, g, b := float64(rgb[0])/255, float64(rgb[1])/255, float64(rgb[2])/255
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	d := maxC - minC
+	if maxC == 0 || d == 0 {
+		return 0, 0
+	}
+	var h float64
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, d / maxC * 100
+}
+
+// kelvinToXY approximates the chromaticity of a black body (Kang et al.)
+func kelvinToXY(k float64) xyPoint {
+	t := math.Max(minKelvin, math.Min(maxKelvin, k))
+	var x float64
+	if t <= 4000 {
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+	} else {
+		x = -3.0258469e9/(t*t*t) + 2.1070379e6/(t*t) + 0.2226347e3/t + 0.240390
+	}
+	var y float64
+	switch {
+	case t <= 2222:
+		y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+	case t <= 4000:
+		y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+	default:
+		y = 3.0817580*x*x*x - 5.87338670*x*x + 3.75112997*x - 0.37001483
+	}
+	return xyPoint{x, y}
+}
+
+// xyToKelvin approximates the correlated colour temperature of a chromaticity (McCamy)
+func xyToKelvin(p xyPoint) float64 {
+	n := (p.X - 0.3320) / (0.1858 - p.Y)
+	k := 449*n*n*n + 3525*n*n + 6823.3*n + 5520.33
+	return math.Max(minKelvin, math.Min(maxKelvin, k))
+}
@@ -12,7 +12,6 @@ func (s *CoverStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevi
 	if pos, ok := haState.Attributes["current_position"].(float64); ok {
 		state.Bri = uint8(pos * 254 / 100)
 	}
-	state.Reachable = true
 	return state
 }
 
@@ -31,32 +30,7 @@ func (s *CoverStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevic
 		}
 	}
 
-	var effect string
-	if vd.ActionConfig != nil {
-		if hueState.On {
-			if vd.ActionConfig.OnService != "" {
-				service = vd.ActionConfig.OnService
-			}
-			effect = vd.ActionConfig.OnEffect
-			for k, v := range vd.ActionConfig.OnPayload {
-				params[k] = v
-			}
-		} else {
-			if vd.ActionConfig.OffService != "" {
-				service = vd.ActionConfig.OffService
-			}
-			effect = vd.ActionConfig.OffEffect
-			for k, v := range vd.ActionConfig.OffPayload {
-				params[k] = v
-			}
-		}
-	}
-
-	return model.HomeAssistantCommand{
-		Service: service,
-		Data:    params,
-		Effect:  effect,
-	}
+	return withActionConfig(service, params, hueState, vd)
 }
 
 func (s *CoverStrategy) GetMetadata() model.HueMetadata {
@@ -1,12 +1,22 @@
 package translator
 
 import (
-	"github.com/Knetic/govaluate"
+	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
+	"log/slog"
+	"math"
 	"strings"
+	"sync"
 )
 
-type CustomStrategy struct{}
+type CustomStrategy struct {
+	mu   sync.RWMutex
+	vars map[string]map[string]interface{} // formula variables by entity ID, from the states HA reports
+}
+
+func (s *CustomStrategy) Blank() Translator {
+	return &CustomStrategy{}
+}
 
 func (s *CustomStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
 	state := &model.DeviceState{}
@@ -24,13 +34,21 @@ func (s *CustomStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDev
 		input = v
 	}
 
+	vars := formulaVariables(haState)
+	s.mu.Lock()
+	if s.vars == nil {
+		s.vars = make(map[string]map[string]interface{})
+	}
+	s.vars[vd.EntityID] = vars
+	s.mu.Unlock()
+
+	output := input
 	if vd.ActionConfig != nil && vd.ActionConfig.ToHueFormula != "" {
-		state.Bri = uint8(s.evaluate(vd.ActionConfig.ToHueFormula, input))
-	} else {
-		state.Bri = uint8(input)
+		output = s.evaluate(vd.ActionConfig.ToHueFormula, input, vars)
 	}
+	// Out of range values would wrap around
+	state.Bri = uint8(math.Round(math.Max(0, math.Min(254, output))))
 
-	state.Reachable = true
 	return state
 }
 
@@ -44,7 +62,10 @@ func (s *CustomStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevi
 	input := float64(hueState.Bri)
 	var output float64
 	if vd.ActionConfig != nil && vd.ActionConfig.ToHAFormula != "" {
-		output = s.evaluate(vd.ActionConfig.ToHAFormula, input)
+		s.mu.RLock()
+		vars := s.vars[vd.EntityID]
+		s.mu.RUnlock()
+		output = s.evaluate(vd.ActionConfig.ToHAFormula, input, vars)
 	} else {
 		output = input
 	}
@@ -67,34 +88,7 @@ func (s *CustomStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevi
 		params["value"] = output
 	}
 
-	var effect string
-	if vd.ActionConfig != nil {
-		if hueState.On {
-			if vd.ActionConfig.OnService != "" {
-				service = vd.ActionConfig.OnService
-			}
-			effect = vd.ActionConfig.OnEffect
-			// Merge custom ON payload
-			for k, v := range vd.ActionConfig.OnPayload {
-				params[k] = v
-			}
-		} else {
-			if vd.ActionConfig.OffService != "" {
-				service = vd.ActionConfig.OffService
-			}
-			effect = vd.ActionConfig.OffEffect
-			// Merge custom OFF payload
-			for k, v := range vd.ActionConfig.OffPayload {
-				params[k] = v
-			}
-		}
-	}
-
-	return model.HomeAssistantCommand{
-		Service: service,
-		Data:    params,
-		Effect:  effect,
-	}
+	return withActionConfig(service, params, hueState, vd)
 }
 
 func (s *CustomStrategy) GetMetadata() model.HueMetadata {
@@ -105,22 +99,33 @@ func (s *CustomStrategy) GetMetadata() model.HueMetadata {
 	}
 }
 
-// evaluate handles simple formulas like "x * 2.54" or "x / 2.54 + 7"
-func (s *CustomStrategy) evaluate(formula string, x float64) float64 {
-	expression, err := govaluate.NewEvaluableExpression(formula)
-	if err != nil {
-		return x
+// Validate reports the formulas of the device that do not parse
+func (s *CustomStrategy) Validate(vd *model.VirtualDevice) error {
+	if vd.ActionConfig == nil {
+		return nil
+	}
+	if err := ValidateFormula(vd.ActionConfig.ToHueFormula); err != nil {
+		return fmt.Errorf("to_hue_formula %q: %w", vd.ActionConfig.ToHueFormula, err)
+	}
+	if err := ValidateFormula(vd.ActionConfig.ToHAFormula); err != nil {
+		return fmt.Errorf("to_ha_formula %q: %w", vd.ActionConfig.ToHAFormula, err)
+	}
+	return nil
+}
+
+// evaluate computes formulas like "x * 2.54" or "map(x, 0, 254, 7, 28)" with x and the variables
+// of the entity. Formulas that fail leave x unchanged.
+func (s *CustomStrategy) evaluate(formula string, x float64, vars map[string]interface{}) float64 {
+	parameters := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		parameters[k] = v
 	}
-	parameters := make(map[string]interface{}, 1)
 	parameters["x"] = x
 
-	result, err := expression.Evaluate(parameters)
+	val, err := evaluateFormula(formula, parameters)
 	if err != nil {
+		slog.Warn("Translator: formula failed, keeping the input", "formula", formula, "x", x, "error", err)
 		return x
 	}
-
-	if val, ok := result.(float64); ok {
-		return val
-	}
-	return x
+	return val
 }
@@ -0,0 +1,102 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"math"
+	"sync"
+)
+
+// FanStrategy maps Hue brightness to the fan speed percentage, or to a preset mode for the
+// brightness bands configured in the action config
+type FanStrategy struct {
+	mu    sync.RWMutex
+	steps map[string]float64 // percentage_step by entity ID, learnt from the states HA reports
+}
+
+func (s *FanStrategy) Blank() Translator {
+	return &FanStrategy{}
+}
+
+func (s *FanStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := &model.DeviceState{}
+	state.On = (haState.State == "on")
+
+	if step, ok := haState.Attributes["percentage_step"].(float64); ok && step > 0 {
+		s.mu.Lock()
+		if s.steps == nil {
+			s.steps = make(map[string]float64)
+		}
+		s.steps[vd.EntityID] = step
+		s.mu.Unlock()
+	}
+
+	if pct, ok := haState.Attributes["percentage"].(float64); ok {
+		state.Bri = uint8(math.Round(math.Max(0, math.Min(100, pct)) * 254 / 100))
+		if state.Bri == 0 && pct > 0 {
+			state.Bri = 1
+		}
+	} else if mode, ok := haState.Attributes["preset_mode"].(string); ok && vd.ActionConfig != nil {
+		// Fans running a preset report no percentage, the top of its band round-trips
+		for _, p := range vd.ActionConfig.FanPresets {
+			if p.Mode == mode {
+				state.Bri = p.MaxBri
+				break
+			}
+		}
+	}
+	return state
+}
+
+func (s *FanStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "turn_on"
+	params := make(model.HAFields)
+
+	if !hueState.On {
+		service = "turn_off"
+	} else if hueState.UpdatedByBri {
+		if mode := s.preset(hueState.Bri, vd); mode != "" {
+			service = "set_preset_mode"
+			params["preset_mode"] = mode
+		} else {
+			service = "set_percentage"
+			params["percentage"] = s.percentage(hueState.Bri, vd)
+		}
+	}
+
+	return withActionConfig(service, params, hueState, vd)
+}
+
+func (s *FanStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "Dimmable light",
+		ModelID:          "LWB010",
+		ManufacturerName: "Philips",
+	}
+}
+
+// preset returns the preset mode of the narrowest configured band containing bri
+func (s *FanStrategy) preset(bri uint8, vd *model.VirtualDevice) string {
+	if vd.ActionConfig == nil {
+		return ""
+	}
+	mode, top := "", 256
+	for _, p := range vd.ActionConfig.FanPresets {
+		if bri <= p.MaxBri && int(p.MaxBri) < top {
+			mode, top = p.Mode, int(p.MaxBri)
+		}
+	}
+	return mode
+}
+
+// percentage converts bri to a speed the fan supports, a fan that is on never gets 0 as HA would
+// turn it off
+func (s *FanStrategy) percentage(bri uint8, vd *model.VirtualDevice) int {
+	s.mu.RLock()
+	step, ok := s.steps[vd.EntityID]
+	s.mu.RUnlock()
+	if !ok {
+		step = 1
+	}
+	speeds := math.Max(1, math.Round(float64(bri)*100/254/step))
+	return int(math.Round(math.Min(100, speeds*step)))
+}
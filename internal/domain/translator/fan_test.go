@@ -0,0 +1,95 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fanState(state string, attrs model.HAFields) model.HAEntityState {
+	return model.HAEntityState{EntityID: "fan.ceiling", State: state, Attributes: attrs}
+}
+
+func TestFanStrategy_ToHue(t *testing.T) {
+	s := &FanStrategy{}
+	vd := &model.VirtualDevice{EntityID: "fan.ceiling", Type: model.MappingTypeFan}
+
+	hueState := s.ToHue(fanState("on", model.HAFields{"percentage": 50.0}), vd)
+	assert.True(t, hueState.On)
+	assert.Equal(t, uint8(127), hueState.Bri)
+
+	hueState = s.ToHue(fanState("off", model.HAFields{"percentage": 0.0}), vd)
+	assert.False(t, hueState.On)
+	assert.Zero(t, hueState.Bri)
+
+	// The slowest speed is not reported as 0
+	assert.Equal(t, uint8(1), s.ToHue(fanState("on", model.HAFields{"percentage": 0.1}), vd).Bri)
+	assert.Equal(t, uint8(254), s.ToHue(fanState("on", model.HAFields{"percentage": 120.0}), vd).Bri)
+
+	// Presets without a percentage report the top of their band
+	vd.ActionConfig = &model.ActionConfig{FanPresets: []model.FanPreset{{MaxBri: 80, Mode: "sleep"}, {MaxBri: 254, Mode: "turbo"}}}
+	assert.Equal(t, uint8(80), s.ToHue(fanState("on", model.HAFields{"preset_mode": "sleep"}), vd).Bri)
+	assert.Zero(t, s.ToHue(fanState("on", model.HAFields{"preset_mode": "auto"}), vd).Bri)
+}
+
+func TestFanStrategy_ToHA(t *testing.T) {
+	s := &FanStrategy{}
+	vd := &model.VirtualDevice{EntityID: "fan.ceiling", Type: model.MappingTypeFan}
+
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 127, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_percentage", cmd.Service)
+	assert.Equal(t, model.HAFields{"percentage": 50}, cmd.Data)
+
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 127}, vd)
+	assert.Equal(t, "turn_on", cmd.Service)
+	assert.Empty(t, cmd.Data)
+
+	cmd = s.ToHA(&model.DeviceState{On: false, Bri: 127, UpdatedByBri: true}, vd)
+	assert.Equal(t, "turn_off", cmd.Service)
+	assert.Empty(t, cmd.Data)
+
+	// Speeds are snapped to the steps the fan reports, a fan that is on never gets 0
+	s.ToHue(fanState("on", model.HAFields{"percentage": 33.33, "percentage_step": 100.0 / 3}), vd)
+	for bri, want := range map[uint8]int{1: 33, 70: 33, 127: 67, 200: 67, 254: 100} {
+		cmd = s.ToHA(&model.DeviceState{On: true, Bri: bri, UpdatedByBri: true}, vd)
+		assert.Equal(t, want, cmd.Data["percentage"], "bri %d", bri)
+	}
+
+	// Brightness bands select preset modes, above the last band the percentage is used
+	vd.ActionConfig = &model.ActionConfig{FanPresets: []model.FanPreset{{MaxBri: 200, Mode: "auto"}, {MaxBri: 80, Mode: "sleep"}}}
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 50, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_preset_mode", cmd.Service)
+	assert.Equal(t, model.HAFields{"preset_mode": "sleep"}, cmd.Data)
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 150, UpdatedByBri: true}, vd)
+	assert.Equal(t, model.HAFields{"preset_mode": "auto"}, cmd.Data)
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_percentage", cmd.Service)
+	assert.Equal(t, model.HAFields{"percentage": 100}, cmd.Data)
+}
+
+func TestFanStrategy_ActionConfig(t *testing.T) {
+	s := &FanStrategy{}
+	vd := &model.VirtualDevice{
+		EntityID: "fan.ceiling",
+		ActionConfig: &model.ActionConfig{
+			OnService:  "script.fan_on",
+			OnPayload:  model.HAFields{"direction": "forward"},
+			OnEffect:   "on_effect",
+			OffService: "script.fan_off",
+			OffPayload: model.HAFields{"oscillating": false},
+			OffEffect:  "off_effect",
+		},
+	}
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, vd)
+	assert.Equal(t, "script.fan_on", cmd.Service)
+	assert.Equal(t, model.HAFields{"percentage": 100, "direction": "forward"}, cmd.Data)
+	assert.Equal(t, "on_effect", cmd.Effect)
+
+	cmd = s.ToHA(&model.DeviceState{On: false}, vd)
+	assert.Equal(t, "script.fan_off", cmd.Service)
+	assert.Equal(t, model.HAFields{"oscillating": false}, cmd.Data)
+	assert.Equal(t, "off_effect", cmd.Effect)
+
+	assert.Equal(t, "Dimmable light", s.GetMetadata().Type)
+}
@@ -0,0 +1,130 @@
+package translator
+
+import (
+	"fmt"
+	"hue-bridge-emulator/internal/domain/model"
+	"math"
+	"strconv"
+
+	"github.com/Knetic/govaluate"
+)
+
+// formulaFunctions can be called from every formula
+var formulaFunctions = map[string]govaluate.ExpressionFunction{
+	// clamp(v, min, max)
+	"clamp": func(args ...interface{}) (interface{}, error) {
+		v, err := formulaArgs("clamp", args, 3)
+		if err != nil {
+			return nil, err
+		}
+		return math.Max(v[1], math.Min(v[2], v[0])), nil
+	},
+	// round(v) or round(v, digits)
+	"round": func(args ...interface{}) (interface{}, error) {
+		if len(args) == 2 {
+			v, err := formulaArgs("round", args, 2)
+			if err != nil {
+				return nil, err
+			}
+			p := math.Pow(10, math.Round(v[1]))
+			return math.Round(v[0]*p) / p, nil
+		}
+		v, err := formulaArgs("round", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return math.Round(v[0]), nil
+	},
+	"min": func(args ...interface{}) (interface{}, error) {
+		v, err := formulaArgs("min", args, -1)
+		if err != nil {
+			return nil, err
+		}
+		res := v[0]
+		for _, f := range v[1:] {
+			res = math.Min(res, f)
+		}
+		return res, nil
+	},
+	"max": func(args ...interface{}) (interface{}, error) {
+		v, err := formulaArgs("max", args, -1)
+		if err != nil {
+			return nil, err
+		}
+		res := v[0]
+		for _, f := range v[1:] {
+			res = math.Max(res, f)
+		}
+		return res, nil
+	},
+	// map(x, a, b, c, d) maps x linearly from a..b to c..d
+	"map": func(args ...interface{}) (interface{}, error) {
+		v, err := formulaArgs("map", args, 5)
+		if err != nil {
+			return nil, err
+		}
+		if v[1] == v[2] {
+			return nil, fmt.Errorf("map: empty input range")
+		}
+		return v[3] + (v[0]-v[1])*(v[4]-v[3])/(v[2]-v[1]), nil
+	},
+}
+
+// formulaArgs checks that a function got n numbers, at least one when n is negative
+func formulaArgs(name string, args []interface{}, n int) ([]float64, error) {
+	if (n >= 0 && len(args) != n) || (n < 0 && len(args) == 0) {
+		return nil, fmt.Errorf("%s: wrong number of arguments (%d)", name, len(args))
+	}
+	res := make([]float64, len(args))
+	for i, a := range args {
+		f, ok := a.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s: argument %d is not a number", name, i+1)
+		}
+		res[i] = f
+	}
+	return res, nil
+}
+
+// ValidateFormula reports the syntax errors of a formula, an empty formula is valid
+func ValidateFormula(formula string) error {
+	if formula == "" {
+		return nil
+	}
+	_, err := govaluate.NewEvaluableExpressionWithFunctions(formula, formulaFunctions)
+	return err
+}
+
+// evaluateFormula computes formula with the variables vars, the result must be a number
+func evaluateFormula(formula string, vars map[string]interface{}) (float64, error) {
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(formula, formulaFunctions)
+	if err != nil {
+		return 0, err
+	}
+	result, err := expression.Evaluate(vars)
+	if err != nil {
+		return 0, err
+	}
+	val, ok := result.(float64)
+	if !ok {
+		return 0, fmt.Errorf("result %v is not a number", result)
+	}
+	return val, nil
+}
+
+// formulaVariables exposes the state of an entity to formulas: its state, as a number when it is
+// one, and its numeric attributes by name
+func formulaVariables(haState model.HAEntityState) map[string]interface{} {
+	vars := make(map[string]interface{}, len(haState.Attributes)+1)
+	for k, v := range haState.Attributes {
+		if f, ok := v.(float64); ok {
+			vars[k] = f
+		}
+	}
+	if f, err := strconv.ParseFloat(haState.State, 64); err == nil {
+		vars["state"] = f
+	} else {
+		vars["state"] = haState.State
+	}
+	return vars
+}
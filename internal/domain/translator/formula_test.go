@@ -0,0 +1,87 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateFormula_Functions(t *testing.T) {
+	tests := []struct {
+		formula  string
+		expected float64
+	}{
+		{"clamp(x, 0, 100)", 100},
+		{"clamp(x, 200, 254)", 200},
+		{"round(x / 3)", 50},
+		{"round(x / 7, 2)", 21.43},
+		{"min(x, 254, 10)", 10},
+		{"max(x, 254)", 254},
+		{"map(x, 0, 300, 0, 100)", 50},
+		{"map(state, 16, 24, 0, 254)", 127},
+		{"current_position * 2.54", 127},
+		{"hvac_action == 'heating' ? 254 : 0", 0},
+	}
+	vars := map[string]interface{}{"x": 150.0, "state": 20.0, "current_position": 50.0}
+	for _, tt := range tests {
+		t.Run(tt.formula, func(t *testing.T) {
+			got, err := evaluateFormula(tt.formula, withVar(vars, "hvac_action", "idle"))
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.expected, got, 0.001)
+		})
+	}
+
+	for _, formula := range []string{"clamp(x, 1)", "map(x, 1, 1, 0, 10)", "min()", "round('a')", "y + 1", "x > 1"} {
+		_, err := evaluateFormula(formula, vars)
+		assert.Error(t, err, formula)
+	}
+}
+
+func withVar(vars map[string]interface{}, k string, v interface{}) map[string]interface{} {
+	res := map[string]interface{}{k: v}
+	for key, val := range vars {
+		res[key] = val
+	}
+	return res
+}
+
+func TestValidateFormula(t *testing.T) {
+	assert.NoError(t, ValidateFormula(""))
+	assert.NoError(t, ValidateFormula("map(x, 0, 254, 7, 28)"))
+	assert.Error(t, ValidateFormula("x * (2"))
+	assert.Error(t, ValidateFormula("unknown(x)"))
+
+	s := &CustomStrategy{}
+	assert.NoError(t, s.Validate(&model.VirtualDevice{}))
+	err := s.Validate(&model.VirtualDevice{ActionConfig: &model.ActionConfig{ToHueFormula: "x", ToHAFormula: "x +"}})
+	assert.ErrorContains(t, err, "to_ha_formula")
+}
+
+func TestCustomStrategy_FormulaVariables(t *testing.T) {
+	s := &CustomStrategy{}
+	vd := &model.VirtualDevice{
+		EntityID: "sensor.pool",
+		Type:     model.MappingTypeCustom,
+		ActionConfig: &model.ActionConfig{
+			ToHueFormula: "map(state, 20, 30, 0, 254)",
+			ToHAFormula:  "x / 254 * target_max",
+		},
+	}
+
+	haState := model.HAEntityState{EntityID: "sensor.pool", State: "25", Attributes: model.HAFields{"target_max": 40.0, "unit": "°C"}}
+	assert.Equal(t, uint8(127), s.ToHue(haState, vd).Bri)
+
+	// Attributes learnt from HA are available to commands
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 127}, vd)
+	assert.InDelta(t, 20.0, cmd.Data["value"], 0.001)
+
+	// Results are clamped to the Hue range instead of wrapping around
+	haState.State = "40"
+	assert.Equal(t, uint8(254), s.ToHue(haState, vd).Bri)
+	haState.State = "10"
+	assert.Equal(t, uint8(0), s.ToHue(haState, vd).Bri)
+	vd.ActionConfig.ToHueFormula = ""
+	haState.Attributes["brightness"] = 255.0
+	assert.Equal(t, uint8(254), s.ToHue(haState, vd).Bri)
+}
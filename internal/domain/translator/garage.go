@@ -0,0 +1,37 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+)
+
+// GarageStrategy reports an open garage door cover as on
+type GarageStrategy struct{}
+
+func (s *GarageStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := &model.DeviceState{}
+	state.On = (haState.State == "open" || haState.State == "opening")
+	return state
+}
+
+func (s *GarageStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "open_cover"
+	if !hueState.On {
+		service = "close_cover"
+	}
+	return withActionConfig(service, make(model.HAFields), hueState, vd)
+}
+
+func (s *GarageStrategy) Rejects(hueState *model.DeviceState, vd *model.VirtualDevice) string {
+	if hueState.On && !allowsVoiceUnlock(vd) {
+		return "opening by voice is not allowed"
+	}
+	return ""
+}
+
+func (s *GarageStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "On/Off plug-in unit",
+		ModelID:          "LOM001",
+		ManufacturerName: "Philips",
+	}
+}
@@ -2,6 +2,7 @@ package translator
 
 import (
 	"hue-bridge-emulator/internal/domain/model"
+	"math"
 	"strings"
 )
 
@@ -13,11 +14,42 @@ func (s *LightStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevi
 	if bri, ok := haState.Attributes["brightness"].(float64); ok {
 		state.Bri = uint8(bri)
 	}
-	state.Reachable = true
+
+	// Colour is reported in every representation HA provides, colormode tells which one is active
+	if hs, ok := floatPair(haState.Attributes["hs_color"]); ok {
+		state.Hue = uint16(math.Round(hs[0] / 360 * 65535))
+		state.Sat = uint8(math.Round(hs[1] / 100 * 254))
+	}
+	if xy, ok := floatPair(haState.Attributes["xy_color"]); ok {
+		state.Xy = []float32{float32(xy[0]), float32(xy[1])}
+	}
+	if mireds, ok := haState.Attributes["color_temp"].(float64); ok && mireds > 0 {
+		state.Ct = uint16(math.Round(mireds))
+	} else if kelvin, ok := haState.Attributes["color_temp_kelvin"].(float64); ok && kelvin > 0 {
+		state.Ct = uint16(math.Round(1e6 / kelvin))
+	}
+	switch haState.Attributes["color_mode"] {
+	case "color_temp":
+		state.ColorMode = "ct"
+	case "hs":
+		state.ColorMode = "hs"
+	case "xy", "rgb", "rgbw", "rgbww":
+		state.ColorMode = "xy"
+	}
+	if effect, ok := haState.Attributes["effect"].(string); ok && effect == "colorloop" {
+		state.Effect = effect
+	}
+
 	return state
 }
 
 func (s *LightStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service, params := s.params(hueState, vd)
+	return withActionConfig(service, params, hueState, vd)
+}
+
+// params returns the service and data for hueState before the action config is applied
+func (s *LightStrategy) params(hueState *model.DeviceState, vd *model.VirtualDevice) (string, model.HAFields) {
 	service := "turn_on"
 	params := make(model.HAFields)
 
@@ -30,39 +62,42 @@ func (s *LightStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevic
 
 	if !hueState.On {
 		service = "turn_off"
-	} else {
+	} else if domain == "light" {
 		// Only include brightness for light domain or if explicitly on
-		if domain == "light" && hueState.Bri > 0 {
+		if hueState.Bri > 0 {
 			params["brightness"] = hueState.Bri
 		}
-	}
-
-	var effect string
-	if vd.ActionConfig != nil {
-		if hueState.On {
-			if vd.ActionConfig.OnService != "" {
-				service = vd.ActionConfig.OnService
-			}
-			effect = vd.ActionConfig.OnEffect
-			for k, v := range vd.ActionConfig.OnPayload {
-				params[k] = v
+		// Hue gives xy precedence over ct, and ct over hue/sat
+		if hueState.UpdatedByXy && len(hueState.Xy) == 2 {
+			params["xy_color"] = []float64{float64(hueState.Xy[0]), float64(hueState.Xy[1])}
+		} else if hueState.UpdatedByCt && hueState.Ct > 0 {
+			params["color_temp_kelvin"] = int(math.Round(1e6 / float64(hueState.Ct)))
+		} else if hueState.UpdatedByHue || hueState.UpdatedBySat {
+			params["hs_color"] = []float64{
+				math.Round(float64(hueState.Hue)/65535*360*100) / 100,
+				math.Round(float64(hueState.Sat)/254*100*100) / 100,
 			}
-		} else {
-			if vd.ActionConfig.OffService != "" {
-				service = vd.ActionConfig.OffService
-			}
-			effect = vd.ActionConfig.OffEffect
-			for k, v := range vd.ActionConfig.OffPayload {
-				params[k] = v
+		}
+		switch hueState.Alert {
+		case "select":
+			params["flash"] = "short"
+		case "lselect":
+			params["flash"] = "long"
+		}
+		if hueState.UpdatedByEffect {
+			switch hueState.Effect {
+			case "colorloop":
+				params["effect"] = "colorloop"
+			case "none":
+				params["effect"] = "off"
 			}
 		}
 	}
 
-	return model.HomeAssistantCommand{
-		Service: service,
-		Data:    params,
-		Effect:  effect,
+	if domain == "light" && hueState.TransitionTime != nil {
+		params["transition"] = float64(*hueState.TransitionTime) / 10
 	}
+	return service, params
 }
 
 func (s *LightStrategy) GetMetadata() model.HueMetadata {
@@ -72,3 +107,14 @@ func (s *LightStrategy) GetMetadata() model.HueMetadata {
 		ManufacturerName: "Philips",
 	}
 }
+
+// floatPair reads a two-element numeric HA attribute such as hs_color or xy_color
+func floatPair(v any) ([2]float64, bool) {
+	list, ok := v.([]any)
+	if !ok || len(list) != 2 {
+		return [2]float64{}, false
+	}
+	a, okA := list[0].(float64)
+	b, okB := list[1].(float64)
+	return [2]float64{a, b}, okA && okB
+}
@@ -0,0 +1,41 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+)
+
+// LockStrategy reports a locked lock as on, turning it off unlocks it
+type LockStrategy struct{}
+
+func (s *LockStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := &model.DeviceState{}
+	state.On = (haState.State == "locked" || haState.State == "locking")
+	return state
+}
+
+func (s *LockStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "lock"
+	if !hueState.On {
+		service = "unlock"
+	}
+	return withActionConfig(service, make(model.HAFields), hueState, vd)
+}
+
+func (s *LockStrategy) Rejects(hueState *model.DeviceState, vd *model.VirtualDevice) string {
+	if !hueState.On && !allowsVoiceUnlock(vd) {
+		return "unlocking by voice is not allowed"
+	}
+	return ""
+}
+
+func (s *LockStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "On/Off plug-in unit",
+		ModelID:          "LOM001",
+		ManufacturerName: "Philips",
+	}
+}
+
+func allowsVoiceUnlock(vd *model.VirtualDevice) bool {
+	return vd.ActionConfig != nil && vd.ActionConfig.AllowVoiceUnlock
+}
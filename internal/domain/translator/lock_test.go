@@ -0,0 +1,66 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockStrategy(t *testing.T) {
+	s := &LockStrategy{}
+	vd := &model.VirtualDevice{EntityID: "lock.front_door", Type: model.MappingTypeLock}
+
+	assert.True(t, s.ToHue(model.HAEntityState{State: "locked"}, vd).On)
+	assert.True(t, s.ToHue(model.HAEntityState{State: "locking"}, vd).On)
+	assert.False(t, s.ToHue(model.HAEntityState{State: "unlocked"}, vd).On)
+	assert.False(t, s.ToHue(model.HAEntityState{State: "jammed"}, vd).On)
+
+	assert.Equal(t, "lock", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+	assert.Equal(t, "unlock", s.ToHA(&model.DeviceState{On: false}, vd).Service)
+
+	// Locking is always allowed, unlocking only when enabled
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: true}, vd))
+	assert.NotEmpty(t, s.Rejects(&model.DeviceState{On: false}, vd))
+	vd.ActionConfig = &model.ActionConfig{AllowVoiceUnlock: true}
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: false}, vd))
+}
+
+func TestValveStrategy(t *testing.T) {
+	s := &ValveStrategy{}
+	vd := &model.VirtualDevice{EntityID: "valve.garden", Type: model.MappingTypeValve}
+
+	hueState := s.ToHue(model.HAEntityState{State: "open", Attributes: model.HAFields{"current_position": 50.0}}, vd)
+	assert.True(t, hueState.On)
+	assert.Equal(t, uint8(127), hueState.Bri)
+	assert.False(t, s.ToHue(model.HAEntityState{State: "closed"}, vd).On)
+
+	assert.Equal(t, "open_valve", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+	assert.Equal(t, "close_valve", s.ToHA(&model.DeviceState{On: false}, vd).Service)
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 127, UpdatedByBri: true}, vd)
+	assert.Equal(t, "set_valve_position", cmd.Service)
+	assert.Equal(t, 50, cmd.Data["position"])
+
+	// Setting a position opens the valve
+	assert.NotEmpty(t, s.Rejects(&model.DeviceState{On: true, Bri: 127, UpdatedByBri: true}, vd))
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: false}, vd))
+	vd.ActionConfig = &model.ActionConfig{AllowVoiceUnlock: true}
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: true}, vd))
+}
+
+func TestGarageStrategy(t *testing.T) {
+	s := &GarageStrategy{}
+	vd := &model.VirtualDevice{EntityID: "cover.garage_door", Type: model.MappingTypeGarage}
+
+	assert.True(t, s.ToHue(model.HAEntityState{State: "opening"}, vd).On)
+	assert.False(t, s.ToHue(model.HAEntityState{State: "closed"}, vd).On)
+
+	assert.Equal(t, "open_cover", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+	assert.Equal(t, "close_cover", s.ToHA(&model.DeviceState{On: false}, vd).Service)
+
+	assert.NotEmpty(t, s.Rejects(&model.DeviceState{On: true}, vd))
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: false}, vd))
+	vd.ActionConfig = &model.ActionConfig{AllowVoiceUnlock: true, OnService: "toggle"}
+	assert.Empty(t, s.Rejects(&model.DeviceState{On: true}, vd))
+	assert.Equal(t, "toggle", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+}
@@ -0,0 +1,87 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"math"
+	"sync"
+)
+
+// MediaPlayerStrategy maps Hue brightness to the volume of a media player
+type MediaPlayerStrategy struct {
+	mu      sync.RWMutex
+	powered map[string]bool // whether the player is powered on by entity ID, learnt from the states HA reports
+}
+
+func (s *MediaPlayerStrategy) Blank() Translator {
+	return &MediaPlayerStrategy{}
+}
+
+func (s *MediaPlayerStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := &model.DeviceState{}
+	ac := vd.ActionConfig
+	var powered bool
+	switch haState.State {
+	case "off", "standby", model.HAStateUnavailable, model.HAStateUnknown:
+	default:
+		powered = true
+	}
+	s.mu.Lock()
+	if s.powered == nil {
+		s.powered = make(map[string]bool)
+	}
+	s.powered[vd.EntityID] = powered
+	s.mu.Unlock()
+
+	if ac != nil && ac.MediaPlayback {
+		state.On = (haState.State == "playing")
+	} else {
+		state.On = powered
+	}
+	if muted, _ := haState.Attributes["is_volume_muted"].(bool); muted && ac != nil && ac.MutedAsOff {
+		state.On = false
+	}
+	if volume, ok := haState.Attributes["volume_level"].(float64); ok {
+		state.Bri = uint8(math.Round(math.Max(0, math.Min(1, volume)) * 254))
+	}
+	return state
+}
+
+func (s *MediaPlayerStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "turn_on"
+	params := make(model.HAFields)
+	ac := vd.ActionConfig
+
+	switch {
+	case hueState.On && hueState.UpdatedByBri:
+		service = "volume_set"
+		params["volume_level"] = math.Round(float64(hueState.Bri)/254*100) / 100
+	case ac != nil && ac.MutedAsOff && hueState.On && !s.isPowered(vd):
+		// A player that is off or in standby is powered on rather than unmuted
+	case ac != nil && ac.MutedAsOff:
+		service = "volume_mute"
+		params["is_volume_muted"] = !hueState.On
+	case ac != nil && ac.MediaPlayback && hueState.On:
+		service = "media_play"
+	case ac != nil && ac.MediaPlayback:
+		service = "media_pause"
+	case !hueState.On:
+		service = "turn_off"
+	}
+
+	return withActionConfig(service, params, hueState, vd)
+}
+
+// isPowered tells whether HA last reported the player on, players not seen yet count as off
+func (s *MediaPlayerStrategy) isPowered(vd *model.VirtualDevice) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.powered[vd.EntityID]
+}
+
+func (s *MediaPlayerStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "Dimmable light",
+		ModelID:          "LWB010",
+		ManufacturerName: "Philips",
+	}
+}
@@ -0,0 +1,98 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mediaState(state string, attrs model.HAFields) model.HAEntityState {
+	return model.HAEntityState{EntityID: "media_player.tv", State: state, Attributes: attrs}
+}
+
+func TestMediaPlayerStrategy_ToHue(t *testing.T) {
+	s := &MediaPlayerStrategy{}
+	vd := &model.VirtualDevice{EntityID: "media_player.tv", Type: model.MappingTypeMedia}
+
+	hueState := s.ToHue(mediaState("idle", model.HAFields{"volume_level": 0.3}), vd)
+	assert.True(t, hueState.On)
+	assert.Equal(t, uint8(76), hueState.Bri)
+	for _, state := range []string{"off", "standby"} {
+		assert.False(t, s.ToHue(mediaState(state, nil), vd).On, state)
+	}
+	assert.Equal(t, uint8(254), s.ToHue(mediaState("on", model.HAFields{"volume_level": 1.5}), vd).Bri)
+
+	// Muted players stay on unless configured otherwise
+	muted := mediaState("playing", model.HAFields{"volume_level": 0.5, "is_volume_muted": true})
+	assert.True(t, s.ToHue(muted, vd).On)
+	vd.ActionConfig = &model.ActionConfig{MutedAsOff: true}
+	hueState = s.ToHue(muted, vd)
+	assert.False(t, hueState.On)
+	assert.Equal(t, uint8(127), hueState.Bri)
+
+	// With playback control only a playing player is on
+	vd.ActionConfig = &model.ActionConfig{MediaPlayback: true}
+	assert.True(t, s.ToHue(mediaState("playing", nil), vd).On)
+	assert.False(t, s.ToHue(mediaState("paused", nil), vd).On)
+}
+
+func TestMediaPlayerStrategy_ToHA(t *testing.T) {
+	s := &MediaPlayerStrategy{}
+	playback := &model.ActionConfig{MediaPlayback: true}
+	mute := &model.ActionConfig{MutedAsOff: true}
+
+	tests := []struct {
+		name    string
+		ac      *model.ActionConfig
+		state   *model.DeviceState
+		service string
+		data    model.HAFields
+	}{
+		{"on", nil, &model.DeviceState{On: true}, "turn_on", model.HAFields{}},
+		{"off", nil, &model.DeviceState{On: false}, "turn_off", model.HAFields{}},
+		{"volume", nil, &model.DeviceState{On: true, Bri: 76, UpdatedByBri: true}, "volume_set", model.HAFields{"volume_level": 0.3}},
+		{"off ignores volume", nil, &model.DeviceState{On: false, Bri: 76, UpdatedByBri: true}, "turn_off", model.HAFields{}},
+		{"play", playback, &model.DeviceState{On: true}, "media_play", model.HAFields{}},
+		{"pause", playback, &model.DeviceState{On: false}, "media_pause", model.HAFields{}},
+		{"mute", mute, &model.DeviceState{On: false}, "volume_mute", model.HAFields{"is_volume_muted": true}},
+		{"volume while muted as off", mute, &model.DeviceState{On: true, Bri: 254, UpdatedByBri: true}, "volume_set", model.HAFields{"volume_level": 1.0}},
+		{"custom on", &model.ActionConfig{OnService: "script.tv_on", OnPayload: model.HAFields{"source": "HDMI1"}}, &model.DeviceState{On: true}, "script.tv_on", model.HAFields{"source": "HDMI1"}},
+		{"custom off", &model.ActionConfig{OffService: "script.tv_off", OffPayload: model.HAFields{"delay": 5}}, &model.DeviceState{On: false}, "script.tv_off", model.HAFields{"delay": 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := s.ToHA(tt.state, &model.VirtualDevice{EntityID: "media_player.tv", ActionConfig: tt.ac})
+			assert.Equal(t, tt.service, cmd.Service)
+			assert.Equal(t, tt.data, cmd.Data)
+		})
+	}
+
+	cmd := s.ToHA(&model.DeviceState{On: false}, &model.VirtualDevice{ActionConfig: &model.ActionConfig{OffEffect: "fade"}})
+	assert.Equal(t, "fade", cmd.Effect)
+	assert.Equal(t, "Dimmable light", s.GetMetadata().Type)
+}
+
+func TestMediaPlayerStrategy_MutedAsOffPower(t *testing.T) {
+	s := &MediaPlayerStrategy{}
+	vd := &model.VirtualDevice{EntityID: "media_player.tv", ActionConfig: &model.ActionConfig{MutedAsOff: true}}
+	on := &model.DeviceState{On: true}
+
+	// Not seen yet, the player is powered on
+	assert.Equal(t, "turn_on", s.ToHA(on, vd).Service)
+
+	for _, state := range []string{"off", "standby"} {
+		s.ToHue(model.HAEntityState{State: state}, vd)
+		cmd := s.ToHA(on, vd)
+		assert.Equal(t, "turn_on", cmd.Service, state)
+		assert.Equal(t, model.HAFields{}, cmd.Data, state)
+	}
+
+	// Powered on and muted, turning on unmutes
+	s.ToHue(model.HAEntityState{State: "idle", Attributes: model.HAFields{"is_volume_muted": true}}, vd)
+	cmd := s.ToHA(on, vd)
+	assert.Equal(t, "volume_mute", cmd.Service)
+	assert.Equal(t, model.HAFields{"is_volume_muted": false}, cmd.Data)
+
+	assert.Equal(t, "volume_mute", s.ToHA(&model.DeviceState{On: false}, vd).Service)
+}
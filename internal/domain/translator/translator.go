@@ -2,14 +2,81 @@ package translator
 
 import (
 	"hue-bridge-emulator/internal/domain/model"
+	"time"
 )
 
 type Translator interface {
+	// ToHue translates an HA state, reachability is left to the bridge service
 	ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState
 	ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand
 	GetMetadata() model.HueMetadata
 }
 
+// EntityMetadataProvider is implemented by translators whose Hue metadata depends on the
+// capabilities of the entity rather than on the mapping type alone
+type EntityMetadataProvider interface {
+	GetEntityMetadata(vd *model.VirtualDevice) model.HueMetadata
+}
+
+// CommandGuard is implemented by translators of devices for which some commands are unsafe by
+// voice, such as unlocking a door
+type CommandGuard interface {
+	// Rejects returns why the command must not be sent, or an empty string
+	Rejects(hueState *model.DeviceState, vd *model.VirtualDevice) string
+}
+
+// MomentaryTranslator is implemented by translators of stateless entities, which are triggered
+// rather than switched and never report the requested state
+type MomentaryTranslator interface {
+	// Ignores reports whether no command is sent for the state
+	Ignores(hueState *model.DeviceState, vd *model.VirtualDevice) bool
+	// ResetDelay returns how long a triggered device is reported on
+	ResetDelay(vd *model.VirtualDevice) time.Duration
+}
+
+// ConfigValidator is implemented by translators that can reject a device configuration before it
+// is saved
+type ConfigValidator interface {
+	Validate(vd *model.VirtualDevice) error
+}
+
+// StatefulTranslator is implemented by translators that learn the capabilities of an entity from
+// the states they translate
+type StatefulTranslator interface {
+	// Blank returns a translator of the same kind that has learnt nothing yet
+	Blank() Translator
+}
+
 type TranslatorFactory interface {
 	GetTranslator(mappingType model.MappingType) Translator
 }
+
+// withActionConfig applies the custom services and payloads of the action config to a command
+func withActionConfig(service string, params model.HAFields, hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	var effect string
+	if vd.ActionConfig != nil {
+		if hueState.On {
+			if vd.ActionConfig.OnService != "" {
+				service = vd.ActionConfig.OnService
+			}
+			effect = vd.ActionConfig.OnEffect
+			for k, v := range vd.ActionConfig.OnPayload {
+				params[k] = v
+			}
+		} else {
+			if vd.ActionConfig.OffService != "" {
+				service = vd.ActionConfig.OffService
+			}
+			effect = vd.ActionConfig.OffEffect
+			for k, v := range vd.ActionConfig.OffPayload {
+				params[k] = v
+			}
+		}
+	}
+
+	return model.HomeAssistantCommand{
+		Service: service,
+		Data:    params,
+		Effect:  effect,
+	}
+}
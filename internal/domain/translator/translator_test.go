@@ -172,6 +172,7 @@ func TestClimateStrategy(t *testing.T) {
 	// Case 5: Hue to HA (On)
 	hueState.Bri = 254
 	hueState.On = true
+	hueState.UpdatedByBri = true
 	cmd := s.ToHA(hueState, vd)
 	haParams := cmd.Data
 	assert.Equal(t, "set_temperature", cmd.Service)
@@ -183,10 +184,23 @@ func TestClimateStrategy(t *testing.T) {
 	hueState.On = false
 	cmd = s.ToHA(hueState, vd)
 	haParams = cmd.Data
-	assert.Equal(t, "set_temperature", cmd.Service)
+	assert.Equal(t, "set_hvac_mode", cmd.Service)
 	assert.Equal(t, "off", haParams["hvac_mode"])
 	assert.Equal(t, "off_eff", cmd.Effect)
 
+	// Case 6b: Hue to HA (On without temperature), the mode of the payload is set
+	hueState.On = true
+	hueState.UpdatedByBri = false
+	cmd = s.ToHA(hueState, vd)
+	assert.Equal(t, "set_hvac_mode", cmd.Service)
+	assert.Equal(t, model.HAFields{"hvac_mode": "heat"}, cmd.Data)
+
+	// Case 6c: Payloads without hvac_mode keep the plain services
+	plain := &model.VirtualDevice{Type: model.MappingTypeClimate, ActionConfig: &model.ActionConfig{OffPayload: model.HAFields{"preset_mode": "away"}}}
+	cmd = s.ToHA(&model.DeviceState{}, plain)
+	assert.Equal(t, "turn_off", cmd.Service)
+	assert.Equal(t, model.HAFields{"preset_mode": "away"}, cmd.Data)
+
 	// Case 7: Custom services
 	vd.ActionConfig.OnService = "climate.custom_on"
 	vd.ActionConfig.OffService = "climate.custom_off"
@@ -262,12 +276,12 @@ func TestCustomStrategy(t *testing.T) {
 
 func TestCustomStrategy_Evaluate(t *testing.T) {
 	s := &CustomStrategy{}
-	assert.Equal(t, 10.0, s.evaluate("x * 2", 5))
-	assert.Equal(t, 5.0, s.evaluate("x / 2", 10))
-	assert.Equal(t, 5.0, s.evaluate("invalid syntax (", 5)) // Parser error
-	assert.Equal(t, 5.0, s.evaluate("x + y", 5))           // Eval error (y missing)
-	assert.Equal(t, 5.0, s.evaluate("1 == 1", 5))         // Bool return
-	assert.Equal(t, 5.0, s.evaluate("'string'", 5))       // String return
+	assert.Equal(t, 10.0, s.evaluate("x * 2", 5, nil))
+	assert.Equal(t, 5.0, s.evaluate("x / 2", 10, nil))
+	assert.Equal(t, 5.0, s.evaluate("invalid syntax (", 5, nil)) // Parser error
+	assert.Equal(t, 5.0, s.evaluate("x + y", 5, nil))           // Eval error (y missing)
+	assert.Equal(t, 5.0, s.evaluate("1 == 1", 5, nil))         // Bool return
+	assert.Equal(t, 5.0, s.evaluate("'string'", 5, nil))       // String return
 }
 
 func TestMetadata(t *testing.T) {
@@ -323,3 +337,101 @@ func TestCustomStrategy_ToHue_Fallback(t *testing.T) {
 	hueState = s.ToHue(haState, vd)
 	assert.Equal(t, uint8(50), hueState.Bri)
 }
+
+func TestLightStrategy_Color(t *testing.T) {
+	s := &LightStrategy{}
+	vd := &model.VirtualDevice{EntityID: "light.kitchen", Type: model.MappingTypeLight}
+
+	// HA to Hue: colour attributes
+	hueState := s.ToHue(model.HAEntityState{
+		State: "on",
+		Attributes: model.HAFields{
+			"brightness": 200.0,
+			"color_mode": "hs",
+			"hs_color":   []any{180.0, 50.0},
+			"xy_color":   []any{0.25, 0.35},
+			"color_temp": 250.0,
+			"effect":     "colorloop",
+		},
+	}, vd)
+	assert.Equal(t, uint16(32768), hueState.Hue)
+	assert.Equal(t, uint8(127), hueState.Sat)
+	assert.Equal(t, []float32{0.25, 0.35}, hueState.Xy)
+	assert.Equal(t, uint16(250), hueState.Ct)
+	assert.Equal(t, "hs", hueState.ColorMode)
+	assert.Equal(t, "colorloop", hueState.Effect)
+
+	// Kelvin-only entities and colour modes
+	hueState = s.ToHue(model.HAEntityState{State: "on", Attributes: model.HAFields{"color_temp_kelvin": 2700.0, "color_mode": "color_temp"}}, vd)
+	assert.Equal(t, uint16(370), hueState.Ct)
+	assert.Equal(t, "ct", hueState.ColorMode)
+	hueState = s.ToHue(model.HAEntityState{State: "on", Attributes: model.HAFields{"color_mode": "rgb", "hs_color": []any{"bad", 1.0}, "xy_color": []any{0.1}}}, vd)
+	assert.Equal(t, "xy", hueState.ColorMode)
+	assert.Nil(t, hueState.Xy)
+	assert.Zero(t, hueState.Hue)
+
+	// Hue to HA: white temperature with transition
+	transition := uint16(15)
+	cmd := s.ToHA(&model.DeviceState{On: true, Bri: 100, Ct: 370, UpdatedByCt: true, TransitionTime: &transition}, vd)
+	assert.Equal(t, "turn_on", cmd.Service)
+	assert.Equal(t, 2703, cmd.Data["color_temp_kelvin"])
+	assert.Equal(t, 1.5, cmd.Data["transition"])
+	assert.NotContains(t, cmd.Data, "hs_color")
+
+	// hue/sat
+	cmd = s.ToHA(&model.DeviceState{On: true, Hue: 65535, Sat: 254, UpdatedByHue: true, UpdatedBySat: true, Alert: "select"}, vd)
+	assert.Equal(t, []float64{360, 100}, cmd.Data["hs_color"])
+	assert.Equal(t, "short", cmd.Data["flash"])
+
+	// xy wins over ct
+	cmd = s.ToHA(&model.DeviceState{On: true, Xy: []float32{0.5, 0.25}, UpdatedByXy: true, Ct: 200, UpdatedByCt: true, Alert: "lselect", Effect: "colorloop", UpdatedByEffect: true}, vd)
+	assert.Equal(t, []float64{0.5, 0.25}, cmd.Data["xy_color"])
+	assert.NotContains(t, cmd.Data, "color_temp_kelvin")
+	assert.Equal(t, "long", cmd.Data["flash"])
+	assert.Equal(t, "colorloop", cmd.Data["effect"])
+
+	// A running effect is only sent with the command setting it, "none" stops it
+	cmd = s.ToHA(&model.DeviceState{On: true, Bri: 100, UpdatedByBri: true, Effect: "colorloop"}, vd)
+	assert.NotContains(t, cmd.Data, "effect")
+	cmd = s.ToHA(&model.DeviceState{On: true, Effect: "none", UpdatedByEffect: true}, vd)
+	assert.Equal(t, "off", cmd.Data["effect"])
+
+	// Transition also applies to turn_off, colour does not
+	cmd = s.ToHA(&model.DeviceState{On: false, Ct: 200, UpdatedByCt: true, TransitionTime: &transition}, vd)
+	assert.Equal(t, "turn_off", cmd.Service)
+	assert.Equal(t, model.HAFields{"transition": 1.5}, cmd.Data)
+
+	// Non-light domains ignore colour
+	cmd = s.ToHA(&model.DeviceState{On: true, Ct: 200, UpdatedByCt: true, TransitionTime: &transition}, &model.VirtualDevice{EntityID: "switch.plug"})
+	assert.Empty(t, cmd.Data)
+}
+
+// Reachability is decided by the bridge service for every strategy, an unavailable entity has no
+// attributes and must still translate
+func TestStrategies_UnavailableEntity(t *testing.T) {
+	strategies := map[string]Translator{
+		"light":   &LightStrategy{},
+		"color":   &ColorLightStrategy{},
+		"cover":   &CoverStrategy{},
+		"climate": &ClimateStrategy{},
+		"custom":  &CustomStrategy{},
+		"fan":     &FanStrategy{},
+		"media":   &MediaPlayerStrategy{},
+		"lock":    &LockStrategy{},
+		"valve":   &ValveStrategy{},
+		"garage":  &GarageStrategy{},
+		"trigger": &TriggerStrategy{},
+	}
+	for name, s := range strategies {
+		t.Run(name, func(t *testing.T) {
+			for _, state := range []string{model.HAStateUnavailable, model.HAStateUnknown} {
+				vd := &model.VirtualDevice{EntityID: name + ".test", ActionConfig: &model.ActionConfig{ToHueFormula: "x * 2"}}
+				hueState := s.ToHue(model.HAEntityState{EntityID: vd.EntityID, State: state}, vd)
+				// Reachability is set by the service, see TestHueUnavailableEntities
+				if assert.NotNil(t, hueState, state) {
+					assert.Zero(t, hueState.Bri, state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"strings"
+	"time"
+)
+
+// DefaultTriggerResetDelay is how long a triggered scene, script or button is reported on
+const DefaultTriggerResetDelay = 5 * time.Second
+
+// TriggerStrategy activates stateless entities (scenes, scripts and buttons) when turned on. They
+// are always reported off, off is ignored unless an off service is configured.
+type TriggerStrategy struct{}
+
+func (s *TriggerStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	return &model.DeviceState{}
+}
+
+func (s *TriggerStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "turn_on"
+	switch strings.Split(vd.EntityID, ".")[0] {
+	case "button", "input_button":
+		service = "press"
+	}
+	if !hueState.On {
+		service = "turn_off"
+	}
+	return withActionConfig(service, make(model.HAFields), hueState, vd)
+}
+
+func (s *TriggerStrategy) Ignores(hueState *model.DeviceState, vd *model.VirtualDevice) bool {
+	return !hueState.On && (vd.ActionConfig == nil || vd.ActionConfig.OffService == "")
+}
+
+func (s *TriggerStrategy) ResetDelay(vd *model.VirtualDevice) time.Duration {
+	if vd.ActionConfig != nil && vd.ActionConfig.TriggerResetDelay > 0 {
+		return time.Duration(vd.ActionConfig.TriggerResetDelay) * time.Second
+	}
+	return DefaultTriggerResetDelay
+}
+
+func (s *TriggerStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "On/Off plug-in unit",
+		ModelID:          "LOM001",
+		ManufacturerName: "Philips",
+	}
+}
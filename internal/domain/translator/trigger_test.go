@@ -0,0 +1,35 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTriggerStrategy(t *testing.T) {
+	s := &TriggerStrategy{}
+	vd := &model.VirtualDevice{EntityID: "scene.movie", Type: model.MappingTypeTrigger}
+
+	// Stateless entities are always reported off
+	assert.False(t, s.ToHue(model.HAEntityState{EntityID: "scene.movie", State: "2026-10-16T21:00:00+00:00"}, vd).On)
+	assert.False(t, s.ToHue(model.HAEntityState{EntityID: "script.bedtime", State: "on"}, vd).On)
+
+	assert.Equal(t, "turn_on", s.ToHA(&model.DeviceState{On: true}, vd).Service)
+	assert.Equal(t, "turn_on", s.ToHA(&model.DeviceState{On: true}, &model.VirtualDevice{EntityID: "script.bedtime"}).Service)
+	assert.Equal(t, "press", s.ToHA(&model.DeviceState{On: true}, &model.VirtualDevice{EntityID: "button.restart"}).Service)
+	assert.Equal(t, "press", s.ToHA(&model.DeviceState{On: true}, &model.VirtualDevice{EntityID: "input_button.doorbell"}).Service)
+
+	// Off is ignored unless mapped
+	assert.False(t, s.Ignores(&model.DeviceState{On: true}, vd))
+	assert.True(t, s.Ignores(&model.DeviceState{On: false}, vd))
+	assert.Equal(t, DefaultTriggerResetDelay, s.ResetDelay(vd))
+
+	vd.ActionConfig = &model.ActionConfig{OffService: "scene.turn_on", OffPayload: map[string]interface{}{"transition": 2}, TriggerResetDelay: 2}
+	assert.False(t, s.Ignores(&model.DeviceState{On: false}, vd))
+	cmd := s.ToHA(&model.DeviceState{On: false}, vd)
+	assert.Equal(t, "scene.turn_on", cmd.Service)
+	assert.Equal(t, 2, cmd.Data["transition"])
+	assert.Equal(t, 2*time.Second, s.ResetDelay(vd))
+}
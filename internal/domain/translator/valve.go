@@ -0,0 +1,46 @@
+package translator
+
+import (
+	"hue-bridge-emulator/internal/domain/model"
+	"math"
+)
+
+// ValveStrategy reports an open valve as on, brightness is the position of valves that report one
+type ValveStrategy struct{}
+
+func (s *ValveStrategy) ToHue(haState model.HAEntityState, vd *model.VirtualDevice) *model.DeviceState {
+	state := &model.DeviceState{}
+	state.On = (haState.State == "open" || haState.State == "opening")
+	if pos, ok := haState.Attributes["current_position"].(float64); ok {
+		state.Bri = uint8(math.Round(math.Max(0, math.Min(100, pos)) * 254 / 100))
+	}
+	return state
+}
+
+func (s *ValveStrategy) ToHA(hueState *model.DeviceState, vd *model.VirtualDevice) model.HomeAssistantCommand {
+	service := "open_valve"
+	params := make(model.HAFields)
+	if !hueState.On {
+		service = "close_valve"
+	} else if hueState.UpdatedByBri {
+		service = "set_valve_position"
+		params["position"] = int(math.Round(float64(hueState.Bri) * 100 / 254))
+	}
+	return withActionConfig(service, params, hueState, vd)
+}
+
+// Rejects opening, which includes setting a position, closing is always allowed
+func (s *ValveStrategy) Rejects(hueState *model.DeviceState, vd *model.VirtualDevice) string {
+	if hueState.On && !allowsVoiceUnlock(vd) {
+		return "opening by voice is not allowed"
+	}
+	return ""
+}
+
+func (s *ValveStrategy) GetMetadata() model.HueMetadata {
+	return model.HueMetadata{
+		Type:             "Dimmable light",
+		ModelID:          "LWB010",
+		ManufacturerName: "Philips",
+	}
+}
@@ -5,6 +5,7 @@ package e2e_test
 import (
 	"encoding/json"
 	"hue-bridge-emulator/internal/domain/model"
+	"io"
 	"net/http"
 	"strings"
 	"testing"
@@ -88,6 +89,87 @@ func TestAdminConfig(t *testing.T) {
 	assert.Equal(t, 1, len(cfg.VirtualDevices))
 }
 
+func TestAdminConfig_InvalidFormula(t *testing.T) {
+	ts := newTestStack(t, nil, nil)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+
+	newCfg := &model.Config{
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Pool", EntityID: "input_number.pool", Type: model.MappingTypeCustom,
+				ActionConfig: &model.ActionConfig{ToHueFormula: "clamp(x * 2"}},
+		},
+	}
+	body, _ := json.Marshal(newCfg)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/admin/config", strings.NewReader(string(body)))
+	req.SetBasicAuth("admin", "password123")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	msg, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(msg), "to_hue_formula")
+	assert.Contains(t, string(msg), "Pool")
+
+	// Formulas of the targets too
+	newCfg.VirtualDevices[0] = &model.VirtualDevice{HueID: "1", Name: "Garden", EntityID: "light.garden", Type: model.MappingTypeLight,
+		Targets: []*model.DeviceTarget{{EntityID: "input_number.pump", Type: model.MappingTypeCustom,
+			ActionConfig: &model.ActionConfig{ToHAFormula: "x *"}}}}
+	body, _ = json.Marshal(newCfg)
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/admin/config", strings.NewReader(string(body)))
+	req.SetBasicAuth("admin", "password123")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	msg, _ = io.ReadAll(resp.Body)
+	assert.Contains(t, string(msg), "to_ha_formula")
+	assert.Contains(t, string(msg), "input_number.pump")
+}
+
+func TestAdminTranslatePreview(t *testing.T) {
+	ha := newFakeHA(t, nil)
+	ts := newTestStack(t, ha, nil)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+
+	preview := func(body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/admin/translate-preview", strings.NewReader(body))
+		req.SetBasicAuth("admin", "password123")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var res map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+	device := `"virtual_device": {"entity_id": "input_number.pool", "type": "custom",
+		"action_config": {"to_hue_formula": "map(x, 20, 30, 0, 254)", "to_ha_formula": "round(map(x, 0, 254, 20, 30), 1)"}}`
+
+	status, res := preview(`{` + device + `, "ha_state": {"state": "25", "attributes": {"value": 25}}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(127), res["state"].(map[string]interface{})["bri"])
+
+	status, res = preview(`{` + device + `, "hue_state": {"bri": 254}}`)
+	assert.Equal(t, http.StatusOK, status)
+	cmd := res["command"].(map[string]interface{})
+	assert.Equal(t, "set_value", cmd["service"])
+	assert.Equal(t, float64(30), cmd["payload"].(map[string]interface{})["value"])
+
+	status, res = preview(`{` + device + `, "sweep": true}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, res["sweep"], 255)
+
+	status, _ = preview(`{` + device + `, "hue_state": {"bri": 300}}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = preview(`{"virtual_device": {"type": "custom", "action_config": {"to_ha_formula": "x +"}}, "sweep": true}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Previews never call HA
+	assert.Zero(t, ha.callCount())
+}
+
 func TestAdminHAEntities(t *testing.T) {
 	ha := newFakeHA(t, []map[string]interface{}{
 		{"entity_id": "light.living_room", "state": "off", "attributes": map[string]interface{}{"friendly_name": "Living Room"}},
@@ -115,3 +197,170 @@ func TestAdminHAEntities(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(entities))
 }
+
+func TestAdminHueUsers(t *testing.T) {
+	ts := newTestStack(t, nil, nil)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+
+	user := registerHueUser(t, ts)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/hue-users", nil)
+	req.SetBasicAuth("admin", "password123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var users []model.HueUser
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+	resp.Body.Close()
+	assert.Len(t, users, 1)
+	assert.Equal(t, user, users[0].Username)
+	assert.Equal(t, "e2e#test", users[0].DeviceType)
+
+	// Revoked users lose access to the Hue API
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/admin/hue-users?username="+user, nil)
+	req.SetBasicAuth("admin", "password123")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hueErrorType(t, resp))
+
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/admin/hue-users?username="+user, nil)
+	req.SetBasicAuth("admin", "password123")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdminLinkButton(t *testing.T) {
+	ts := newTestStack(t, nil, nil)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+
+	linkButton := func(method string) map[string]interface{} {
+		req, _ := http.NewRequest(method, ts.URL+"/admin/link-button", nil)
+		req.SetBasicAuth("admin", "password123")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var res map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res
+	}
+
+	// Pairing is refused until the button is pressed
+	resp, err := http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{"devicetype":"Echo"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 101, hueErrorType(t, resp))
+	assert.Equal(t, false, linkButton(http.MethodGet)["linkbutton"])
+
+	pressed := linkButton(http.MethodPost)
+	assert.Equal(t, true, pressed["linkbutton"])
+	assert.NotEmpty(t, pressed["until"])
+
+	resp, err = http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{"devicetype":"Echo"}`))
+	assert.NoError(t, err)
+	var result []map[string]map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	user := result[0]["success"]["username"]
+	assert.NotEmpty(t, user)
+
+	// The window state is visible to Hue clients
+	resp, err = http.Get(ts.URL + "/api/" + user + "/config")
+	assert.NoError(t, err)
+	var config map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+	resp.Body.Close()
+	assert.Equal(t, true, config["linkbutton"])
+}
+
+func TestAdminCommands(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.sofa", "state": "off", "attributes": map[string]interface{}{}},
+		{"entity_id": "light.desk", "state": "off", "attributes": map[string]interface{}{}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Sofa", EntityID: "light.sofa", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+	user := registerHueUser(t, ts)
+
+	commands := func(query string) []model.CommandRecord {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/commands"+query, nil)
+		req.SetBasicAuth("admin", "password123")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		var records []model.CommandRecord
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&records))
+		return records
+	}
+	turnOn := func(id string) {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/"+id+"/state", strings.NewReader(`{"on":true}`))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Devices are loaded when Alexa lists them
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	turnOn("1")
+	assert.Eventually(t, func() bool { return len(commands("")) == 1 }, time.Second, 10*time.Millisecond)
+
+	ha.mu.Lock()
+	ha.failWith = http.StatusInternalServerError
+	ha.mu.Unlock()
+	turnOn("2")
+	assert.Eventually(t, func() bool { return len(commands("")) == 2 }, time.Second, 10*time.Millisecond)
+
+	failed := commands("?status=failed")
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "Desk", failed[0].DeviceName)
+		assert.Equal(t, "turn_on", failed[0].Service)
+		assert.Equal(t, http.StatusInternalServerError, failed[0].HTTPStatus)
+		assert.Equal(t, "HA API error: 500", failed[0].Error)
+	}
+	ok := commands("?device=light.sofa")
+	if assert.Len(t, ok, 1) {
+		assert.Equal(t, model.CommandSucceeded, ok[0].Status)
+		assert.Equal(t, http.StatusOK, ok[0].HTTPStatus)
+		assert.Equal(t, "Sofa", ok[0].DeviceName)
+	}
+	assert.Empty(t, commands("?device=1&status=failed"))
+
+	// The failed command is rolled back, the desk is reported off again
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(ts.URL + "/api/" + user + "/lights/2")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		var light struct {
+			State struct {
+				On bool `json:"on"`
+			} `json:"state"`
+		}
+		return json.NewDecoder(resp.Body).Decode(&light) == nil && !light.State.On
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, commands("?limit=1"), 1)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/commands?status=pending", nil)
+	req.SetBasicAuth("admin", "password123")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
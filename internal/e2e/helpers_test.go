@@ -19,14 +19,18 @@ import (
 	"strings"
 	"sync"
 	"testing"
+
+	"github.com/gorilla/websocket"
 )
 
-// fakeHA simulates the Home Assistant REST API.
+// fakeHA simulates the Home Assistant REST and WebSocket APIs.
 type fakeHA struct {
-	mu     sync.Mutex
-	states []map[string]interface{} // returned by GET /api/states
-	calls  []haServiceCall          // recorded by POST /api/services/...
-	server *httptest.Server
+	mu          sync.Mutex
+	states      []map[string]interface{} // returned by GET /api/states
+	calls       []haServiceCall          // recorded by POST /api/services/...
+	subscribers []*websocket.Conn        // subscribed to state_changed over /api/websocket
+	failWith    int                      // status answered to service calls when set
+	server      *httptest.Server
 }
 
 type haServiceCall struct {
@@ -61,15 +65,143 @@ func newFakeHA(t *testing.T, states []map[string]interface{}) *fakeHA {
 		f.calls = append(f.calls, haServiceCall{
 			Domain: parts[0], Service: parts[1], Payload: payload,
 		})
+		failWith := f.failWith
 		f.mu.Unlock()
+		if failWith != 0 {
+			w.WriteHeader(failWith)
+			return
+		}
+		f.applyServiceCall(parts[1], payload)
 		w.WriteHeader(http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/websocket", f.handleWebSocket)
+
 	f.server = httptest.NewServer(mux)
 	t.Cleanup(f.server.Close)
 	return f
 }
 
+// handleWebSocket implements the subset of the HA WebSocket protocol used by the bridge.
+func (f *fakeHA) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer f.unsubscribe(conn)
+
+	conn.WriteJSON(map[string]interface{}{"type": "auth_required"})
+	var auth map[string]interface{}
+	if err := conn.ReadJSON(&auth); err != nil {
+		return
+	}
+	if auth["access_token"] != "test-token" {
+		conn.WriteJSON(map[string]interface{}{"type": "auth_invalid", "message": "Invalid access token"})
+		return
+	}
+	conn.WriteJSON(map[string]interface{}{"type": "auth_ok"})
+
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		f.mu.Lock()
+		switch msg["type"] {
+		case "subscribe_events":
+			f.subscribers = append(f.subscribers, conn)
+			conn.WriteJSON(map[string]interface{}{"id": msg["id"], "type": "result", "success": true})
+		case "get_states":
+			conn.WriteJSON(map[string]interface{}{"id": msg["id"], "type": "result", "success": true, "result": f.states})
+		case "ping":
+			conn.WriteJSON(map[string]interface{}{"id": msg["id"], "type": "pong"})
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakeHA) unsubscribe(conn *websocket.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.subscribers {
+		if c == conn {
+			f.subscribers = append(f.subscribers[:i], f.subscribers[i+1:]...)
+			break
+		}
+	}
+	conn.Close()
+}
+
+// pushStateChange updates an entity and broadcasts a state_changed event to subscribers.
+func (f *fakeHA) pushStateChange(entityID, state string, attributes map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	newState := map[string]interface{}{"entity_id": entityID, "state": state, "attributes": attributes}
+	replaced := false
+	for i, s := range f.states {
+		if s["entity_id"] == entityID {
+			f.states[i] = newState
+			replaced = true
+		}
+	}
+	if !replaced {
+		f.states = append(f.states, newState)
+	}
+	for _, conn := range f.subscribers {
+		conn.WriteJSON(map[string]interface{}{
+			"id":   1,
+			"type": "event",
+			"event": map[string]interface{}{
+				"event_type": "state_changed",
+				"data":       map[string]interface{}{"entity_id": entityID, "new_state": newState},
+			},
+		})
+	}
+}
+
+// applyServiceCall mirrors turn_on/turn_off calls into the entity state like HA does.
+func (f *fakeHA) applyServiceCall(service string, payload map[string]interface{}) {
+	entityID, _ := payload["entity_id"].(string)
+	if entityID == "" || (service != "turn_on" && service != "turn_off") {
+		return
+	}
+
+	f.mu.Lock()
+	attributes := map[string]interface{}{}
+	for _, s := range f.states {
+		if s["entity_id"] == entityID {
+			if attrs, ok := s["attributes"].(map[string]interface{}); ok {
+				for k, v := range attrs {
+					attributes[k] = v
+				}
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if bri, ok := payload["brightness"]; ok {
+		attributes["brightness"] = bri
+	}
+	f.pushStateChange(entityID, strings.TrimPrefix(service, "turn_"), attributes)
+}
+
+func (f *fakeHA) subscriberCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers)
+}
+
+// dropConnections closes every WebSocket connection, simulating an HA restart.
+func (f *fakeHA) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.subscribers {
+		conn.Close()
+	}
+	f.subscribers = nil
+}
+
 func (f *fakeHA) lastCall() haServiceCall {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -129,14 +261,62 @@ func newTestStack(t *testing.T, ha *fakeHA, cfg *model.Config) *httptest.Server
 	}
 
 	translatorFactory := translator.NewFactory()
-	translatorFactory.Register(model.MappingTypeLight, &translator.LightStrategy{})
+	translatorFactory.Register(model.MappingTypeLight, &translator.ColorLightStrategy{})
 	translatorFactory.Register(model.MappingTypeCover, &translator.CoverStrategy{})
 	translatorFactory.Register(model.MappingTypeClimate, &translator.ClimateStrategy{})
 	translatorFactory.Register(model.MappingTypeCustom, &translator.CustomStrategy{})
+	translatorFactory.Register(model.MappingTypeFan, &translator.FanStrategy{})
+	translatorFactory.Register(model.MappingTypeMedia, &translator.MediaPlayerStrategy{})
+	translatorFactory.Register(model.MappingTypeLock, &translator.LockStrategy{})
+	translatorFactory.Register(model.MappingTypeValve, &translator.ValveStrategy{})
+	translatorFactory.Register(model.MappingTypeGarage, &translator.GarageStrategy{})
+	translatorFactory.Register(model.MappingTypeTrigger, &translator.TriggerStrategy{})
 
 	bridgeSvc := service.NewBridgeService(haClient, cfgRepo, translatorFactory)
+	// Like main, the identity is generated at startup rather than on the first read
+	if _, err := bridgeSvc.EnsureIdentity(context.Background(), nil); err != nil {
+		t.Fatalf("failed to initialize the bridge identity: %v", err)
+	}
+
+	// Push-based sync against the fake HA WebSocket API
+	haEvents := homeassistant.NewEventStream()
+	if ha != nil {
+		haEvents.Configure(ha.server.URL, "test-token")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bridgeSvc.WatchEvents(ctx, haEvents)
+
+	whitelistSvc := service.NewWhitelistService(persistence.NewJSONWhitelistRepository(filepath.Join(tmpDir, "whitelist.json")))
+	if err := whitelistSvc.EnsureLegacyUser(context.Background(), cfg != nil && len(cfg.VirtualDevices) > 0); err != nil {
+		t.Fatalf("failed to whitelist the legacy user: %v", err)
+	}
+
+	ts := serveBridge(t, httpAdapter.NewServer(bridgeSvc.ForBridge(service.PrimaryBridgeID), bridgeSvc, authService, whitelistSvc, "127.0.0.1"))
+	testWhitelists.Store(ts.URL, whitelistSvc)
+	t.Cleanup(func() { testWhitelists.Delete(ts.URL) })
+
+	// Additional bridges share the services, like in main
+	if cfg != nil && len(cfg.Bridges) > 0 {
+		instances, err := bridgeSvc.EnsureBridges(context.Background())
+		if err != nil {
+			t.Fatalf("failed to initialize bridges: %v", err)
+		}
+		for _, b := range instances {
+			instance := serveBridge(t, httpAdapter.NewServer(bridgeSvc.ForBridge(b.ID), bridgeSvc, authService, whitelistSvc, "127.0.0.1"))
+			testWhitelists.Store(instance.URL, whitelistSvc)
+			testBridges.Store(ts.URL+"#"+b.ID, instance)
+			t.Cleanup(func() {
+				testWhitelists.Delete(instance.URL)
+				testBridges.Delete(ts.URL + "#" + b.ID)
+			})
+		}
+	}
+	return ts
+}
 
-	srv := httpAdapter.NewServer(bridgeSvc, bridgeSvc, authService, "127.0.0.1")
+func serveBridge(t *testing.T, srv *httpAdapter.Server) *httptest.Server {
+	t.Helper()
 	mux := srv.Mux()
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Bypass rate limiter by using a random RemoteAddr
@@ -146,3 +326,49 @@ func newTestStack(t *testing.T, ha *fakeHA, cfg *model.Config) *httptest.Server
 	t.Cleanup(ts.Close)
 	return ts
 }
+
+// testBridges holds the additional bridges of a stack, keyed by primary URL and bridge ID.
+var testBridges sync.Map
+
+// bridgeServer returns the server of an additional bridge started by newTestStack.
+func bridgeServer(t *testing.T, ts *httptest.Server, id string) *httptest.Server {
+	t.Helper()
+	instance, ok := testBridges.Load(ts.URL + "#" + id)
+	if !ok {
+		t.Fatalf("bridge %s not started", id)
+	}
+	return instance.(*httptest.Server)
+}
+
+// testWhitelists lets tests press the link button of a stack without going through the admin API.
+var testWhitelists sync.Map
+
+// registerHueUser presses the link button, pairs a Hue API client and returns its username.
+func registerHueUser(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	svc, _ := testWhitelists.Load(ts.URL)
+	svc.(*service.WhitelistService).PressLinkButton(context.Background())
+	resp, err := http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{"devicetype":"e2e#test"}`))
+	if err != nil {
+		t.Fatalf("failed to register hue user: %v", err)
+	}
+	defer resp.Body.Close()
+	var result []map[string]map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || len(result) == 0 || result[0]["success"] == nil {
+		t.Fatalf("unexpected register response: %v", result)
+	}
+	return result[0]["success"]["username"]
+}
+
+// hueErrorType returns the type of the first Hue error in the response, or 0.
+func hueErrorType(t *testing.T, resp *http.Response) int {
+	t.Helper()
+	defer resp.Body.Close()
+	var result []map[string]struct {
+		Type int `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || len(result) == 0 {
+		return 0
+	}
+	return result[0]["error"].Type
+}
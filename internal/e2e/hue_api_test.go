@@ -4,8 +4,11 @@ package e2e_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"hue-bridge-emulator/internal/domain/model"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -17,15 +20,136 @@ func TestHueRegister(t *testing.T) {
 	ha := newFakeHA(t, nil)
 	ts := newTestStack(t, ha, nil)
 
-	resp, err := http.Post(ts.URL+"/api", "application/json",
-		strings.NewReader(`{"devicetype":"test"}`))
+	first := registerHueUser(t, ts)
+	second := registerHueUser(t, ts)
+	assert.Len(t, first, 40)
+	assert.NotEqual(t, first, second)
+
+	// Invalid registrations
+	resp, err := http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{"devicetype":`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, hueErrorType(t, resp))
+	resp, err = http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, hueErrorType(t, resp))
+	resp, err = http.Post(ts.URL+"/api", "application/json", strings.NewReader(`{"devicetype":42}`))
 	assert.NoError(t, err)
+	assert.Equal(t, 7, hueErrorType(t, resp))
 
-	assert.Equal(t, 200, resp.StatusCode)
-	var result []map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	// The whitelist is exposed in the configuration
+	resp, err = http.Get(ts.URL + "/api/" + first + "/config")
 	assert.NoError(t, err)
-	assert.Equal(t, "admin", result[0]["success"].(map[string]interface{})["username"])
+	var config map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+	resp.Body.Close()
+	whitelist := config["whitelist"].(map[string]interface{})
+	assert.Len(t, whitelist, 2)
+	assert.Equal(t, "e2e#test", whitelist[first].(map[string]interface{})["name"])
+	assert.NotEmpty(t, whitelist[first].(map[string]interface{})["create date"])
+}
+
+func TestHueLegacyUsername(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.desk", "state": "off", "attributes": map[string]interface{}{}},
+	})
+
+	// Fresh installations have no legacy user
+	ts := newTestStack(t, ha, nil)
+	resp, err := http.Get(ts.URL + "/api/admin/lights")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hueErrorType(t, resp))
+
+	// Installations upgraded with devices but no whitelist keep the "admin" pairing
+	ts = newTestStack(t, ha, &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+		},
+	})
+	resp, err = http.Get(ts.URL + "/api/admin/lights")
+	assert.NoError(t, err)
+	var lights map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&lights))
+	resp.Body.Close()
+	assert.Contains(t, lights, "1")
+
+	// New clients still pair through the link button
+	user := registerHueUser(t, ts)
+	resp, err = http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, hueErrorType(t, resp))
+}
+
+func TestHueUnauthorizedAndErrors(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.desk", "state": "off", "attributes": map[string]interface{}{}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+
+	// Unknown usernames are rejected
+	resp, err := http.Get(ts.URL + "/api/nouser/lights")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hueErrorType(t, resp))
+
+	// ...but may read the public configuration
+	resp, err = http.Get(ts.URL + "/api/nouser/config")
+	assert.NoError(t, err)
+	var config map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+	resp.Body.Close()
+	assert.Equal(t, "BSB001", config["modelid"])
+	assert.NotContains(t, config, "whitelist")
+
+	user := registerHueUser(t, ts)
+	resp, err = http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// Unknown resources
+	resp, err = http.Get(ts.URL + "/api/" + user + "/lights/99")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hueErrorType(t, resp))
+	resp, err = http.Get(ts.URL + "/api/" + user + "/sensors")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hueErrorType(t, resp))
+
+	// Wrong method
+	resp, err = http.Post(ts.URL+"/api/"+user+"/lights/1/state", "application/json", strings.NewReader(`{"on":true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, hueErrorType(t, resp))
+
+	// Invalid and unknown parameters are reported one by one, valid ones still apply
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state",
+		strings.NewReader(`{"on":true,"bri":300,"foo":1}`))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var result []map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, []map[string]map[string]interface{}{
+		{"error": {"type": float64(7), "address": "/lights/1/state/bri", "description": "invalid value, 300, for parameter, bri"}},
+		{"error": {"type": float64(6), "address": "/lights/1/state/foo", "description": "parameter, foo, not available"}},
+		{"success": {"/lights/1/state/on": true}},
+	}, result)
+	assert.Eventually(t, func() bool {
+		return ha.callCount() > 0
+	}, 1*time.Second, 50*time.Millisecond)
+	assert.NotContains(t, ha.lastCall().Payload, "brightness")
+
+	// Nothing valid, nothing sent
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{}`))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, hueErrorType(t, resp))
+	assert.Equal(t, 1, ha.callCount())
 }
 
 func TestHueGetLightsAndSetState(t *testing.T) {
@@ -52,9 +176,10 @@ func TestHueGetLightsAndSetState(t *testing.T) {
 		},
 	}
 	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
 
-	// GET /api/admin/lights
-	resp, err := http.Get(ts.URL + "/api/admin/lights")
+	// GET /api/<user>/lights
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
 	assert.NoError(t, err)
 	assert.Equal(t, 200, resp.StatusCode)
 	var lights map[string]interface{}
@@ -62,8 +187,8 @@ func TestHueGetLightsAndSetState(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, lights, "1")
 
-	// PUT /api/admin/lights/1/state  → should call HA turn_on
-	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/admin/lights/1/state",
+	// PUT /api/<user>/lights/1/state  → should call HA turn_on
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state",
 		strings.NewReader(`{"on":true,"bri":200}`))
 	assert.NoError(t, err)
 	_, err = http.DefaultClient.Do(req)
@@ -80,3 +205,659 @@ func TestHueGetLightsAndSetState(t *testing.T) {
 	assert.Equal(t, "light.living_room", call.Payload["entity_id"])
 	assert.Equal(t, float64(200), call.Payload["brightness"])
 }
+
+func TestHueGroups(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.sofa", "state": "on", "attributes": map[string]interface{}{"brightness": 100.0}},
+		{"entity_id": "switch.tv_lamp", "state": "off", "attributes": map[string]interface{}{}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Sofa", EntityID: "light.sofa", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "TV Lamp", EntityID: "switch.tv_lamp", Type: model.MappingTypeLight},
+		},
+		VirtualGroups: []*model.VirtualGroup{
+			{ID: "1", Name: "Living Room", Type: "Room", Class: "Living room", Lights: []string{"1", "2"}},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	// GET /api/<user>/groups
+	resp, err := http.Get(ts.URL + "/api/" + user + "/groups")
+	assert.NoError(t, err)
+	var groups map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&groups))
+	assert.Equal(t, "Living Room", groups["1"]["name"])
+	assert.Equal(t, map[string]interface{}{"any_on": true, "all_on": false}, groups["1"]["state"])
+
+	// Groups are part of the full state
+	resp, err = http.Get(ts.URL + "/api/" + user)
+	assert.NoError(t, err)
+	var full map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&full))
+	assert.Contains(t, full["groups"], "1")
+
+	// PUT /api/<user>/groups/1/action fans out to every member
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/groups/1/action", strings.NewReader(`{"on":false}`))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	assert.Eventually(t, func() bool {
+		return ha.callCount() == 2
+	}, 1*time.Second, 50*time.Millisecond)
+
+	resp, err = http.Get(ts.URL + "/api/" + user + "/groups/1")
+	assert.NoError(t, err)
+	var group map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&group))
+	assert.Equal(t, map[string]interface{}{"any_on": false, "all_on": false}, group["state"])
+
+	// Unknown group
+	resp, err = http.Get(ts.URL + "/api/" + user + "/groups/42")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hueErrorType(t, resp))
+}
+
+func TestHueColorAndTransition(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{
+			"entity_id": "light.desk",
+			"state":     "on",
+			"attributes": map[string]interface{}{
+				"brightness": 100.0,
+				"color_mode": "hs",
+				"hs_color":   []interface{}{0.0, 100.0},
+			},
+		},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	// Listing lights triggers the initial refresh from HA
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, "hs", state["colormode"])
+	assert.Equal(t, float64(254), state["sat"])
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state",
+		strings.NewReader(`{"ct":370,"bri_inc":20,"transitiontime":20}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var result []map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Len(t, result, 3)
+
+	assert.Eventually(t, func() bool {
+		return ha.callCount() > 0
+	}, 1*time.Second, 50*time.Millisecond)
+
+	call := ha.lastCall()
+	assert.Equal(t, "turn_on", call.Service)
+	assert.Equal(t, float64(120), call.Payload["brightness"])
+	assert.Equal(t, float64(2703), call.Payload["color_temp_kelvin"])
+	assert.Equal(t, float64(2), call.Payload["transition"])
+	assert.NotContains(t, call.Payload, "hs_color")
+}
+
+func TestHuePartialCommands(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.desk", "state": "off", "attributes": map[string]interface{}{}},
+		{"entity_id": "light.shelf", "state": "on", "attributes": map[string]interface{}{"brightness": 100.0, "color_temp_kelvin": 4000.0, "color_mode": "color_temp"}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "Shelf", EntityID: "light.shelf", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	put := func(url, body string) {
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Alerts, effects and transitions alone do not turn a light on, nor a group
+	put(ts.URL+"/api/"+user+"/lights/1/state", `{"alert":"select"}`)
+	put(ts.URL+"/api/"+user+"/groups/0/action", `{"transitiontime":10}`)
+	assert.Equal(t, false, getLightState(t, ts.URL+"/api/"+user+"/lights/1")["on"])
+	assert.Equal(t, true, getLightState(t, ts.URL+"/api/"+user+"/lights/2")["on"])
+
+	// Zero increments keep the brightness and colour temperature
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/2")
+	put(ts.URL+"/api/"+user+"/lights/2/state", `{"bri_inc":0,"ct_inc":0}`)
+	after := getLightState(t, ts.URL+"/api/"+user+"/lights/2")
+	assert.Equal(t, state["bri"], after["bri"])
+	assert.Equal(t, state["ct"], after["ct"])
+	assert.Equal(t, true, after["on"])
+
+	// A colour loop can be stopped
+	put(ts.URL+"/api/"+user+"/lights/2/state", `{"effect":"none"}`)
+	assert.Eventually(t, func() bool { return ha.lastCall().Payload["effect"] == "off" }, time.Second, 10*time.Millisecond)
+
+	// An explicit "on": false wins over brightness
+	put(ts.URL+"/api/"+user+"/lights/2/state", `{"on":false,"bri":10}`)
+	assert.Equal(t, false, getLightState(t, ts.URL+"/api/"+user+"/lights/2")["on"])
+	assert.Eventually(t, func() bool { return ha.lastCall().Service == "turn_off" }, time.Second, 10*time.Millisecond)
+}
+
+func TestHueColorCapabilities(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{
+			"entity_id": "light.strip",
+			"state":     "on",
+			"attributes": map[string]interface{}{
+				"supported_color_modes": []interface{}{"hs"},
+				"color_mode":            "hs",
+				"hs_color":              []interface{}{120.0, 100.0},
+			},
+		},
+		{
+			"entity_id": "light.ceiling",
+			"state":     "on",
+			"attributes": map[string]interface{}{
+				"supported_color_modes": []interface{}{"color_temp"},
+				"min_color_temp_kelvin": 2200.0,
+				"max_color_temp_kelvin": 6500.0,
+			},
+		},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Strip", EntityID: "light.strip", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "Ceiling", EntityID: "light.ceiling", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	var lights map[string]map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&lights))
+	resp.Body.Close()
+	assert.Equal(t, "Extended color light", lights["1"]["type"])
+	assert.Equal(t, "Color temperature light", lights["2"]["type"])
+
+	// Colour requests are converted to a mode the light supports
+	setState := func(id, body string) map[string]interface{} {
+		before := ha.callCount()
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/"+id+"/state", strings.NewReader(body))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Eventually(t, func() bool { return ha.callCount() > before }, time.Second, 10*time.Millisecond)
+		return ha.lastCall().Payload
+	}
+	payload := setState("1", `{"xy":[0.6915,0.3083]}`)
+	assert.Equal(t, []interface{}{0.0, 100.0}, payload["hs_color"])
+	assert.NotContains(t, payload, "xy_color")
+
+	payload = setState("2", `{"ct":500}`)
+	assert.Equal(t, float64(2200), payload["color_temp_kelvin"])
+}
+
+func TestHueFan(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{
+			"entity_id": "fan.ceiling",
+			"state":     "on",
+			"attributes": map[string]interface{}{
+				"percentage":      50.0,
+				"percentage_step": 25.0,
+			},
+		},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Ceiling Fan", EntityID: "fan.ceiling", Type: model.MappingTypeFan},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, float64(127), state["bri"])
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"bri":200}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Eventually(t, func() bool { return ha.callCount() > 0 }, time.Second, 10*time.Millisecond)
+	call := ha.lastCall()
+	assert.Equal(t, "fan", call.Domain)
+	assert.Equal(t, "set_percentage", call.Service)
+	assert.Equal(t, float64(75), call.Payload["percentage"])
+}
+
+func TestHueMediaPlayer(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{
+			"entity_id":  "media_player.tv",
+			"state":      "playing",
+			"attributes": map[string]interface{}{"volume_level": 0.5},
+		},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "TV", EntityID: "media_player.tv", Type: model.MappingTypeMedia},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, true, state["on"])
+	assert.Equal(t, float64(127), state["bri"])
+
+	// "Alexa, set TV to 30%"
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"bri":76}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Eventually(t, func() bool { return ha.callCount() > 0 }, time.Second, 10*time.Millisecond)
+	call := ha.lastCall()
+	assert.Equal(t, "media_player", call.Domain)
+	assert.Equal(t, "volume_set", call.Service)
+	assert.Equal(t, 0.3, call.Payload["volume_level"])
+}
+
+func TestHueLockSafeguard(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "lock.front_door", "state": "locked"},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Front Door", EntityID: "lock.front_door", Type: model.MappingTypeLock},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// "Alexa, turn off Front Door" must not unlock it
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"on":false}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, true, state["on"])
+
+	// Locking goes through
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"on":true}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Eventually(t, func() bool { return ha.callCount() > 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, ha.callCount())
+	call := ha.lastCall()
+	assert.Equal(t, "lock", call.Domain)
+	assert.Equal(t, "lock", call.Service)
+}
+
+func TestHueTrigger(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "script.bedtime", "state": "off"},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Bedtime", EntityID: "script.bedtime", Type: model.MappingTypeTrigger,
+				ActionConfig: &model.ActionConfig{TriggerResetDelay: 1}},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"on":true}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Eventually(t, func() bool { return ha.callCount() > 0 }, time.Second, 10*time.Millisecond)
+	call := ha.lastCall()
+	assert.Equal(t, "script", call.Domain)
+	assert.Equal(t, "turn_on", call.Service)
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, true, state["on"])
+
+	// Reported off again so it can be triggered again
+	assert.Eventually(t, func() bool {
+		return getLightState(t, ts.URL+"/api/"+user+"/lights/1")["on"] == false
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestHueMultiEntityDevice(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.left", "state": "off", "attributes": map[string]interface{}{}},
+		{"entity_id": "light.right", "state": "on", "attributes": map[string]interface{}{"brightness": 254.0}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Bedside Lamps", EntityID: "light.left", Type: model.MappingTypeLight,
+				Targets: []*model.DeviceTarget{{EntityID: "light.right", Payload: map[string]interface{}{"transition": 2}}}},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	// On when any lamp is on
+	state := getLightState(t, ts.URL+"/api/"+user+"/lights/1")
+	assert.Equal(t, true, state["on"])
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state", strings.NewReader(`{"on":false}`))
+	assert.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Eventually(t, func() bool { return ha.callCount() == 2 }, time.Second, 10*time.Millisecond)
+	ha.mu.Lock()
+	calls := map[interface{}]haServiceCall{}
+	for _, c := range ha.calls {
+		calls[c.Payload["entity_id"]] = c
+	}
+	ha.mu.Unlock()
+	assert.Equal(t, "turn_off", calls["light.left"].Service)
+	assert.Equal(t, "turn_off", calls["light.right"].Service)
+	assert.Equal(t, float64(2), calls["light.right"].Payload["transition"])
+}
+
+func TestBridgeIdentity(t *testing.T) {
+	ts := newTestStack(t, newFakeHA(t, nil), nil)
+
+	resp, err := http.Get(ts.URL + "/api/nouser/config")
+	assert.NoError(t, err)
+	var config map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+	resp.Body.Close()
+
+	// A fresh installation gets its own identity instead of the historical hardcoded one
+	mac := config["mac"].(string)
+	bridgeID := config["bridgeid"].(string)
+	assert.NotEqual(t, "001788FFFE102201", bridgeID)
+	serial := strings.ReplaceAll(mac, ":", "")
+	assert.Equal(t, strings.ToUpper(serial[:6]+"fffe"+serial[6:]), bridgeID)
+
+	resp, err = http.Get(ts.URL + "/description.xml")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "<serialNumber>"+serial+"</serialNumber>")
+	assert.Contains(t, string(body), "<UDN>uuid:2f402f80-da50-11e1-9b23-"+serial+"</UDN>")
+
+	// The identity is stable
+	resp, err = http.Get(ts.URL + "/api/nouser/config")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+	resp.Body.Close()
+	assert.Equal(t, bridgeID, config["bridgeid"])
+}
+
+func TestBridgeIdentity_Regenerate(t *testing.T) {
+	ts := newTestStack(t, newFakeHA(t, nil), nil)
+	http.Post(ts.URL+"/admin/setup", "application/x-www-form-urlencoded",
+		strings.NewReader("username=admin&password=password123"))
+
+	bridgeID := func() string {
+		resp, err := http.Get(ts.URL + "/api/nouser/config")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var config map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+		return config["bridgeid"].(string)
+	}
+	admin := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.SetBasicAuth("admin", "password123")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	original := bridgeID()
+
+	// Edits through the config are dropped
+	resp := admin(http.MethodPost, "/admin/config", `{"identity":{"mac":"00:17:88:00:00:01","bridge_id":"001788FFFE000001"}}`)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, original, bridgeID())
+
+	resp = admin(http.MethodGet, "/admin/identity/regenerate", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp = admin(http.MethodPost, "/admin/identity/regenerate", "")
+	var identity model.BridgeIdentity
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+	resp.Body.Close()
+	assert.NotEqual(t, original, identity.BridgeID)
+	assert.Equal(t, identity.BridgeID, bridgeID())
+}
+
+func TestMultipleBridges(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.sofa", "state": "on", "attributes": map[string]interface{}{"brightness": 255}},
+		{"entity_id": "light.desk", "state": "off", "attributes": map[string]interface{}{}},
+		{"entity_id": "light.porch", "state": "off", "attributes": map[string]interface{}{}},
+	})
+	cfg := &model.Config{
+		HassURL:             ha.server.URL,
+		HassToken:           "test-token",
+		MaxDevicesPerBridge: 1,
+		Bridges:             []*model.BridgeInstance{{ID: "2", IP: "127.0.0.2"}, {ID: "3", IP: "127.0.0.3"}},
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Sofa", EntityID: "light.sofa", Type: model.MappingTypeLight},
+			{HueID: "2", Name: "Desk", EntityID: "light.desk", Type: model.MappingTypeLight},
+			{HueID: "3", Name: "Porch", EntityID: "light.porch", Type: model.MappingTypeLight, BridgeID: "2"},
+		},
+		VirtualGroups: []*model.VirtualGroup{{ID: "1", Name: "Living Room", Lights: []string{"1", "2"}}},
+	}
+	primary := newTestStack(t, ha, cfg)
+	second, third := bridgeServer(t, primary, "2"), bridgeServer(t, primary, "3")
+
+	lightNames := func(ts *httptest.Server) []string {
+		user := registerHueUser(t, ts)
+		resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var lights map[string]map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&lights))
+		var names []string
+		for _, l := range lights {
+			names = append(names, l["name"].(string))
+		}
+		return names
+	}
+
+	// The explicit assignment fills the second bridge, the overflow goes to the third one
+	assert.Equal(t, []string{"Sofa"}, lightNames(primary))
+	assert.Equal(t, []string{"Porch"}, lightNames(second))
+	assert.Equal(t, []string{"Desk"}, lightNames(third))
+
+	// Each bridge has its own identity
+	bridgeIDs := map[string]bool{}
+	for _, ts := range []*httptest.Server{primary, second, third} {
+		resp, err := http.Get(ts.URL + "/api/nouser/config")
+		assert.NoError(t, err)
+		var config map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+		resp.Body.Close()
+		bridgeIDs[config["bridgeid"].(string)] = true
+	}
+	assert.Len(t, bridgeIDs, 3)
+
+	// Devices of another bridge are unknown
+	user := registerHueUser(t, second)
+	resp, err := http.Get(second.URL + "/api/" + user + "/lights/1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hueErrorType(t, resp))
+
+	// Groups only switch their members on the bridge serving the request
+	req, _ := http.NewRequest(http.MethodPut, third.URL+"/api/"+registerHueUser(t, third)+"/groups/1/action", strings.NewReader(`{"on":true}`))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Eventually(t, func() bool { return ha.callCount() == 1 }, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "light.desk", ha.lastCall().Payload["entity_id"])
+}
+
+func TestHueReachability(t *testing.T) {
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.ok", "state": "on", "attributes": map[string]interface{}{"brightness": 200.0}},
+		{"entity_id": "light.offline", "state": "unavailable", "attributes": map[string]interface{}{}},
+		{"entity_id": "cover.ok", "state": "open", "attributes": map[string]interface{}{"current_position": 50.0}},
+		{"entity_id": "cover.offline", "state": "unknown", "attributes": map[string]interface{}{}},
+		{"entity_id": "climate.ok", "state": "heat", "attributes": map[string]interface{}{"temperature": 21.0}},
+		{"entity_id": "climate.offline", "state": "unavailable", "attributes": map[string]interface{}{}},
+		{"entity_id": "input_number.ok", "state": "10", "attributes": map[string]interface{}{"value": 10.0}},
+		{"entity_id": "input_number.offline", "state": "unknown", "attributes": map[string]interface{}{}},
+	})
+	cfg := &model.Config{HassURL: ha.server.URL, HassToken: "test-token"}
+	types := []model.MappingType{model.MappingTypeLight, model.MappingTypeCover, model.MappingTypeClimate, model.MappingTypeCustom}
+	entities := []string{"light", "cover", "climate", "input_number"}
+	expected := map[string]bool{}
+	id := 1
+	for i, mappingType := range types {
+		for _, suffix := range []string{"ok", "offline"} {
+			cfg.VirtualDevices = append(cfg.VirtualDevices, &model.VirtualDevice{
+				HueID: fmt.Sprint(id), Name: entities[i] + " " + suffix, EntityID: entities[i] + "." + suffix, Type: mappingType,
+			})
+			expected[fmt.Sprint(id)] = suffix == "ok"
+			id++
+		}
+	}
+	// An entity removed from HA
+	cfg.VirtualDevices = append(cfg.VirtualDevices, &model.VirtualDevice{HueID: "9", Name: "Removed", EntityID: "light.removed", Type: model.MappingTypeLight})
+	expected["9"] = false
+
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	var lights map[string]struct {
+		Name  string `json:"name"`
+		State struct {
+			Reachable bool `json:"reachable"`
+		} `json:"state"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&lights))
+	resp.Body.Close()
+
+	assert.Len(t, lights, len(expected))
+	for id, reachable := range expected {
+		assert.Equal(t, reachable, lights[id].State.Reachable, lights[id].Name)
+	}
+
+}
+
+func TestHueUnavailableEntities(t *testing.T) {
+	entities := map[model.MappingType]string{
+		model.MappingTypeLight:   "light.test",
+		model.MappingTypeCover:   "cover.test",
+		model.MappingTypeClimate: "climate.test",
+		model.MappingTypeCustom:  "input_number.test",
+		model.MappingTypeFan:     "fan.test",
+		model.MappingTypeMedia:   "media_player.test",
+		model.MappingTypeLock:    "lock.test",
+		model.MappingTypeValve:   "valve.test",
+		model.MappingTypeGarage:  "cover.garage",
+		model.MappingTypeTrigger: "script.test",
+	}
+	for _, state := range []string{model.HAStateUnavailable, model.HAStateUnknown} {
+		t.Run(state, func(t *testing.T) {
+			var states []map[string]interface{}
+			cfg := &model.Config{}
+			for mappingType, entityID := range entities {
+				states = append(states, map[string]interface{}{"entity_id": entityID, "state": state, "attributes": map[string]interface{}{}})
+				cfg.VirtualDevices = append(cfg.VirtualDevices, &model.VirtualDevice{
+					HueID: fmt.Sprint(len(cfg.VirtualDevices) + 1), Name: string(mappingType), EntityID: entityID, Type: mappingType,
+					ActionConfig: &model.ActionConfig{ToHueFormula: "x * 2"},
+				})
+			}
+			ha := newFakeHA(t, states)
+			cfg.HassURL, cfg.HassToken = ha.server.URL, "test-token"
+			ts := newTestStack(t, ha, cfg)
+			user := registerHueUser(t, ts)
+
+			resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+			assert.NoError(t, err)
+			var lights map[string]struct {
+				Name  string `json:"name"`
+				State struct {
+					On        bool `json:"on"`
+					Reachable bool `json:"reachable"`
+				} `json:"state"`
+			}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&lights))
+			resp.Body.Close()
+
+			assert.Len(t, lights, len(entities))
+			for _, light := range lights {
+				assert.False(t, light.State.Reachable, light.Name)
+				assert.False(t, light.State.On, light.Name)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ func TestIntegrationScenario(t *testing.T) {
 		{"entity_id": "light.bedroom", "state": "off", "attributes": map[string]interface{}{"friendly_name": "Bedroom"}},
 	})
 	ts := newTestStack(t, ha, nil)
+	user := registerHueUser(t, ts)
 
 	// Step 1: Initial setup
 	client := &http.Client{
@@ -55,7 +56,7 @@ func TestIntegrationScenario(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Step 4: Verify device exists in Hue API
-	resp, err = http.Get(ts.URL + "/api/admin/lights")
+	resp, err = http.Get(ts.URL + "/api/" + user + "/lights")
 	assert.NoError(t, err)
 	assert.Equal(t, 200, resp.StatusCode)
 	var lights map[string]interface{}
@@ -63,7 +64,7 @@ func TestIntegrationScenario(t *testing.T) {
 	assert.Contains(t, lights, "1")
 
 	// Step 5: Send Alexa command via Hue API
-	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/api/admin/lights/1/state",
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/api/"+user+"/lights/1/state",
 		strings.NewReader(`{"on":true,"bri":128}`))
 	resp, err = http.DefaultClient.Do(req)
 	assert.NoError(t, err)
@@ -0,0 +1,70 @@
+//go:build e2e
+
+package e2e_test
+
+import (
+	"encoding/json"
+	"hue-bridge-emulator/internal/adapters/output/homeassistant"
+	"hue-bridge-emulator/internal/domain/model"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getLightState(t *testing.T, url string) map[string]interface{} {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var light map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&light); err != nil {
+		return nil
+	}
+	state, _ := light["state"].(map[string]interface{})
+	return state
+}
+
+func TestHAWebSocketStateSync(t *testing.T) {
+	oldBackoff := homeassistant.ReconnectMinBackoff
+	homeassistant.ReconnectMinBackoff = 10 * time.Millisecond
+	defer func() { homeassistant.ReconnectMinBackoff = oldBackoff }()
+
+	ha := newFakeHA(t, []map[string]interface{}{
+		{"entity_id": "light.kitchen", "state": "off", "attributes": map[string]interface{}{"friendly_name": "Kitchen"}},
+	})
+	cfg := &model.Config{
+		HassURL:   ha.server.URL,
+		HassToken: "test-token",
+		VirtualDevices: []*model.VirtualDevice{
+			{HueID: "1", Name: "Kitchen", EntityID: "light.kitchen", Type: model.MappingTypeLight},
+		},
+	}
+	ts := newTestStack(t, ha, cfg)
+	user := registerHueUser(t, ts)
+	lightURL := ts.URL + "/api/" + user + "/lights/1"
+
+	// Initial state comes from the REST API
+	resp, err := http.Get(ts.URL + "/api/" + user + "/lights")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, false, getLightState(t, lightURL)["on"])
+	assert.Eventually(t, func() bool { return ha.subscriberCount() > 0 }, 2*time.Second, 10*time.Millisecond)
+
+	// A pushed state_changed event is applied without waiting for a refresh
+	ha.pushStateChange("light.kitchen", "on", map[string]interface{}{"brightness": 180.0})
+	assert.Eventually(t, func() bool {
+		state := getLightState(t, lightURL)
+		return state["on"] == true && state["bri"] == 180.0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// After a disconnection the stream reconnects and resynchronises missed changes
+	ha.dropConnections()
+	ha.pushStateChange("light.kitchen", "off", map[string]interface{}{})
+	assert.Eventually(t, func() bool {
+		return ha.subscriberCount() > 0 && getLightState(t, lightURL)["on"] == false
+	}, 2*time.Second, 10*time.Millisecond)
+}
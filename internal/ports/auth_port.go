@@ -3,6 +3,7 @@ package ports
 import (
 	"context"
 	"hue-bridge-emulator/internal/domain/model"
+	"time"
 )
 
 type AuthPort interface {
@@ -16,3 +17,21 @@ type AuthService interface {
 	CreateCredentials(ctx context.Context, username, password string) error
 	Exists() bool
 }
+
+// WhitelistPort persists the Hue API users
+type WhitelistPort interface {
+	Get(ctx context.Context) ([]*model.HueUser, error)
+	Save(ctx context.Context, users []*model.HueUser) error
+	Exists() bool
+}
+
+// WhitelistService registers and authorizes Hue API users
+type WhitelistService interface {
+	Register(ctx context.Context, deviceType string) (*model.HueUser, error)
+	Authorize(ctx context.Context, username string) (bool, error)
+	EnsureLegacyUser(ctx context.Context, paired bool) error
+	GetUsers(ctx context.Context) ([]*model.HueUser, error)
+	DeleteUser(ctx context.Context, username string) error
+	PressLinkButton(ctx context.Context) time.Time
+	LinkButtonPressed(ctx context.Context) bool
+}
@@ -15,6 +15,15 @@ type Translator = translator.Translator
 
 type TranslatorFactory = translator.TranslatorFactory
 
+type EntityMetadataProvider = translator.EntityMetadataProvider
+
+type CommandGuard = translator.CommandGuard
+
+type MomentaryTranslator = translator.MomentaryTranslator
+
+type ConfigValidator = translator.ConfigValidator
+
+type StatefulTranslator = translator.StatefulTranslator
 
 // HueEmulationPort defines the interface for Hue protocol emulation
 type HueEmulationPort interface {
@@ -22,6 +31,10 @@ type HueEmulationPort interface {
 	GetDevice(ctx context.Context, id string) (*model.Device, error)
 	GetDeviceMetadata(deviceType model.MappingType) model.HueMetadata
 	UpdateDeviceState(ctx context.Context, id string, state *model.DeviceState) error
+	GetGroups(ctx context.Context) ([]*model.Group, error)
+	GetGroup(ctx context.Context, id string) (*model.Group, error)
+	UpdateGroupState(ctx context.Context, id string, state *model.DeviceState) error
+	GetBridgeIdentity(ctx context.Context) (*model.BridgeIdentity, error)
 }
 
 // AdminPort defines the interface for administrative tasks
@@ -30,12 +43,20 @@ type AdminPort interface {
 	UpdateConfig(ctx context.Context, cfg *model.Config) error
 	GetAllEntities(ctx context.Context) ([]HomeAssistantEntity, error)
 	TestDeviceAction(ctx context.Context, vd *model.VirtualDevice, state *model.DeviceState) error
+	PreviewTranslation(ctx context.Context, vd *model.VirtualDevice, haState *model.HAEntityState, hueState *model.DeviceState, sweep bool) (*model.TranslationPreview, error)
+	GetCommands(ctx context.Context, filter model.CommandFilter) []model.CommandRecord
+	GetStateMismatches(ctx context.Context) []model.StateMismatch
+	RegenerateIdentity(ctx context.Context) (*model.BridgeIdentity, error)
 }
 
 
 type HomeAssistantPort interface {
 	GetRawStates(ctx context.Context) ([]model.HAEntityState, error)
-	SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) error
+	// SetState calls the service of cmd and returns the HTTP status Home Assistant answered with,
+	// 0 when it did not answer
+	SetState(ctx context.Context, device *model.Device, cmd model.HomeAssistantCommand) (int, error)
+	// Available is false while Home Assistant is known to be down
+	Available() bool
 }
 
 // ReconfigurableHomeAssistantPort defines an interface for HomeAssistant ports that can be reconfigured at runtime
@@ -48,3 +69,14 @@ type ReconfigurableHomeAssistantPort interface {
 type Reconfigurable interface {
 	Configure(url, token string)
 }
+
+// StateChangeHandler receives single entity state changes pushed by Home Assistant
+type StateChangeHandler interface {
+	ApplyStateChange(ctx context.Context, state model.HAEntityState)
+}
+
+// HomeAssistantEventsPort streams Home Assistant state changes to a handler until the context is cancelled
+type HomeAssistantEventsPort interface {
+	Reconfigurable
+	Run(ctx context.Context, handler StateChangeHandler)
+}